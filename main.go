@@ -0,0 +1,92 @@
+// Command syslog bundles the syslog server, client and anomaly detector as
+// subcommands of a single binary.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"syslog/syslog_anomaly"
+	"syslog/syslog_client"
+	"syslog/syslog_server"
+)
+
+const usage = `Usage: syslog <command> [flags]
+
+Commands:
+  server   run the syslog server, web UI and REST API
+  send     send syslog messages over UDP or TCP
+  anomaly  detect anomalies in a syslog file
+  version  print the build version
+
+Run 'syslog <command> -help' for the flags of a command.`
+
+// Build information, injected at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    string
+	buildTime string
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		var found *syslog_anomaly.AnomaliesFoundError
+		if errors.As(err, &found) {
+			os.Exit(found.Code)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", usage)
+	}
+	syslog_server.Build = buildInfo()
+	switch args[0] {
+	case "version":
+		info := syslog_server.Build
+		fmt.Printf("syslog %s (commit %s, built %s, %s)\n", info.Version, info.Commit, info.BuildTime, info.GoVersion)
+		return nil
+	case "server":
+		return syslog_server.Run(args[1:])
+	case "send":
+		return syslog_client.Run(args[1:])
+	case "anomaly":
+		return syslog_anomaly.Run(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], usage)
+	}
+}
+
+// buildInfo returns the injected build information, falling back to the VCS
+// details the go command embeds when they were not injected.
+func buildInfo() syslog_server.BuildInfo {
+	info := syslog_server.BuildInfo{Version: version, Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
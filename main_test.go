@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"syslog/syslog_anomaly"
+	"syslog/syslog_server"
+)
+
+func TestRunUnknownCommand(t *testing.T) {
+	if err := run(nil); err == nil {
+		t.Error("expected usage error without a command")
+	}
+	if err := run([]string{"bogus"}); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("expected unknown command error, got %v", err)
+	}
+}
+
+func TestRunSend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := run([]string{"send", "-a", conn.LocalAddr().String(), "-f", "1", "-s", "3", "-m", "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<11>") || !strings.HasSuffix(msg, "syslog_client: hello") {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+func TestRunAnomaly(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		json.NewEncoder(w).Encode(syslog_anomaly.CompletionResponse{})
+	}))
+	defer srv.Close()
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("OPENAI_API_URL", srv.URL)
+
+	input := filepath.Join(t.TempDir(), "syslog.txt")
+	if err := os.WriteFile(input, []byte("Jan 1 00:00:00 host app: hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"anomaly", "-i", input}); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 LLM request, got %d", requests)
+	}
+}
+
+func TestRunServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiAddr := ln.Addr().String()
+	ln.Close()
+
+	err = run([]string{"server", "-a", "127.0.0.1:notaport", "-w", apiAddr})
+	if err == nil || !strings.Contains(err.Error(), "UDP address") {
+		t.Errorf("expected the server to report the invalid address, got %v", err)
+	}
+	// The web server started before the failure is shut down with it, so it
+	// does not leak into later tests.
+	ln, err = net.Listen("tcp", apiAddr)
+	if err != nil {
+		t.Fatalf("expected the web server address to be released: %v", err)
+	}
+	ln.Close()
+}
+
+func TestBuildInfo(t *testing.T) {
+	defer func(v, c, b string) { version, commit, buildTime = v, c, b }(version, commit, buildTime)
+	version, commit, buildTime = "v1.2.3", "abc123", "2026-01-02T03:04:05Z"
+	info := buildInfo()
+	if info.Version != "v1.2.3" || info.Commit != "abc123" || info.BuildTime != "2026-01-02T03:04:05Z" || info.GoVersion == "" {
+		t.Errorf("unexpected build info %+v", info)
+	}
+	if err := run([]string{"version"}); err != nil {
+		t.Fatal(err)
+	}
+	if syslog_server.Build != info {
+		t.Errorf("expected run to publish the build info to the server, got %+v", syslog_server.Build)
+	}
+}
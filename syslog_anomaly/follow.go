@@ -0,0 +1,174 @@
+package syslog_anomaly
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// fileTailer reads the lines appended to a log file, like tail -F. It
+// starts at the end of the file and follows the path when the file is
+// rotated, or starts over when it is truncated.
+type fileTailer struct {
+	path    string
+	file    *os.File
+	offset  int64
+	partial []byte
+}
+
+// newFileTailer opens path and skips its current content. A file that does
+// not exist yet is picked up once it is created.
+func newFileTailer(path string) (*fileTailer, error) {
+	t := &fileTailer{path: path}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.offset, err = file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+	t.file = file
+	return t, nil
+}
+
+// poll returns the complete lines appended since the last poll. A last line
+// without a newline is held back until it is completed.
+func (t *fileTailer) poll() ([]string, error) {
+	var lines []string
+	fi, err := os.Stat(t.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if t.file != nil {
+		current, statErr := t.file.Stat()
+		switch {
+		case statErr != nil || fi == nil || !os.SameFile(current, fi):
+			// Rotated: finish the old file, then move to the new one.
+			if lines, err = t.read(); err != nil {
+				return nil, err
+			}
+			lines = append(lines, t.flushPartial()...)
+			t.file.Close()
+			t.file, t.offset = nil, 0
+		case fi.Size() < t.offset:
+			// Truncated in place, for example by copytruncate.
+			t.partial = nil
+			if t.offset, err = t.file.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if t.file == nil {
+		if fi == nil {
+			return lines, nil
+		}
+		if t.file, err = os.Open(t.path); err != nil {
+			return nil, err
+		}
+	}
+	more, err := t.read()
+	return append(lines, more...), err
+}
+
+// read reads from the current offset to the end of the file.
+func (t *fileTailer) read() ([]string, error) {
+	data, err := io.ReadAll(t.file)
+	if err != nil {
+		return nil, err
+	}
+	t.offset += int64(len(data))
+	data = append(t.partial, data...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		t.partial = data
+		return nil, nil
+	}
+	t.partial = append([]byte(nil), data[end+1:]...)
+	return removeEmptyStrings(strings.Split(string(data[:end]), "\n")), nil
+}
+
+func (t *fileTailer) flushPartial() []string {
+	line := string(t.partial)
+	t.partial = nil
+	return removeEmptyStrings([]string{line})
+}
+
+func (t *fileTailer) close() {
+	if t.file != nil {
+		t.file.Close()
+	}
+}
+
+// follow tails the file every interval and, when new lines were appended,
+// analyzes the last window lines, printing the anomalies of at least
+// minConfidence not already found by the previous analysis. Analysis errors are reported on errOut and
+// following continues. It returns when done is closed.
+func follow(config LLMConfig, tailer *fileTailer, interval time.Duration, window int,
+	minConfidence float64, format string, w, errOut io.Writer, done <-chan struct{}) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lines []string
+	reported := map[string]bool{}
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return nil
+		}
+		added, err := tailer.poll()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", tailer.path, err)
+		}
+		if len(added) == 0 {
+			continue
+		}
+		lines = append(lines, added...)
+		if len(lines) > window {
+			lines = append(lines[:0], lines[len(lines)-window:]...)
+		}
+		anomalies, err := FindAnomalies(config, lines)
+		if err != nil {
+			fmt.Fprintf(errOut, "error analyzing syslog messages: %v\n", err)
+			continue
+		}
+		anomalies = FilterByConfidence(anomalies, minConfidence)
+		// An anomaly stays in the window for a while; print it once.
+		found := make(map[string]bool, len(anomalies))
+		for _, anomaly := range anomalies {
+			found[anomaly.Message] = true
+			if reported[anomaly.Message] {
+				continue
+			}
+			if err := writeAnomaly(w, format, anomaly); err != nil {
+				return err
+			}
+		}
+		reported = found
+	}
+}
+
+// writeAnomaly prints a single anomaly, as a line of text or of JSON.
+func writeAnomaly(w io.Writer, format string, anomaly Anomaly) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(anomaly)
+	}
+	label := anomaly.Severity
+	if anomaly.Confidence != nil {
+		label += fmt.Sprintf(", %.2f", *anomaly.Confidence)
+	}
+	var err error
+	if anomaly.Reason != "" {
+		_, err = fmt.Fprintf(w, "[%s] %s (%s)\n", label, anomaly.Message, anomaly.Reason)
+	} else {
+		_, err = fmt.Fprintf(w, "[%s] %s\n", label, anomaly.Message)
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package syslog_anomaly
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a strings.Builder safe to read while follow writes to it.
+type syncBuffer struct {
+	mu sync.Mutex
+	sb strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.String()
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		io.WriteString(f, line+"\n")
+	}
+}
+
+func TestFileTailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syslog.log")
+	appendLines(t, path, "old line")
+	tailer, err := newFileTailer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tailer.close()
+	poll := func(want ...string) {
+		t.Helper()
+		got, err := tailer.poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("poll() = %q, want %q", got, want)
+		}
+	}
+
+	// The existing content is skipped and a partial line held back.
+	poll()
+	appendLines(t, path, "one")
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	io.WriteString(f, "tw")
+	f.Close()
+	poll("one")
+	appendLines(t, path, "o")
+	poll("two")
+
+	// Rotation: the rest of the old file, then the new file from the start.
+	appendLines(t, path, "last before rotation")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path, "first after rotation")
+	poll("last before rotation", "first after rotation")
+
+	// Truncation in place starts over.
+	if err := os.WriteFile(path, []byte("after truncate\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	poll("after truncate")
+}
+
+func TestFollowAnalyzesNewLines(t *testing.T) {
+	var mu sync.Mutex
+	var analyzed [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req CompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		_, joined, _ := strings.Cut(string(req.Messages[0].Content), "Syslog messages:\\n")
+		lines := strings.Split(joined, "\n ")
+		mu.Lock()
+		analyzed = append(analyzed, lines)
+		mu.Unlock()
+		anomalies := []Anomaly{}
+		for _, line := range lines {
+			if strings.Contains(line, "panic") {
+				anomalies = append(anomalies, Anomaly{Message: line, Reason: "crash", Severity: "critical"})
+			}
+		}
+		content, _ := json.Marshal(anomalies)
+		json.NewEncoder(w).Encode(CompletionResponse{
+			Choices: []Choice{{Message: Message{Role: "assistant", Content: MessageContent(content)}}},
+		})
+	}))
+	defer srv.Close()
+	analyses := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(analyzed)
+	}
+
+	path := filepath.Join(t.TempDir(), "syslog.log")
+	appendLines(t, path, "Jan 1 00:00:00 host kernel: panic from before following")
+	tailer, err := newFileTailer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tailer.close()
+	var out, errOut syncBuffer
+	done := make(chan struct{})
+	stopped := make(chan error)
+	go func() {
+		stopped <- follow(LLMConfig{URL: srv.URL, Model: "test"}, tailer, 10*time.Millisecond, 3, 0, "text", &out, &errOut, done)
+	}()
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s; output:\n%s%s", what, out.String(), errOut.String())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	appendLines(t, path, "Jan 1 00:00:01 host app: ok", "Jan 1 00:00:02 host kernel: panic: out of memory")
+	waitFor("the first analysis", func() bool { return strings.Contains(out.String(), "panic: out of memory") })
+	for i := 3; i < 8; i++ {
+		appendLines(t, path, fmt.Sprintf("Jan 1 00:00:0%d host app: ok %d", i, i))
+		n := analyses()
+		waitFor("another analysis", func() bool { return analyses() > n })
+	}
+	close(done)
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := out.String(); got != "[critical] Jan 1 00:00:02 host kernel: panic: out of memory (crash)\n" {
+		t.Errorf("expected the anomaly printed once and nothing from before following, got:\n%s", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if last := analyzed[len(analyzed)-1]; len(last) != 3 || last[2] != "Jan 1 00:00:07 host app: ok 7" {
+		t.Errorf("expected the last analysis to cover the 3 most recent lines, got %q", last)
+	}
+}
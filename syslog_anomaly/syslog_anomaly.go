@@ -1,15 +1,25 @@
-package main
+// Package syslog_anomaly detects anomalous syslog messages using an OpenAI
+// API compatible LLM.
+package syslog_anomaly
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type SyslogMessages struct {
@@ -17,62 +27,267 @@ type SyslogMessages struct {
 }
 
 type CompletionRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string         `json:"role"`
+	Content MessageContent `json:"content"`
+	Refusal string         `json:"refusal,omitempty"`
+}
+
+// MessageContent is the text of a message. Some endpoints return content as
+// an array of parts instead of a string; the text parts are concatenated.
+type MessageContent string
+
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*c = ""
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = MessageContent(text)
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("content is neither a string nor an array of parts: %w", err)
+	}
+	var sb strings.Builder
+	for _, part := range parts {
+		if part.Type == "text" || part.Type == "output_text" {
+			sb.WriteString(part.Text)
+		}
+	}
+	*c = MessageContent(sb.String())
+	return nil
 }
 
 type CompletionResponse struct {
-	ID      string   `json:"id"`
-	Choices []Choice `json:"choices"`
+	ID      string    `json:"id"`
+	Choices []Choice  `json:"choices"`
+	Error   *APIError `json:"error,omitempty"`
+}
+
+// APIError is the error object returned in the body of a failed request.
+type APIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    any    `json:"code,omitempty"`
 }
 
 type Choice struct {
 	Message Message `json:"message"`
 }
 
+// Anomaly is a single anomalous syslog message reported by the LLM.
+type Anomaly struct {
+	Message  string `json:"message"`
+	Reason   string `json:"reason"`
+	Severity string `json:"severity"`
+	// Confidence is how sure the LLM is, from 0 to 1, or nil if it did not
+	// say.
+	Confidence *float64 `json:"confidence,omitempty"`
+}
+
 type LLMConfig struct {
-	apiKey string
-	model  string
-	url    string
+	// Provider names a preset in Providers; empty means openai.
+	Provider       string
+	APIKey         string
+	Model          string
+	URL            string
+	PromptTemplate string
+	Temperature    *float64
+	MaxTokens      int
+	MaxRetries     int
+	RetryDelay     time.Duration
+}
+
+// Provider is an OpenAI API compatible service: its default chat
+// completions URL and model, and the header that carries the API key.
+type Provider struct {
+	URL        string
+	Model      string
+	AuthHeader string
+	AuthPrefix string
+}
+
+// Providers are the presets selected with OPENAI_PROVIDER. Azure OpenAI has
+// no default URL or model, as the URL names the resource and deployment,
+// e.g. https://NAME.openai.azure.com/openai/deployments/DEPLOYMENT/chat/completions?api-version=2024-06-01.
+var Providers = map[string]Provider{
+	"openai": {
+		URL:        "https://api.openai.com/v1/chat/completions",
+		Model:      "gpt-3.5-turbo",
+		AuthHeader: "Authorization",
+		AuthPrefix: "Bearer ",
+	},
+	"azure": {
+		AuthHeader: "api-key",
+	},
+	"ollama": {
+		URL:        "http://localhost:11434/v1/chat/completions",
+		Model:      "llama3",
+		AuthHeader: "Authorization",
+		AuthPrefix: "Bearer ",
+	},
+	"together": {
+		URL:        "https://api.together.xyz/v1/chat/completions",
+		Model:      "meta-llama/Llama-3-8b-chat-hf",
+		AuthHeader: "Authorization",
+		AuthPrefix: "Bearer ",
+	},
+}
+
+// LookupProvider returns the preset called name, or openai if name is empty.
+func LookupProvider(name string) (Provider, error) {
+	if name == "" {
+		name = "openai"
+	}
+	provider, ok := Providers[strings.ToLower(name)]
+	if !ok {
+		return Provider{}, fmt.Errorf("unknown LLM provider %q, use openai, azure, ollama or together", name)
+	}
+	return provider, nil
+}
+
+// WithDefaults returns config with the URL and model of its provider filled
+// in where they are not set.
+func (config LLMConfig) WithDefaults() (LLMConfig, error) {
+	provider, err := LookupProvider(config.Provider)
+	if err != nil {
+		return config, err
+	}
+	if config.URL == "" {
+		config.URL = provider.URL
+	}
+	if config.Model == "" {
+		config.Model = provider.Model
+	}
+	if config.URL == "" {
+		return config, fmt.Errorf("OPENAI_API_URL is required for the %s provider", config.Provider)
+	}
+	return config, nil
+}
+
+// LLMConfigFromEnv reads the LLM settings from the OPENAI_* environment
+// variables, applying the defaults of the OPENAI_PROVIDER preset for the URL
+// and model, and a default for retries.
+func LLMConfigFromEnv() (LLMConfig, error) {
+	config, err := LLMConfig{
+		Provider:       os.Getenv("OPENAI_PROVIDER"),
+		APIKey:         os.Getenv("OPENAI_API_KEY"),
+		URL:            os.Getenv("OPENAI_API_URL"),
+		Model:          os.Getenv("OPENAI_MODEL"),
+		PromptTemplate: os.Getenv("OPENAI_PROMPT"),
+		MaxRetries:     3,
+	}.WithDefaults()
+	if err != nil {
+		return config, err
+	}
+	if temperature := os.Getenv("OPENAI_TEMPERATURE"); temperature != "" {
+		t, err := strconv.ParseFloat(temperature, 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid OPENAI_TEMPERATURE %q: %w", temperature, err)
+		}
+		config.Temperature = &t
+	}
+	if maxTokens := os.Getenv("OPENAI_MAX_TOKENS"); maxTokens != "" {
+		n, err := strconv.Atoi(maxTokens)
+		if err != nil {
+			return config, fmt.Errorf("invalid OPENAI_MAX_TOKENS %q: %w", maxTokens, err)
+		}
+		config.MaxTokens = n
+	}
+	if maxRetries := os.Getenv("OPENAI_MAX_RETRIES"); maxRetries != "" {
+		n, err := strconv.Atoi(maxRetries)
+		if err != nil || n < 0 {
+			return config, fmt.Errorf("invalid OPENAI_MAX_RETRIES %q", maxRetries)
+		}
+		config.MaxRetries = n
+	}
+	return config, nil
+}
+
+// defaultRetryDelay is the initial backoff between LLM request attempts.
+const defaultRetryDelay = time.Second
+
+// maxRetryDelay caps the backoff between attempts, including a delay asked
+// for with Retry-After.
+var maxRetryDelay = 30 * time.Second
+
+// defaultPromptTemplate is used when no prompt is configured. The
+// {messages} placeholder is replaced by the syslog messages to analyze.
+const defaultPromptTemplate = `	Given a list of syslog messages, respond only with a JSON array of the anomalous
+							syslog messages. Each element must be an object with the fields "message" (the
+							original syslog message), "reason" (why it is anomalous), "severity" (one of
+							"low", "medium", "high" or "critical") and "confidence" (a number from 0 to 1, how
+							sure you are that it is anomalous). Respond with [] if there are no anomalies.
+							Syslog messages:\n{messages}`
+
+// buildPrompt expands the prompt template with the messages. A template
+// without the {messages} placeholder gets the messages appended.
+func buildPrompt(promptTemplate string, messages []string) string {
+	if promptTemplate == "" {
+		promptTemplate = defaultPromptTemplate
+	}
+	joined := strings.Join(messages, "\n ")
+	if strings.Contains(promptTemplate, "{messages}") {
+		return strings.ReplaceAll(promptTemplate, "{messages}", joined)
+	}
+	return promptTemplate + "\n" + joined
+}
+
+// FindAnomalies asks the LLM which of the messages are anomalous.
+func FindAnomalies(config LLMConfig, messages []string) ([]Anomaly, error) {
+	return FindAnomaliesContext(context.Background(), config, messages)
 }
 
-func findAnomalies(config LLMConfig, messages []string) ([]string, error) {
+// FindAnomaliesContext is FindAnomalies with a context that cancels the
+// request and the backoff between retries.
+func FindAnomaliesContext(ctx context.Context, config LLMConfig, messages []string) ([]Anomaly, error) {
 	requestBody := CompletionRequest{
-		Model: config.model,
+		Model: config.Model,
 		Messages: []Message{
 			{
-				Role: "user",
-				Content: `	Given a list of syslog messages, respond only with lines of text
-							that start with ANOMALIES: and followed by lines of anomalous syslog messages.
-							Syslog messages:\n` + strings.Join(messages, "\n "),
+				Role:    "user",
+				Content: MessageContent(buildPrompt(config.PromptTemplate, messages)),
 			},
 		},
+		Temperature: config.Temperature,
+		MaxTokens:   config.MaxTokens,
 	}
-	apiKey := config.apiKey
-	url := config.url
+	provider, err := LookupProvider(config.Provider)
+	if err != nil {
+		return nil, err
+	}
+	apiKey := config.APIKey
+	url := config.URL
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	if apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set(provider.AuthHeader, provider.AuthPrefix+apiKey)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doWithRetry(client, req, config.MaxRetries, config.RetryDelay)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -81,63 +296,403 @@ func findAnomalies(config LLMConfig, messages []string) ([]string, error) {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 	var completionResponse CompletionResponse
-	if err := json.Unmarshal(body, &completionResponse); err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
+	err = json.Unmarshal(body, &completionResponse)
+	if resp.StatusCode >= 400 {
+		message := strings.TrimSpace(string(body))
+		if err == nil && completionResponse.Error != nil {
+			message = completionResponse.Error.Message
+		}
+		return nil, &StatusError{StatusCode: resp.StatusCode, Message: message}
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
+	if completionResponse.Error != nil {
+		return nil, fmt.Errorf("LLM API error: %s", completionResponse.Error.Message)
+	}
 
-	anomalyReport := "ANOMALIES:"
-	anomalies := []string{}
+	anomalies := []Anomaly{}
+	var refusal string
 	for _, choice := range completionResponse.Choices {
-		idx := strings.Index(choice.Message.Content, anomalyReport)
-		if idx == 0 {
-			anomalies = strings.Split(choice.Message.Content[len(anomalyReport):], "\n")
-			anomalies = removeEmptyStrings(anomalies)
-			break
+		if choice.Message.Content == "" && choice.Message.Refusal != "" {
+			refusal = choice.Message.Refusal
+			continue
 		}
+		if parsed, ok := parseAnomalies(string(choice.Message.Content)); ok {
+			return DedupAnomalies(parsed), nil
+		}
+	}
+	if refusal != "" {
+		return nil, fmt.Errorf("LLM refused the request: %s", refusal)
 	}
-	fmt.Println("anomalies", len(anomalies))
+	return DedupAnomalies(anomalies), nil
+}
 
-	return anomalies, nil
+// doWithRetry sends req, retrying network errors, 429 and 5xx responses up to
+// maxRetries times with exponential backoff and jitter. A Retry-After header
+// on the response takes precedence over the computed backoff. Delays are
+// capped at maxRetryDelay, and waiting stops when the request context is
+// done.
+func doWithRetry(client *http.Client, req *http.Request, maxRetries int, baseDelay time.Duration) (*http.Response, error) {
+	if baseDelay <= 0 {
+		baseDelay = defaultRetryDelay
+	}
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to reset request body: %w", err)
+			}
+			req.Body = body
+		}
+		resp, err := client.Do(req)
+		delay := retryDelay(baseDelay, attempt)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to send request: %w", err)
+		} else if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("request failed with status %d", resp.StatusCode)
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				delay = min(retryAfter, maxRetryDelay)
+			}
+			resp.Body.Close()
+		} else {
+			return resp, nil
+		}
+		if attempt < maxRetries {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, fmt.Errorf("LLM request abandoned after %d attempts: %w", attempt+1, req.Context().Err())
+			}
+		}
+	}
+	return nil, fmt.Errorf("LLM request failed after %d attempts: %w", maxRetries+1, lastErr)
+}
+
+// retryDelay is the backoff after attempt: baseDelay doubled for each
+// earlier attempt plus jitter, at most maxRetryDelay.
+func retryDelay(baseDelay time.Duration, attempt int) time.Duration {
+	delay := baseDelay
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay+time.Duration(rand.Int63n(int64(baseDelay))), maxRetryDelay)
 }
 
-func main() {
-	inputFilePtr := flag.String("i", "", "Path to the syslog file")
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
 
-	flag.Parse()
+// parseAnomalies decodes the JSON array requested in the prompt. If the model
+// ignored the format and answered with plain "ANOMALIES:" lines, those are
+// returned with an unknown severity instead.
+func parseAnomalies(content string) ([]Anomaly, bool) {
+	content = strings.TrimSpace(content)
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimSuffix(content, "```")
+	content = strings.TrimSpace(content)
 
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	url := os.Getenv("OPENAI_API_URL")
-	model := os.Getenv("OPENAI_MODEL")
+	var anomalies []Anomaly
+	if err := json.Unmarshal([]byte(content), &anomalies); err == nil {
+		return anomalies, true
+	}
 
-	if apiKey == "" {
-		log.Fatal("Please provide an API key using env var OPENAI_API_KEY")
+	anomalyReport := "ANOMALIES:"
+	if !strings.HasPrefix(content, anomalyReport) {
+		return nil, false
 	}
-	if url == "" {
-		url = "https://api.openai.com/v1/chat/completions"
+	for _, line := range removeEmptyStrings(strings.Split(content[len(anomalyReport):], "\n")) {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			anomalies = append(anomalies, Anomaly{Message: line, Severity: "unknown"})
+		}
 	}
-	if model == "" {
-		model = "gpt-3.5-turbo"
+	return anomalies, true
+}
+
+// severityRank orders anomaly severities from most to least severe.
+func severityRank(severity string) int {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return 4
+	case "high":
+		return 3
+	case "medium":
+		return 2
+	case "low":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// DedupAnomalies drops repeated messages, keeping the highest severity seen,
+// and sorts the result from most to least severe.
+func DedupAnomalies(anomalies []Anomaly) []Anomaly {
+	result := []Anomaly{}
+	index := map[string]int{}
+	for _, anomaly := range anomalies {
+		if anomaly.Message == "" {
+			continue
+		}
+		if i, ok := index[anomaly.Message]; ok {
+			if severityRank(anomaly.Severity) > severityRank(result[i].Severity) {
+				result[i] = anomaly
+			}
+			continue
+		}
+		index[anomaly.Message] = len(result)
+		result = append(result, anomaly)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return severityRank(result[i].Severity) > severityRank(result[j].Severity)
+	})
+	return result
+}
+
+// FilterByConfidence drops the anomalies the LLM is less than min confident
+// about. Anomalies without a confidence are kept, as is everything when min
+// is 0.
+func FilterByConfidence(anomalies []Anomaly, min float64) []Anomaly {
+	if min <= 0 {
+		return anomalies
+	}
+	var result []Anomaly
+	for _, anomaly := range anomalies {
+		if anomaly.Confidence == nil || *anomaly.Confidence >= min {
+			result = append(result, anomaly)
+		}
+	}
+	return result
+}
+
+// Report is the result of an anomaly run as emitted by -o json.
+type Report struct {
+	InputCount   int       `json:"input_count"`
+	AnomalyCount int       `json:"anomaly_count"`
+	Anomalies    []Anomaly `json:"anomalies"`
+}
+
+// maxStatusErrorMessage bounds how much of an error response is shown, as
+// some gateways answer with whole HTML pages.
+const maxStatusErrorMessage = 200
+
+// StatusError is returned by FindAnomalies when the LLM API answers with an
+// HTTP error status. Message is the API's error message, or the response
+// body if it has none.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	message := e.Message
+	if len(message) > maxStatusErrorMessage {
+		message = strings.ToValidUTF8(message[:maxStatusErrorMessage], "") + "..."
+	}
+	if e.AuthFailed() {
+		return fmt.Sprintf("LLM authentication failed (%d), check the API key: %s", e.StatusCode, message)
+	}
+	return fmt.Sprintf("LLM request failed with status %d: %s", e.StatusCode, message)
+}
+
+// AuthFailed reports whether the API rejected the API key.
+func (e *StatusError) AuthFailed() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// AnomaliesFoundError is returned by Run when anomalies were found and
+// -exit-code is non-zero, so the caller can exit with Code.
+type AnomaliesFoundError struct {
+	Count int
+	Code  int
+}
+
+func (e *AnomaliesFoundError) Error() string {
+	return fmt.Sprintf("%d anomalies found", e.Count)
+}
+
+// Run implements the anomaly subcommand.
+func Run(args []string) error {
+	flags := flag.NewFlagSet("anomaly", flag.ContinueOnError)
+	inputFilePtr := flags.String("i", "", "Path to the syslog file, a directory or a glob of (optionally gzipped) log files")
+	output := flags.String("o", "text", "Output format: 'text' or 'json'")
+	exitCode := flags.Int("exit-code", 0, "Exit code to use when anomalies are found (0 to always exit successfully)")
+	followFile := flags.Bool("follow", false, "Keep reading lines appended to the -i file, like tail -F, and print anomalies as they are found (one JSON object per line with -o json)")
+	interval := flags.Duration("interval", 30*time.Second, "With -follow, how often to analyze the new lines")
+	window := flags.Int("window", 500, "With -follow, how many of the most recent lines to analyze")
+	minConfidence := flags.Float64("minconfidence", 0, "Discard anomalies the LLM is less confident about than this, from 0 to 1 (0 keeps all)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unsupported output format: %s. Use 'text' or 'json'", *output)
+	}
+	if *minConfidence < 0 || *minConfidence > 1 {
+		return fmt.Errorf("-minconfidence must be between 0 and 1, got %v", *minConfidence)
+	}
+
+	config, err := LLMConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	if config.APIKey == "" {
+		return fmt.Errorf("please provide an API key using env var OPENAI_API_KEY")
 	}
 	if *inputFilePtr == "" {
-		log.Fatal("Please provide an input file using the -i flag.")
+		return fmt.Errorf("please provide an input file using the -i flag")
+	}
+
+	if *followFile {
+		if *interval <= 0 || *window <= 0 {
+			return fmt.Errorf("-interval and -window must be positive")
+		}
+		tailer, err := newFileTailer(*inputFilePtr)
+		if err != nil {
+			return fmt.Errorf("error opening input file: %w", err)
+		}
+		defer tailer.close()
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		done := make(chan struct{})
+		go func() {
+			<-signals
+			close(done)
+		}()
+		return follow(config, tailer, *interval, *window, *minConfidence, *output, os.Stdout, os.Stderr, done)
+	}
+
+	messages, err := readMessages(*inputFilePtr)
+	if err != nil {
+		return fmt.Errorf("error reading input file: %w", err)
+	}
+	anomalies, err := FindAnomalies(config, messages)
+	if err != nil {
+		return fmt.Errorf("error analyzing syslog messages: %w", err)
+	}
+	anomalies = FilterByConfidence(anomalies, *minConfidence)
+	if err := writeReport(os.Stdout, *output, len(messages), anomalies); err != nil {
+		return err
+	}
+	if len(anomalies) > 0 && *exitCode != 0 {
+		return &AnomaliesFoundError{Count: len(anomalies), Code: *exitCode}
 	}
+	return nil
+}
 
-	fileContent, err := os.ReadFile(*inputFilePtr)
+// readMessages returns the non-empty lines of the file, directory or glob
+// at input. Files ending in .gz are decompressed and multiple files are read
+// oldest first, so rotated logs are analyzed in chronological order.
+func readMessages(input string) ([]string, error) {
+	files, err := inputFiles(input)
 	if err != nil {
-		log.Fatalf("Error reading input file: %v", err)
+		return nil, err
+	}
+	var messages []string
+	for _, file := range files {
+		content, err := readLogFile(file)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, removeEmptyStrings(strings.Split(string(content), "\n"))...)
+	}
+	return messages, nil
+}
+
+// inputFiles expands input into a list of files sorted by modification time.
+func inputFiles(input string) ([]string, error) {
+	var files []string
+	if fi, err := os.Stat(input); err == nil && fi.IsDir() {
+		entries, err := os.ReadDir(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				files = append(files, filepath.Join(input, entry.Name()))
+			}
+		}
+	} else if err == nil {
+		return []string{input}, nil
+	} else {
+		files, err = filepath.Glob(input)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no files match %s", input)
+		}
 	}
 
-	messages := strings.Split(string(fileContent), "\n")
-	messages = removeEmptyStrings(messages)
-	config := LLMConfig{apiKey: apiKey, url: url, model: model}
-	anomalies, err := findAnomalies(config, messages)
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = fi.ModTime()
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if !modTimes[files[i]].Equal(modTimes[files[j]]) {
+			return modTimes[files[i]].Before(modTimes[files[j]])
+		}
+		return files[i] < files[j]
+	})
+	return files, nil
+}
+
+// readLogFile reads a log file, decompressing it if it ends in .gz.
+func readLogFile(filename string) ([]byte, error) {
+	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("Error analyzing syslog messages: %v", err)
+		return nil, err
 	}
+	defer file.Close()
+	if !strings.HasSuffix(filename, ".gz") {
+		return io.ReadAll(file)
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
+
+// writeReport prints the anomalies in the given output format.
+func writeReport(w io.Writer, format string, inputCount int, anomalies []Anomaly) error {
+	if format == "json" {
+		report := Report{InputCount: inputCount, AnomalyCount: len(anomalies), Anomalies: anomalies}
+		if report.Anomalies == nil {
+			report.Anomalies = []Anomaly{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	fmt.Fprintln(w, "anomalies", len(anomalies))
 	for _, anomaly := range anomalies {
-		fmt.Println(anomaly)
+		if err := writeAnomaly(w, format, anomaly); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func removeEmptyStrings(s []string) []string {
@@ -0,0 +1,416 @@
+package syslog_anomaly
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mockLLMServer(t *testing.T, content string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(CompletionResponse{
+			Choices: []Choice{{Message: Message{Role: "assistant", Content: MessageContent(content)}}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFindAnomaliesStructured(t *testing.T) {
+	srv := mockLLMServer(t, "```json\n"+`[
+		{"message": "Jan 1 00:00:01 web-01 sshd: login ok", "reason": "odd hour", "severity": "low"},
+		{"message": "Jan 1 00:00:02 db-01 kernel: disk failure", "reason": "hardware", "severity": "critical"},
+		{"message": "Jan 1 00:00:01 web-01 sshd: login ok", "reason": "odd hour", "severity": "medium"}
+	]`+"\n```")
+	anomalies, err := FindAnomalies(LLMConfig{URL: srv.URL, Model: "test"}, []string{"<13>Jan 1 00:00:00 host app: msg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) != 2 {
+		t.Fatalf("expected 2 deduplicated anomalies, got %d: %+v", len(anomalies), anomalies)
+	}
+	if anomalies[0].Severity != "critical" || anomalies[1].Severity != "medium" {
+		t.Errorf("expected anomalies sorted by severity with the highest duplicate kept, got %+v", anomalies)
+	}
+}
+
+func TestFilterByConfidence(t *testing.T) {
+	srv := mockLLMServer(t, `[
+		{"message": "Jan 1 00:00:01 db-01 kernel: disk failure", "reason": "hardware", "severity": "critical", "confidence": 0.95},
+		{"message": "Jan 1 00:00:02 web-01 cron: job took 2s", "reason": "slow", "severity": "low", "confidence": 0.3},
+		{"message": "Jan 1 00:00:03 web-01 sshd: root login", "reason": "security", "severity": "high"}
+	]`)
+	anomalies, err := FindAnomalies(LLMConfig{URL: srv.URL, Model: "test"}, []string{"<13>Jan 1 00:00:00 host app: msg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FilterByConfidence(anomalies, 0); len(got) != 3 {
+		t.Errorf("expected the default threshold to keep all anomalies, got %+v", got)
+	}
+	got := FilterByConfidence(anomalies, 0.5)
+	if len(got) != 2 || got[0].Reason != "hardware" || got[1].Reason != "security" {
+		t.Errorf("expected the low-confidence anomaly to be dropped and the unscored one kept, got %+v", got)
+	}
+	var out bytes.Buffer
+	writeAnomaly(&out, "text", got[0])
+	if want := "[critical, 0.95] Jan 1 00:00:01 db-01 kernel: disk failure (hardware)\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestFindAnomaliesPlainTextFallback(t *testing.T) {
+	srv := mockLLMServer(t, "ANOMALIES:\nJan 1 00:00:02 db-01 kernel: disk failure\n")
+	anomalies, err := FindAnomalies(LLMConfig{URL: srv.URL, Model: "test"}, []string{"<13>Jan 1 00:00:00 host app: msg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Message != "Jan 1 00:00:02 db-01 kernel: disk failure" || anomalies[0].Severity != "unknown" {
+		t.Errorf("unexpected fallback anomalies %+v", anomalies)
+	}
+}
+
+func TestFindAnomaliesRetriesRateLimit(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(CompletionResponse{
+			Choices: []Choice{{Message: Message{Content: `[{"message":"m","reason":"r","severity":"high"}]`}}},
+		})
+	}))
+	defer srv.Close()
+
+	config := LLMConfig{URL: srv.URL, MaxRetries: 3, RetryDelay: time.Millisecond}
+	anomalies, err := FindAnomalies(config, []string{"<13>Jan 1 00:00:00 host app: hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || len(anomalies) != 1 {
+		t.Errorf("expected success on the third attempt, got %d attempts and %d anomalies", attempts, len(anomalies))
+	}
+
+	attempts = -10
+	config.MaxRetries = 1
+	if _, err := FindAnomalies(config, []string{"x"}); err == nil || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Errorf("expected an error after exhausting retries, got %v", err)
+	}
+}
+
+func TestRetryDelayIsCapped(t *testing.T) {
+	for _, attempt := range []int{0, 1, 10, 63, 64, 1000} {
+		if delay := retryDelay(time.Second, attempt); delay <= 0 || delay > maxRetryDelay {
+			t.Errorf("attempt %d: delay %v out of (0, %v]", attempt, delay, maxRetryDelay)
+		}
+	}
+	if delay := retryDelay(time.Hour, 0); delay != maxRetryDelay {
+		t.Errorf("expected a large base delay to be capped, got %v", delay)
+	}
+}
+
+func TestFindAnomaliesRetryAfterCapAndCancel(t *testing.T) {
+	defer func(d time.Duration) { maxRetryDelay = d }(maxRetryDelay)
+	maxRetryDelay = 50 * time.Millisecond
+
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(CompletionResponse{})
+	}))
+	defer srv.Close()
+
+	config := LLMConfig{URL: srv.URL, MaxRetries: 1}
+	start := time.Now()
+	if _, err := FindAnomalies(config, []string{"x"}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected Retry-After to be capped, waited %v", elapsed)
+	}
+
+	// Canceling the context stops waiting between attempts.
+	maxRetryDelay = time.Hour
+	attempts = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err := FindAnomaliesContext(ctx, config, []string{"x"})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("expected the backoff to end with the context, got %v after %v", err, time.Since(start))
+	}
+}
+
+func TestFindAnomaliesConfiguredRequest(t *testing.T) {
+	var got CompletionRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(CompletionResponse{})
+	}))
+	defer srv.Close()
+
+	temperature := 0.2
+	config := LLMConfig{URL: srv.URL, Model: "test", PromptTemplate: "Find odd lines:\n{messages}", Temperature: &temperature, MaxTokens: 256}
+	if _, err := FindAnomalies(config, []string{"host app: hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Temperature == nil || *got.Temperature != 0.2 || got.MaxTokens != 256 {
+		t.Errorf("expected temperature 0.2 and max_tokens 256, got %v and %d", got.Temperature, got.MaxTokens)
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Content != "Find odd lines:\nhost app: hello" {
+		t.Errorf("unexpected prompt %+v", got.Messages)
+	}
+
+	if prompt := buildPrompt("", []string{"x"}); !strings.Contains(prompt, "Given a list of syslog messages") {
+		t.Errorf("empty template should fall back to the default prompt, got %q", prompt)
+	}
+}
+
+func TestFindAnomaliesProviderAuth(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		json.NewEncoder(w).Encode(CompletionResponse{})
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		provider, header, value string
+	}{
+		{"", "Authorization", "Bearer secret"},
+		{"openai", "Authorization", "Bearer secret"},
+		{"together", "Authorization", "Bearer secret"},
+		{"azure", "Api-Key", "secret"},
+	} {
+		config := LLMConfig{Provider: tt.provider, APIKey: "secret", URL: srv.URL}
+		if _, err := FindAnomalies(config, []string{"host app: hello"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := header.Get(tt.header); got != tt.value {
+			t.Errorf("provider %q: %s header %q, want %q", tt.provider, tt.header, got, tt.value)
+		}
+		if tt.provider == "azure" && header.Get("Authorization") != "" {
+			t.Errorf("azure requests must not send a bearer token, got %q", header.Get("Authorization"))
+		}
+	}
+
+	if _, err := FindAnomalies(LLMConfig{Provider: "bogus", URL: srv.URL}, []string{"x"}); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
+
+func TestFindAnomaliesAuthFailure(t *testing.T) {
+	for _, tt := range []struct {
+		status      int
+		contentType string
+		body        string
+		want        string
+		auth        bool
+	}{
+		{http.StatusUnauthorized, "application/json",
+			`{"error": {"message": "Incorrect API key provided: sk-bad", "type": "invalid_request_error"}}`,
+			"LLM authentication failed (401), check the API key: Incorrect API key provided: sk-bad", true},
+		{http.StatusForbidden, "text/plain", "forbidden\n",
+			"LLM authentication failed (403), check the API key: forbidden", true},
+		{http.StatusBadRequest, "application/json", `{"error": {"message": "model not found"}}`,
+			"LLM request failed with status 400: model not found", false},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contentType)
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		}))
+		_, err := FindAnomalies(LLMConfig{APIKey: "sk-bad", URL: srv.URL}, []string{"host app: hello"})
+		srv.Close()
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status || statusErr.AuthFailed() != tt.auth {
+			t.Errorf("status %d: expected a StatusError, got %v", tt.status, err)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("status %d: got %q, want %q", tt.status, err, tt.want)
+		}
+	}
+}
+
+func TestLLMConfigFromEnvProvider(t *testing.T) {
+	t.Setenv("OPENAI_PROVIDER", "ollama")
+	t.Setenv("OPENAI_API_URL", "")
+	t.Setenv("OPENAI_MODEL", "")
+	config, err := LLMConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.URL != "http://localhost:11434/v1/chat/completions" || config.Model != "llama3" {
+		t.Errorf("expected the ollama defaults, got %q and %q", config.URL, config.Model)
+	}
+
+	t.Setenv("OPENAI_MODEL", "mistral")
+	if config, err := LLMConfigFromEnv(); err != nil || config.Model != "mistral" {
+		t.Errorf("expected OPENAI_MODEL to override the preset, got %q, %v", config.Model, err)
+	}
+
+	t.Setenv("OPENAI_PROVIDER", "azure")
+	if _, err := LLMConfigFromEnv(); err == nil {
+		t.Error("expected an error for azure without OPENAI_API_URL")
+	}
+	azureURL := "https://example.openai.azure.com/openai/deployments/gpt4/chat/completions?api-version=2024-06-01"
+	t.Setenv("OPENAI_API_URL", azureURL)
+	if config, err := LLMConfigFromEnv(); err != nil || config.URL != azureURL {
+		t.Errorf("expected the azure URL, got %q, %v", config.URL, err)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	anomalies := []Anomaly{
+		{Message: "Jan 1 00:00:02 db-01 kernel: disk failure", Reason: "hardware", Severity: "critical"},
+		{Message: "Jan 1 00:00:03 web-01 app: odd", Severity: "unknown"},
+	}
+
+	var text strings.Builder
+	if err := writeReport(&text, "text", 5, anomalies); err != nil {
+		t.Fatal(err)
+	}
+	want := "anomalies 2\n" +
+		"[critical] Jan 1 00:00:02 db-01 kernel: disk failure (hardware)\n" +
+		"[unknown] Jan 1 00:00:03 web-01 app: odd\n"
+	if text.String() != want {
+		t.Errorf("text report = %q, want %q", text.String(), want)
+	}
+
+	var out strings.Builder
+	if err := writeReport(&out, "json", 5, anomalies); err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal([]byte(out.String()), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.InputCount != 5 || report.AnomalyCount != 2 || report.Anomalies[0].Reason != "hardware" {
+		t.Errorf("unexpected JSON report %+v", report)
+	}
+
+	out.Reset()
+	if err := writeReport(&out, "json", 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"anomalies": []`) {
+		t.Errorf("expected an empty anomalies array, got %s", out.String())
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	srv := mockLLMServer(t, `[{"message": "Jan 1 00:00:02 db-01 kernel: disk failure", "reason": "hardware", "severity": "high"}]`)
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("OPENAI_API_URL", srv.URL)
+	input := filepath.Join(t.TempDir(), "syslog.txt")
+	if err := os.WriteFile(input, []byte("Jan 1 00:00:02 db-01 kernel: disk failure\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Run([]string{"-i", input, "-o", "json"}); err != nil {
+		t.Errorf("expected success without -exit-code, got %v", err)
+	}
+	err := Run([]string{"-i", input, "-exit-code", "3"})
+	var found *AnomaliesFoundError
+	if !errors.As(err, &found) || found.Code != 3 || found.Count != 1 {
+		t.Errorf("expected AnomaliesFoundError with code 3, got %v", err)
+	}
+	if err := Run([]string{"-i", input, "-o", "xml"}); err == nil {
+		t.Error("expected an error for an unsupported output format")
+	}
+}
+
+func TestReadMessagesFromRotatedLogs(t *testing.T) {
+	dir := t.TempDir()
+	rotated := filepath.Join(dir, "syslog-2024-01-01T00-00-00.000.log.gz")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("Jan 1 00:00:00 host app: first\nJan 1 00:00:01 host app: second\n"))
+	gz.Close()
+	if err := os.WriteFile(rotated, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(dir, "syslog.log")
+	if err := os.WriteFile(current, []byte("Jan 1 00:00:02 host app: third\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(rotated, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"Jan 1 00:00:00 host app: first",
+		"Jan 1 00:00:01 host app: second",
+		"Jan 1 00:00:02 host app: third",
+	}
+	for _, input := range []string{dir, filepath.Join(dir, "syslog*")} {
+		messages, err := readMessages(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(messages, "|") != strings.Join(want, "|") {
+			t.Errorf("readMessages(%s) = %q, want %q", input, messages, want)
+		}
+	}
+	if _, err := readMessages(filepath.Join(dir, "*.missing")); err == nil {
+		t.Error("expected an error when the glob matches nothing")
+	}
+}
+
+func TestFindAnomaliesResponseShapes(t *testing.T) {
+	respond := func(status int, body string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			io.WriteString(w, body)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	messages := []string{"Jan 1 00:00:00 host app: msg"}
+
+	parts := respond(http.StatusOK, `{"choices":[{"message":{"role":"assistant","content":[
+		{"type":"text","text":"[{\"message\": \"m\", "},
+		{"type":"text","text":"\"reason\": \"r\", \"severity\": \"high\"}]"}]}}]}`)
+	anomalies, err := FindAnomalies(LLMConfig{URL: parts.URL, Model: "test"}, messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Message != "m" || anomalies[0].Severity != "high" {
+		t.Errorf("expected the text parts to be joined, got %+v", anomalies)
+	}
+
+	refusal := respond(http.StatusOK, `{"choices":[{"message":{"role":"assistant","content":null,"refusal":"I can't help with that."}}]}`)
+	if _, err := FindAnomalies(LLMConfig{URL: refusal.URL, Model: "test"}, messages); err == nil || !strings.Contains(err.Error(), "I can't help with that.") {
+		t.Errorf("expected the refusal to be reported, got %v", err)
+	}
+
+	apiError := respond(http.StatusUnauthorized, `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`)
+	if _, err := FindAnomalies(LLMConfig{URL: apiError.URL, Model: "test"}, messages); err == nil || !strings.Contains(err.Error(), "Incorrect API key provided") {
+		t.Errorf("expected the API error message, got %v", err)
+	}
+
+	plain := respond(http.StatusBadRequest, `bad request`)
+	if _, err := FindAnomalies(LLMConfig{URL: plain.URL, Model: "test"}, messages); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Errorf("expected the status to be reported, got %v", err)
+	}
+}
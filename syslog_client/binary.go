@@ -0,0 +1,132 @@
+package syslog_client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BinaryMagic starts every frame of the compact binary encoding. It is
+// neither a digit nor printable ASCII, so a server can tell binary frames
+// from octet counted and LF terminated text ones.
+const BinaryMagic byte = 0xB5
+
+// ErrBinaryTooLarge is returned by ReadBinary for a frame over the size
+// limit. The frame is skipped, so the next one can still be read.
+var ErrBinaryTooLarge = errors.New("binary frame exceeds the maximum size")
+
+// BinaryMessage is a syslog message in the compact binary encoding. A zero
+// Timestamp with no Host and App marks a Message that is a complete syslog
+// message without its priority, as sent with SendRaw.
+type BinaryMessage struct {
+	Priority  int
+	Timestamp time.Time
+	Host      string
+	App       string
+	Message   string
+}
+
+// AppendBinary appends the frame of m to buf: BinaryMagic, the length of
+// the rest as a uvarint, the priority byte, the timestamp in Unix
+// nanoseconds as a varint (0 for none), then host, app and message, each a
+// uvarint length followed by the bytes.
+func AppendBinary(buf []byte, m BinaryMessage) []byte {
+	var nanos int64
+	if !m.Timestamp.IsZero() {
+		nanos = m.Timestamp.UnixNano()
+	}
+	body := make([]byte, 0, 1+binary.MaxVarintLen64+3*binary.MaxVarintLen32+len(m.Host)+len(m.App)+len(m.Message))
+	body = append(body, byte(m.Priority))
+	body = binary.AppendVarint(body, nanos)
+	for _, s := range []string{m.Host, m.App, m.Message} {
+		body = binary.AppendUvarint(body, uint64(len(s)))
+		body = append(body, s...)
+	}
+	buf = append(buf, BinaryMagic)
+	buf = binary.AppendUvarint(buf, uint64(len(body)))
+	return append(buf, body...)
+}
+
+// ReadBinary reads the next frame from r. Frames longer than maxSize bytes
+// are skipped with ErrBinaryTooLarge.
+func ReadBinary(r *bufio.Reader, maxSize int) (BinaryMessage, error) {
+	magic, err := r.ReadByte()
+	if err != nil {
+		return BinaryMessage{}, err
+	}
+	if magic != BinaryMagic {
+		return BinaryMessage{}, fmt.Errorf("not a binary frame: first byte %#x", magic)
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return BinaryMessage{}, fmt.Errorf("invalid binary frame length: %w", err)
+	}
+	if length > uint64(maxSize) {
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return BinaryMessage{}, err
+		}
+		return BinaryMessage{}, ErrBinaryTooLarge
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return BinaryMessage{}, fmt.Errorf("truncated binary frame: %w", err)
+	}
+	return decodeBinary(body)
+}
+
+// decodeBinary decodes the body of a frame, after its length.
+func decodeBinary(body []byte) (BinaryMessage, error) {
+	if len(body) == 0 || body[0] > 191 {
+		return BinaryMessage{}, errors.New("invalid priority in binary frame")
+	}
+	m := BinaryMessage{Priority: int(body[0])}
+	body = body[1:]
+	nanos, n := binary.Varint(body)
+	if n <= 0 {
+		return BinaryMessage{}, errors.New("invalid timestamp in binary frame")
+	}
+	if nanos != 0 {
+		m.Timestamp = time.Unix(0, nanos).UTC()
+	}
+	body = body[n:]
+	for _, field := range []*string{&m.Host, &m.App, &m.Message} {
+		length, n := binary.Uvarint(body)
+		if n <= 0 || length > uint64(len(body)-n) {
+			return BinaryMessage{}, errors.New("truncated string in binary frame")
+		}
+		*field = string(body[n : n+int(length)])
+		body = body[n+int(length):]
+	}
+	return m, nil
+}
+
+// rawBinaryMessage wraps a formatted syslog message for SendRaw. Messages
+// without a valid priority are user.notice, as in RFC 3164 4.3.3.
+func rawBinaryMessage(message string) BinaryMessage {
+	if rest, ok := strings.CutPrefix(message, "<"); ok {
+		if digits, text, ok := strings.Cut(rest, ">"); ok {
+			if priority, err := strconv.Atoi(digits); err == nil && priority >= 0 && priority <= 191 && len(digits) <= 3 {
+				return BinaryMessage{Priority: priority, Message: text}
+			}
+		}
+	}
+	return BinaryMessage{Priority: 13, Message: message}
+}
+
+// Text renders m as a text syslog message: the raw message for SendRaw
+// frames, otherwise RFC 5424 keeping the timestamp to the nanosecond.
+func (m BinaryMessage) Text() string {
+	if m.Timestamp.IsZero() && m.Host == "" && m.App == "" {
+		return fmt.Sprintf("<%d>%s", m.Priority, m.Message)
+	}
+	timestamp := "-"
+	if !m.Timestamp.IsZero() {
+		timestamp = m.Timestamp.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - - - %s", m.Priority, timestamp, nilValue(m.Host, 255), nilValue(m.App, 48), m.Message)
+}
@@ -0,0 +1,83 @@
+package syslog_client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	messages := []BinaryMessage{
+		{Priority: 27, Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC), Host: "web-01", App: "nginx", Message: "upstream timed out"},
+		{Priority: 0, Timestamp: time.Unix(0, -1).UTC(), Host: "h", App: "a", Message: "before the epoch"},
+		{Priority: 191, Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Message: "multi\nline ünïcode " + strings.Repeat("x", 300)},
+		{Priority: 13, Message: "Jan 1 00:00:00 host app: raw"},
+	}
+	var stream []byte
+	for _, m := range messages {
+		stream = AppendBinary(stream, m)
+	}
+	r := bufio.NewReader(bytes.NewReader(stream))
+	for i, want := range messages {
+		got, err := ReadBinary(r, 1024)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if got.Priority != want.Priority || !got.Timestamp.Equal(want.Timestamp) || got.Host != want.Host || got.App != want.App || got.Message != want.Message {
+			t.Errorf("message %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	if _, err := ReadBinary(r, 1024); err != io.EOF {
+		t.Errorf("expected EOF after the last frame, got %v", err)
+	}
+
+	// The encoding is more compact than the text format.
+	text := formatSyslogMessage(27, messages[0].Timestamp, "web-01", "nginx", "upstream timed out", &RFC5424{MsgID: "-"})
+	if size := len(AppendBinary(nil, messages[0])); size >= len(text) {
+		t.Errorf("expected the binary frame to be smaller than %d bytes, got %d", len(text), size)
+	}
+}
+
+func TestReadBinaryErrors(t *testing.T) {
+	large := BinaryMessage{Priority: 13, Message: strings.Repeat("x", 100)}
+	small := BinaryMessage{Priority: 13, Message: "small"}
+	r := bufio.NewReader(bytes.NewReader(AppendBinary(AppendBinary(nil, large), small)))
+	if _, err := ReadBinary(r, 50); !errors.Is(err, ErrBinaryTooLarge) {
+		t.Errorf("expected ErrBinaryTooLarge, got %v", err)
+	}
+	if got, err := ReadBinary(r, 50); err != nil || got.Message != "small" {
+		t.Errorf("expected the reader to resume after a large frame, got %+v (%v)", got, err)
+	}
+
+	frame := AppendBinary(nil, small)
+	for name, data := range map[string][]byte{
+		"no magic":  []byte("<13>text\n"),
+		"truncated": frame[:len(frame)-1],
+		"priority":  append([]byte{BinaryMagic, 2}, 192, 0),
+	} {
+		if _, err := ReadBinary(bufio.NewReader(bytes.NewReader(data)), 1024); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestBinaryMessageText(t *testing.T) {
+	tests := []struct {
+		m    BinaryMessage
+		want string
+	}{
+		{BinaryMessage{Priority: 27, Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 120000000, time.UTC), Host: "web-01", App: "nginx", Message: "hi"}, "<27>1 2024-01-02T03:04:05.12Z web-01 nginx - - - hi"},
+		{BinaryMessage{Priority: 14, Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Message: "no host"}, "<14>1 2024-01-02T03:04:05Z - - - - - no host"},
+		{rawBinaryMessage("<13>Jan 1 00:00:00 host app: raw"), "<13>Jan 1 00:00:00 host app: raw"},
+		{rawBinaryMessage("no priority"), "<13>no priority"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Text(); got != tt.want {
+			t.Errorf("Text() = %q, want %q", got, tt.want)
+		}
+	}
+}
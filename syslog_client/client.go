@@ -0,0 +1,169 @@
+package syslog_client
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// maxAckRetries is how many times a TCP message is resent on a new
+// connection when no ack arrives.
+const maxAckRetries = 3
+
+// Client sends syslog messages to a server over UDP or TCP. Sends are not
+// logged, so a client can carry the log output of the program using it.
+type Client struct {
+	// RFC5424 selects the RFC 5424 format for Send when set.
+	RFC5424 *RFC5424
+	// Timestamp, if not zero, is the time Send stamps messages with instead
+	// of the current time, for replaying historical logs.
+	Timestamp time.Time
+	// Framing selects the TCP framing: "lf" (default), "octet" counting
+	// as described in RFC 6587, or "binary" for the compact encoding of
+	// AppendBinary.
+	Framing string
+	// Ack makes TCP sends wait for the server to acknowledge each message,
+	// resending it on a new connection if no ack arrives within AckTimeout.
+	// The server must run with -tcpack.
+	Ack        bool
+	AckTimeout time.Duration
+
+	proto  string
+	addr   string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Dial connects to the syslog server at addr using proto "udp" or "tcp".
+func Dial(proto, addr string) (*Client, error) {
+	proto = strings.ToLower(proto)
+	if proto != "udp" && proto != "tcp" {
+		return nil, fmt.Errorf("unsupported protocol: %s. Use 'udp' or 'tcp'", proto)
+	}
+	conn, err := net.Dial(proto, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s server: %w", strings.ToUpper(proto), err)
+	}
+	return &Client{proto: proto, addr: addr, conn: conn}, nil
+}
+
+// Send formats a message with Timestamp, or the current time, and sends it.
+func (c *Client) Send(facility, severity int, host, app, msg string) error {
+	if facility < 0 || facility > 23 {
+		return fmt.Errorf("invalid facility level: %d. Must be between 0 and 23", facility)
+	}
+	if severity < 0 || severity > 7 {
+		return fmt.Errorf("invalid severity level: %d. Must be between 0 and 7", severity)
+	}
+	if c.proto == "tcp" && c.Framing == "binary" {
+		// Send the fields as they are rather than formatted as text.
+		timestamp := c.Timestamp
+		if timestamp.IsZero() {
+			timestamp = time.Now()
+		}
+		m := BinaryMessage{Priority: facility*8 + severity, Timestamp: timestamp, Host: host, App: app, Message: msg}
+		return c.writeTCPFrame(string(AppendBinary(nil, m)), m.Text())
+	}
+	return c.SendRaw(formatSyslogMessage(facility*8+severity, c.Timestamp, host, app, msg, c.RFC5424))
+}
+
+// SendRaw sends an already formatted syslog message.
+func (c *Client) SendRaw(message string) error {
+	if c.proto == "tcp" {
+		return c.sendTCPMessage(message)
+	}
+	return c.sendUDPMessage(message)
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// sendUDPMessage sends a syslog message as a single datagram.
+func (c *Client) sendUDPMessage(message string) error {
+	if _, err := c.conn.Write([]byte(message)); err != nil {
+		return fmt.Errorf("error sending UDP message: %w", err)
+	}
+	return nil
+}
+
+// sendTCPMessage sends a syslog message terminated by a newline, prefixed
+// with its length when octet counting is selected, or binary encoded.
+func (c *Client) sendTCPMessage(message string) error {
+	frame := message + "\n"
+	switch c.Framing {
+	case "octet":
+		frame = fmt.Sprintf("%d %s", len(message), message)
+	case "binary":
+		frame = string(AppendBinary(nil, rawBinaryMessage(message)))
+	}
+	return c.writeTCPFrame(frame, message)
+}
+
+// writeTCPFrame writes a framed message, waiting for the ack if enabled.
+func (c *Client) writeTCPFrame(frame, message string) error {
+	if c.Ack {
+		return c.sendWithAck(frame)
+	}
+	if _, err := c.conn.Write([]byte(frame)); err != nil {
+		return fmt.Errorf("error sending TCP message: %w", err)
+	}
+	return nil
+}
+
+// sendWithAck writes frame and waits for the server's ack, reconnecting and
+// resending on errors and timeouts. A message may therefore be delivered
+// more than once, but is not lost while the server is reachable.
+func (c *Client) sendWithAck(frame string) error {
+	var err error
+	for attempt := 0; attempt <= maxAckRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("No ack from %s (%v), resending", c.addr, err)
+			if err = c.reconnect(); err != nil {
+				continue
+			}
+		}
+		if err = c.writeAndAwaitAck(frame); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("error sending TCP message: no ack after %d attempts: %w", maxAckRetries+1, err)
+}
+
+func (c *Client) writeAndAwaitAck(frame string) error {
+	timeout := c.AckTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	if c.reader == nil {
+		c.reader = bufio.NewReader(c.conn)
+	}
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+	if _, err := c.conn.Write([]byte(frame)); err != nil {
+		return err
+	}
+	reply, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if reply != "ack\n" {
+		return fmt.Errorf("unexpected reply %q", reply)
+	}
+	return nil
+}
+
+func (c *Client) reconnect() error {
+	c.conn.Close()
+	conn, err := net.Dial(c.proto, c.addr)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
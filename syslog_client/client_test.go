@@ -0,0 +1,142 @@
+package syslog_client
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientSendUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client, err := Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Send(4, 3, "web-01", "sshd", "authentication failure"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<35>") || !strings.HasSuffix(msg, " web-01 sshd: authentication failure") {
+		t.Errorf("unexpected UDP message %q", msg)
+	}
+}
+
+func TestClientSendTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	client, err := Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendRaw("<13>Jan 1 00:00:00 host app: one"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Send(1, 6, "host", "app", "two"); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	lines := <-received
+	if len(lines) != 2 || lines[0] != "<13>Jan 1 00:00:00 host app: one" || !strings.HasSuffix(lines[1], "host app: two") {
+		t.Errorf("unexpected TCP messages %q", lines)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	if _, err := Dial("tpc", "127.0.0.1:514"); err == nil {
+		t.Error("expected an error for an unsupported protocol")
+	}
+	client, err := Dial("udp", "127.0.0.1:514")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Send(24, 0, "h", "a", "m"); err == nil {
+		t.Error("expected an error for an invalid facility")
+	}
+	if err := client.Send(1, 8, "h", "a", "m"); err == nil {
+		t.Error("expected an error for an invalid severity")
+	}
+}
+
+func TestClientAckResend(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 2)
+	go func() {
+		// The first connection swallows the message without an ack.
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+		defer conn.Close()
+
+		conn2, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn2.Close()
+		line, _ = bufio.NewReader(conn2).ReadString('\n')
+		received <- line
+		conn2.Write([]byte("ack\n"))
+	}()
+
+	client, err := Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Ack = true
+	client.AckTimeout = 100 * time.Millisecond
+	if err := client.SendRaw("<13>Jan 1 00:00:00 host app: important"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := <-received; got != "<13>Jan 1 00:00:00 host app: important\n" {
+			t.Errorf("attempt %d: unexpected message %q", i+1, got)
+		}
+	}
+
+	ln.Close()
+	if err := client.SendRaw("<13>Jan 1 00:00:01 host app: lost"); err == nil || !strings.Contains(err.Error(), "no ack") {
+		t.Errorf("expected an error once the server is gone, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package syslog_client
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// simTemplate is a message an app may log. Weight sets how often it is
+// picked relative to the app's other templates. Placeholders are filled in
+// by simulator.fill.
+type simTemplate struct {
+	severity int
+	weight   int
+	text     string
+}
+
+// simApps are the apps the simulator can log as, with their usual messages.
+// Most traffic is informational with occasional warnings and errors.
+var simApps = []struct {
+	name      string
+	templates []simTemplate
+}{
+	{"sshd", []simTemplate{
+		{6, 50, "Accepted publickey for {user} from {ip} port {port} ssh2"},
+		{6, 30, "Disconnected from user {user} {ip} port {port}"},
+		{5, 10, "Invalid user {user} from {ip} port {port}"},
+		{4, 8, "Failed password for {user} from {ip} port {port} ssh2"},
+		{3, 2, "error: kex_exchange_identification: Connection closed by remote host"},
+	}},
+	{"nginx", []simTemplate{
+		{6, 70, "{ip} - - \"GET /{path} HTTP/1.1\" 200 {bytes}"},
+		{6, 10, "{ip} - - \"POST /api/{path} HTTP/1.1\" 201 {bytes}"},
+		{5, 10, "{ip} - - \"GET /{path} HTTP/1.1\" 404 {bytes}"},
+		{4, 6, "upstream response time {ms}ms exceeded threshold for /{path}"},
+		{3, 4, "connect() failed (111: Connection refused) while connecting to upstream, client: {ip}"},
+	}},
+	{"kernel", []simTemplate{
+		{6, 40, "eth0: link up, 1000Mbps, full-duplex"},
+		{7, 40, "audit: type=1400 apparmor=\"ALLOWED\" operation=\"open\" pid={pid}"},
+		{4, 15, "TCP: request_sock_TCP: Possible SYN flooding on port {port}. Sending cookies."},
+		{3, 5, "EXT4-fs warning (device sda1): ext4_dx_add_entry: Directory index full"},
+	}},
+	{"cron", []simTemplate{
+		{6, 80, "({user}) CMD (/usr/local/bin/backup.sh --incremental)"},
+		{6, 15, "pam_unix(cron:session): session opened for user {user}"},
+		{3, 5, "({user}) MAIL (mailed 1 byte of output; but got status 0x004b)"},
+	}},
+	{"postgres", []simTemplate{
+		{6, 50, "LOG: checkpoint complete: wrote {count} buffers"},
+		{6, 25, "LOG: connection authorized: user={user} database=app"},
+		{4, 15, "LOG: duration: {ms} ms statement: SELECT * FROM orders WHERE id = {count}"},
+		{3, 10, "ERROR: duplicate key value violates unique constraint \"orders_pkey\""},
+	}},
+	{"dockerd", []simTemplate{
+		{6, 60, "Container {hex} health status changed to healthy"},
+		{6, 25, "Pulling image registry.local/app:{count}"},
+		{4, 10, "Health check for container {hex} exceeded timeout"},
+		{3, 5, "Container {hex} exited with code 137"},
+	}},
+	{"systemd", []simTemplate{
+		{6, 60, "Started Session {count} of user {user}."},
+		{6, 30, "Reloading OpenBSD Secure Shell server..."},
+		{3, 10, "app.service: Main process exited, code=exited, status=1/FAILURE"},
+	}},
+	{"haproxy", []simTemplate{
+		{6, 80, "{ip}:{port} [frontend] backend/web-{count} {ms}/0/1/2/{ms} 200 {bytes}"},
+		{4, 15, "Server backend/web-{count} is DOWN, reason: Layer4 timeout"},
+		{2, 5, "backend backend has no server available!"},
+	}},
+}
+
+// simAnomalies are rare messages injected to exercise anomaly detection.
+var simAnomalies = []simTemplate{
+	{2, 1, "Out of memory: Killed process {pid} (java) total-vm:{bytes}kB"},
+	{0, 1, "Kernel panic - not syncing: Fatal exception in interrupt"},
+	{1, 1, "EXT4-fs error (device sda1): ext4_journal_check_start: Detected aborted journal"},
+	{2, 1, "segfault at {hex} ip {hex} sp {hex} error 4 in libc.so.6"},
+	{4, 1, "Accepted password for root from {ip} port {port} ssh2"},
+	{3, 1, "I/O error, dev sdb, sector {count} op 0x0:(READ)"},
+}
+
+var simHostRoles = []string{"web", "db", "app", "lb", "cache"}
+
+var simUsers = []string{"alice", "bob", "deploy", "postgres", "root", "www-data", "admin", "guest"}
+
+var simPaths = []string{"", "index.html", "login", "orders", "health", "static/app.js", "users/42"}
+
+// simulator generates synthetic but realistic syslog traffic. The same seed
+// always produces the same messages.
+type simulator struct {
+	rng            *rand.Rand
+	hosts          []string
+	apps           int
+	anomalyPercent float64
+}
+
+// simMessage is one generated message before formatting.
+type simMessage struct {
+	severity int
+	host     string
+	app      string
+	text     string
+	anomaly  bool
+}
+
+func newSimulator(seed uint64, hosts, apps int, anomalyPercent float64) (*simulator, error) {
+	if hosts < 1 {
+		return nil, fmt.Errorf("invalid number of hosts: %d. Must be at least 1", hosts)
+	}
+	if apps < 1 || apps > len(simApps) {
+		return nil, fmt.Errorf("invalid number of apps: %d. Must be between 1 and %d", apps, len(simApps))
+	}
+	if anomalyPercent < 0 || anomalyPercent > 100 {
+		return nil, fmt.Errorf("invalid anomaly percentage: %g. Must be between 0 and 100", anomalyPercent)
+	}
+	s := &simulator{
+		rng:            rand.New(rand.NewPCG(seed, 0)),
+		apps:           apps,
+		anomalyPercent: anomalyPercent,
+	}
+	for i := 0; i < hosts; i++ {
+		s.hosts = append(s.hosts, fmt.Sprintf("%s-%02d", simHostRoles[i%len(simHostRoles)], i/len(simHostRoles)+1))
+	}
+	return s, nil
+}
+
+// next generates the next message.
+func (s *simulator) next() simMessage {
+	host := s.hosts[s.rng.IntN(len(s.hosts))]
+	app := simApps[s.rng.IntN(s.apps)]
+	if s.rng.Float64()*100 < s.anomalyPercent {
+		tmpl := simAnomalies[s.rng.IntN(len(simAnomalies))]
+		return simMessage{severity: tmpl.severity, host: host, app: app.name, text: s.fill(tmpl.text), anomaly: true}
+	}
+	tmpl := s.pick(app.templates)
+	return simMessage{severity: tmpl.severity, host: host, app: app.name, text: s.fill(tmpl.text)}
+}
+
+// pick chooses a template with probability proportional to its weight.
+func (s *simulator) pick(templates []simTemplate) simTemplate {
+	total := 0
+	for _, t := range templates {
+		total += t.weight
+	}
+	n := s.rng.IntN(total)
+	for _, t := range templates {
+		if n < t.weight {
+			return t
+		}
+		n -= t.weight
+	}
+	return templates[len(templates)-1]
+}
+
+// fill replaces the placeholders in text with random values.
+func (s *simulator) fill(text string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(text, '{')
+		end := strings.IndexByte(text, '}')
+		if start < 0 || end < start {
+			b.WriteString(text)
+			return b.String()
+		}
+		b.WriteString(text[:start])
+		b.WriteString(s.value(text[start+1 : end]))
+		text = text[end+1:]
+	}
+}
+
+func (s *simulator) value(placeholder string) string {
+	switch placeholder {
+	case "ip":
+		return fmt.Sprintf("10.%d.%d.%d", s.rng.IntN(256), s.rng.IntN(256), s.rng.IntN(254)+1)
+	case "port":
+		return fmt.Sprint(1024 + s.rng.IntN(64511))
+	case "user":
+		return simUsers[s.rng.IntN(len(simUsers))]
+	case "path":
+		return simPaths[s.rng.IntN(len(simPaths))]
+	case "bytes":
+		return fmt.Sprint(s.rng.IntN(100000))
+	case "ms":
+		return fmt.Sprint(s.rng.IntN(5000))
+	case "pid":
+		return fmt.Sprint(100 + s.rng.IntN(32000))
+	case "count":
+		return fmt.Sprint(1 + s.rng.IntN(999))
+	case "hex":
+		return fmt.Sprintf("%012x", s.rng.Uint64()>>16)
+	}
+	return "{" + placeholder + "}"
+}
+
+// simulate sends messages from sim at rate messages per second until
+// duration has passed, and returns how many were sent and how many of those
+// were injected anomalies.
+func simulate(send func(string) error, sim *simulator, facility int, rate float64, duration time.Duration, rfc5424 *RFC5424) (sent, anomalies int, err error) {
+	if rate <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate: %g. Must be greater than 0", rate)
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	deadline := time.After(duration)
+	for {
+		msg := sim.next()
+		if err := send(formatSyslogMessage(facility*8+msg.severity, time.Time{}, msg.host, msg.app, msg.text, rfc5424)); err != nil {
+			return sent, anomalies, err
+		}
+		sent++
+		if msg.anomaly {
+			anomalies++
+		}
+		select {
+		case <-deadline:
+			return sent, anomalies, nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package syslog_client
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulatorDeterministic(t *testing.T) {
+	generate := func(seed uint64) []simMessage {
+		sim, err := newSimulator(seed, 3, 4, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var messages []simMessage
+		for i := 0; i < 200; i++ {
+			messages = append(messages, sim.next())
+		}
+		return messages
+	}
+
+	first, second := generate(42), generate(42)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("message %d differs between runs with the same seed: %+v != %+v", i, first[i], second[i])
+		}
+	}
+	want := []simMessage{
+		{severity: 6, host: "app-01", app: "sshd", text: "Accepted publickey for bob from 10.117.84.93 port 42793 ssh2"},
+		{severity: 6, host: "app-01", app: "sshd", text: "Accepted publickey for www-data from 10.77.106.128 port 15363 ssh2"},
+		{severity: 6, host: "db-01", app: "nginx", text: `10.140.173.228 - - "POST /api/health HTTP/1.1" 201 62056`},
+	}
+	sim, err := newSimulator(42, 3, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range want {
+		if got := sim.next(); got != w {
+			t.Errorf("message %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	other := generate(43)
+	same := 0
+	for i := range first {
+		if first[i] == other[i] {
+			same++
+		}
+	}
+	if same == len(first) {
+		t.Error("expected a different seed to generate different traffic")
+	}
+
+	hosts, apps, anomalies := map[string]bool{}, map[string]bool{}, 0
+	for _, msg := range first {
+		hosts[msg.host] = true
+		apps[msg.app] = true
+		if msg.anomaly {
+			anomalies++
+		}
+		if strings.ContainsAny(msg.text, "{}") {
+			t.Errorf("unfilled placeholder in %q", msg.text)
+		}
+	}
+	if len(hosts) != 3 || len(apps) != 4 {
+		t.Errorf("expected 3 hosts and 4 apps, got %v and %v", hosts, apps)
+	}
+	if anomalies < 5 || anomalies > 40 {
+		t.Errorf("expected about 10%% anomalies, got %d of %d", anomalies, len(first))
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	sim, err := newSimulator(1, 2, 2, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	n, anomalies, err := simulate(func(msg string) error {
+		sent = append(sent, msg)
+		return nil
+	}, sim, 1, 1000, 20*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n != len(sent) || anomalies != n {
+		t.Errorf("sent %d (%d anomalies), recorded %d", n, anomalies, len(sent))
+	}
+	for _, msg := range sent {
+		if parts := strings.SplitN(msg, ">", 2); len(parts) != 2 || !strings.HasPrefix(parts[0], "<") {
+			t.Errorf("expected a syslog priority in %q", msg)
+		}
+	}
+
+	for _, args := range [][3]float64{{0, 1, 0}, {1, 0, 0}, {1, 99, 0}, {1, 1, 101}} {
+		if _, err := newSimulator(1, int(args[0]), int(args[1]), args[2]); err == nil {
+			t.Errorf("expected an error for hosts=%g apps=%g anomaly=%g", args[0], args[1], args[2])
+		}
+	}
+}
@@ -1,4 +1,5 @@
-package main
+// Package syslog_client sends syslog messages over UDP or TCP.
+package syslog_client
 
 import (
 	"bufio"
@@ -6,30 +7,54 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func main() {
+// Run implements the send subcommand.
+func Run(args []string) error {
 	// Command-line flags
-	protocol := flag.String("p", "udp", "Protocol to use: 'udp' or 'tcp'")
-	address := flag.String("a", "127.0.0.1:514", "Address of the syslog server")
-	facility := flag.Int("f", 1, "Syslog facility level (0 to 23)")
-	severity := flag.Int("s", 6, "Syslog severity level (0 to 7)")
-	host := flag.String("h", "localhost", "Host name")
-	app := flag.String("n", "syslog_client", "Application name")
-	message := flag.String("m", "Test syslog message", "The message to send")
-	inputFile := flag.String("i", "", "Input file containing syslog messages")
-	debuglog := flag.String("d", "/dev/null", "debug log file")
-
-	flag.Parse()
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	protocol := flags.String("p", "udp", "Protocol to use: 'udp' or 'tcp'")
+	address := flags.String("a", "127.0.0.1:514", "Address of the syslog server")
+	facility := flags.Int("f", 1, "Syslog facility level (0 to 23)")
+	severity := flags.Int("s", 6, "Syslog severity level (0 to 7)")
+	host := flags.String("h", "localhost", "Host name")
+	app := flags.String("n", "syslog_client", "Application name")
+	message := flags.String("m", "Test syslog message", "The message to send")
+	timestamp := flags.String("time", "", "Timestamp of the -m message in RFC 3339 format, e.g. 2024-01-02T15:04:05Z (default now)")
+	inputFile := flags.String("i", "", "Input file containing syslog messages, '-' for standard input")
+	framing := flags.String("framing", "lf", "TCP framing: 'lf', 'octet' (RFC 6587 octet counting) or 'binary' (compact length-prefixed encoding, for high throughput)")
+	ack := flags.Bool("ack", false, "Wait for the server to acknowledge each TCP message and resend on timeout (server needs -tcpack)")
+	ackTimeout := flags.Duration("acktimeout", 5*time.Second, "How long to wait for an ack before resending")
+	dryRun := flags.Bool("dry-run", false, "Parse and print the messages to standard output without sending them")
+	stdin := flags.Bool("stdin", false, "Read syslog messages from standard input")
+	rfc5424 := flags.Bool("rfc5424", false, "Send -m messages in RFC 5424 format instead of BSD (RFC 3164)")
+	procID := flags.String("procid", strconv.Itoa(os.Getpid()), "RFC 5424 PROCID")
+	msgID := flags.String("msgid", "-", "RFC 5424 MSGID")
+	sdID := flags.String("sdid", "syslog@32473", "RFC 5424 structured data element ID")
+	var sdParams sdParamsFlag
+	flags.Var(&sdParams, "sd", "RFC 5424 structured data parameter as key=value (repeatable)")
+	debuglog := flags.String("d", "/dev/null", "debug log file")
+	simulateTraffic := flags.Bool("simulate", false, "Send synthetic traffic from random hosts and apps for load tests and demos")
+	rate := flags.Float64("rate", 10, "Simulated messages per second")
+	duration := flags.Duration("duration", 10*time.Second, "How long to simulate traffic")
+	hosts := flags.Int("hosts", 5, "Number of distinct simulated hosts")
+	apps := flags.Int("apps", 4, fmt.Sprintf("Number of distinct simulated apps (1 to %d)", len(simApps)))
+	anomalyPercent := flags.Float64("anomaly", 0, "Percentage of simulated messages that are anomalies")
+	seed := flags.Uint64("seed", 0, "Random seed for the simulation, for reproducible traffic (0 picks one)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
 
 	if *debuglog != "" {
 		f, err := os.OpenFile(*debuglog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			log.Fatalf("Error opening debug log file: %v", err)
+			return fmt.Errorf("error opening debug log file: %w", err)
 		}
 		log.SetOutput(f)
 		log.SetFlags(log.LstdFlags | log.Lshortfile)
@@ -40,126 +65,312 @@ func main() {
 
 	// Validate priority level
 	if *facility < 0 || *facility > 23 {
-		log.Fatalf("Invalid facility level: %d. Must be between 0 and 23.", *facility)
+		return fmt.Errorf("invalid facility level: %d. Must be between 0 and 23", *facility)
 	}
 
 	if *severity < 0 || *severity > 7 {
-		log.Fatalf("Invalid severity level: %d. Must be between 0 and 7.", *severity)
+		return fmt.Errorf("invalid severity level: %d. Must be between 0 and 7", *severity)
+	}
+
+	if *framing != "lf" && *framing != "octet" && *framing != "binary" {
+		return fmt.Errorf("unsupported framing: %s. Use 'lf', 'octet' or 'binary'", *framing)
+	}
+	if *framing == "binary" && strings.ToLower(*protocol) != "tcp" {
+		return fmt.Errorf("binary framing requires -p tcp")
+	}
+
+	var stamp time.Time
+	if *timestamp != "" {
+		var err error
+		if stamp, err = time.Parse(time.RFC3339Nano, *timestamp); err != nil {
+			return fmt.Errorf("invalid -time %q: use RFC 3339, e.g. 2024-01-02T15:04:05Z", *timestamp)
+		}
+	}
+
+	var format *RFC5424
+	if *rfc5424 {
+		format = &RFC5424{ProcID: *procID, MsgID: *msgID, SDID: *sdID, Params: sdParams}
+	}
+
+	var sim *simulator
+	if *simulateTraffic {
+		if *seed == 0 {
+			*seed = uint64(time.Now().UnixNano())
+		}
+		var err error
+		sim, err = newSimulator(*seed, *hosts, *apps, *anomalyPercent)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *dryRun {
+		if sim != nil {
+			_, _, err := simulate(func(msg string) error {
+				_, err := fmt.Println(msg)
+				return err
+			}, sim, *facility, *rate, *duration, format)
+			return err
+		}
+		return runDryRun(*stdin, *inputFile, *facility, *severity, stamp, *host, *app, *message, format)
+	}
+
+	client, err := Dial(*protocol, *address)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	client.Framing = *framing
+	client.Ack = *ack
+	client.AckTimeout = *ackTimeout
+	client.RFC5424 = format
+	client.Timestamp = stamp
+
+	if sim != nil {
+		sent, anomalies, err := simulate(client.SendRaw, sim, *facility, *rate, *duration, format)
+		fmt.Printf("Sent %d simulated messages (%d anomalies, seed %d)\n", sent, anomalies, *seed)
+		return err
 	}
 
 	// Check if input file is provided
+	if *stdin || *inputFile == "-" {
+		return sendMessages(client, os.Stdin, *facility, *host, *app)
+	}
 	if *inputFile != "" {
-		sendMessagesFromFile(*inputFile, *protocol, *address, *facility)
-	} else {
-		// Create the syslog message with a timestamp and priority level
-		syslogMessage := formatSyslogMessage(*facility*8+*severity, *host, *app, *message)
-
-		// Send the message based on the chosen protocol
-		switch strings.ToLower(*protocol) {
-		case "udp":
-			sendUDPMessage(*address, syslogMessage)
-		case "tcp":
-			sendTCPMessage(*address, syslogMessage)
-		default:
-			log.Fatalf("Unsupported protocol: %s. Use 'udp' or 'tcp'.", *protocol)
-		}
+		return sendMessagesFromFile(client, *inputFile, *facility, *host, *app)
+	}
+	if err := client.Send(*facility, *severity, *host, *app, *message); err != nil {
+		return err
 	}
+	log.Printf("Sent %s message to %s", strings.ToUpper(client.proto), client.addr)
+	return nil
 }
 
-// formatSyslogMessage creates a syslog message with priority, timestamp, and message body.
-func formatSyslogMessage(priority int, host string, app string, message string) string {
-	timestamp := time.Now().Format("Jan 2 15:04:05")
-	return fmt.Sprintf("<%d>%s %s %s", priority, timestamp, host, app+": "+message)
+// RFC5424 holds the RFC 5424 header fields and structured data that the BSD
+// format lacks.
+type RFC5424 struct {
+	ProcID string
+	MsgID  string
+	SDID   string
+	Params []SDParam
 }
 
-// sendUDPMessage sends a syslog message over UDP.
-func sendUDPMessage(address, message string) {
-	conn, err := net.Dial("udp", address)
-	if err != nil {
-		log.Fatalf("Error connecting to UDP server: %v", err)
-	}
-	defer conn.Close()
+// SDParam is a single structured data parameter.
+type SDParam struct {
+	Name  string
+	Value string
+}
 
-	_, err = conn.Write([]byte(message))
-	if err != nil {
-		log.Fatalf("Error sending UDP message: %v", err)
+// sdParamsFlag collects repeated -sd key=value flags.
+type sdParamsFlag []SDParam
+
+func (f *sdParamsFlag) String() string {
+	var params []string
+	for _, p := range *f {
+		params = append(params, p.Name+"="+p.Value)
 	}
+	return strings.Join(params, ",")
+}
 
-	log.Printf("Sent UDP message to %s: %s", address, message)
+func (f *sdParamsFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("structured data must be key=value, got %q", value)
+	}
+	*f = append(*f, SDParam{Name: name, Value: val})
+	return nil
 }
 
-// sendTCPMessage sends a syslog message over TCP.
-func sendTCPMessage(address, message string) {
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		log.Fatalf("Error connecting to TCP server: %v", err)
+// formatSyslogMessage creates a syslog message with priority, timestamp, and message body.
+// A nil rfc5424 produces the BSD format, otherwise an RFC 5424 version 1 message.
+// A zero timestamp is replaced by the current time.
+func formatSyslogMessage(priority int, timestamp time.Time, host string, app string, message string, rfc5424 *RFC5424) string {
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	if rfc5424 == nil {
+		return fmt.Sprintf("<%d>%s %s %s", priority, timestamp.Format("Jan 2 15:04:05"), host, app+": "+message)
 	}
-	defer conn.Close()
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s", priority, timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		nilValue(host, 255), nilValue(app, 48), nilValue(rfc5424.ProcID, 128), nilValue(rfc5424.MsgID, 32),
+		rfc5424.structuredData(), message)
+}
 
-	_, err = conn.Write([]byte(message + "\n"))
-	if err != nil {
-		log.Fatalf("Error sending TCP message: %v", err)
+// nilValue returns an RFC 5424 header field: printable, without spaces,
+// truncated to maxLen, or "-" when empty.
+func nilValue(s string, maxLen int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > maxLen {
+		s = s[:maxLen]
+	}
+	if s == "" {
+		return "-"
 	}
+	return s
+}
 
-	log.Printf("Sent TCP message to %s: %s", address, message)
+// structuredData renders the SD element, escaping '"', '\' and ']' in
+// parameter values.
+func (r *RFC5424) structuredData() string {
+	if len(r.Params) == 0 || r.SDID == "" {
+		return "-"
+	}
+	escaper := strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+	var sd strings.Builder
+	sd.WriteString("[" + r.SDID)
+	for _, p := range r.Params {
+		fmt.Fprintf(&sd, ` %s="%s"`, p.Name, escaper.Replace(p.Value))
+	}
+	sd.WriteString("]")
+	return sd.String()
 }
 
 // sendMessagesFromFile reads syslog messages from a file and sends them.
-func sendMessagesFromFile(filename, protocol, address string, facility int) {
+func sendMessagesFromFile(client *Client, filename string, facility int, host, app string) error {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("Error opening file: %v", err)
+		return fmt.Errorf("error opening file: %w", err)
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	return sendMessages(client, file, facility, host, app)
+}
+
+// sendMessages sends each line read from r until EOF. A final line without a
+// trailing newline is sent as well.
+func sendMessages(client *Client, r io.Reader, facility int, host, app string) error {
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		line := scanner.Text()
-		syslogMessage := parseSyslogLine(line, facility)
-
-		switch strings.ToLower(protocol) {
-		case "udp":
-			sendUDPMessage(address, syslogMessage)
-		case "tcp":
-			sendTCPMessage(address, syslogMessage)
-		default:
-			log.Fatalf("Unsupported protocol: %s. Use 'udp' or 'tcp'.", protocol)
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
 		}
+		syslogMessage := parseSyslogLine(line, facility, host, app)
+		if syslogMessage == "" {
+			continue
+		}
+		if err := client.SendRaw(syslogMessage); err != nil {
+			return err
+		}
+		log.Printf("Sent %s message to %s: %s", strings.ToUpper(client.proto), client.addr, syslogMessage)
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("Error reading file: %v", err)
+		return fmt.Errorf("error reading input: %w", err)
+	}
+	return nil
+}
+
+// runDryRun prints the messages that would be sent to standard output.
+func runDryRun(stdin bool, inputFile string, facility, severity int, timestamp time.Time, host, app, message string, rfc5424 *RFC5424) error {
+	if stdin || inputFile == "-" {
+		return dryRunMessages(os.Stdout, os.Stdin, facility, host, app)
+	}
+	if inputFile != "" {
+		file, err := os.Open(inputFile)
+		if err != nil {
+			return fmt.Errorf("error opening file: %w", err)
+		}
+		defer file.Close()
+		return dryRunMessages(os.Stdout, file, facility, host, app)
+	}
+	fmt.Println(formatSyslogMessage(facility*8+severity, timestamp, host, app, message, rfc5424))
+	return nil
+}
+
+// dryRunMessages parses each line read from r like sendMessages and writes
+// the formatted message, prefixed with its line number and priority, to w.
+// It returns an error listing the lines that could not be parsed.
+func dryRunMessages(w io.Writer, r io.Reader, facility int, host, app string) error {
+	scanner := bufio.NewScanner(r)
+	var malformed []string
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		syslogMessage := parseSyslogLine(line, facility, host, app)
+		if syslogMessage == "" {
+			fmt.Fprintf(w, "%d: malformed: %s\n", lineNo, line)
+			malformed = append(malformed, strconv.Itoa(lineNo))
+			continue
+		}
+		priority := syslogMessage[1:strings.Index(syslogMessage, ">")]
+		fmt.Fprintf(w, "%d: priority %s: %s\n", lineNo, priority, syslogMessage)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading input: %w", err)
+	}
+	if len(malformed) > 0 {
+		return fmt.Errorf("%d malformed lines: %s", len(malformed), strings.Join(malformed, ", "))
+	}
+	return nil
+}
+
+// rfc3164Timestamp matches a leading "Mmm dd hh:mm:ss" timestamp, allowing
+// the space padded day form ("Jan  1").
+var rfc3164Timestamp = regexp.MustCompile(`^([A-Z][a-z]{2}) +(\d{1,2}) (\d{2}:\d{2}:\d{2}) `)
+
+// splitTimestamp validates a leading RFC 3164 timestamp and returns it along
+// with the remainder of the line.
+func splitTimestamp(line string) (string, string, bool) {
+	m := rfc3164Timestamp.FindStringSubmatch(line)
+	if m == nil {
+		return "", line, false
+	}
+	date := m[1] + " " + m[2] + " " + m[3]
+	if _, err := time.Parse("Jan 2 15:04:05", date); err != nil {
+		return "", line, false
 	}
+	return date, line[len(m[0]):], true
 }
 
 // parseSyslogLine parses a line from the input file and formats it as a syslog message.
-func parseSyslogLine(line string, facility int) string {
-	parts := strings.SplitN(line, " ", 6)
-	if len(parts) < 6 {
-		log.Printf("Error: Invalid syslog line format: %s", line)
-		return ""
-	}
-	log.Printf("Received syslog message: %v|%v|%v|%v|%v|%v", parts[0], parts[1], parts[2], parts[3], parts[4], parts[5])
-	date := parts[0] + " " + parts[1] + " " + parts[2]
-	host := parts[3]
-	app := parts[4]
-	app = strings.TrimSuffix(app, ":")
+// Lines that do not start with a valid RFC 3164 timestamp are sent whole as the
+// message body, stamped with the current time and the default host and app.
+func parseSyslogLine(line string, facility int, defaultHost, defaultApp string) string {
+	date, rest, ok := splitTimestamp(line)
+	var host, app, message string
+	if ok {
+		parts := strings.SplitN(rest, " ", 3)
+		if len(parts) < 3 {
+			log.Printf("Error: Invalid syslog line format: %s", line)
+			return ""
+		}
+		host = parts[0]
+		app = strings.TrimSuffix(parts[1], ":")
+		message = parts[2]
+	} else {
+		log.Printf("No valid timestamp, sending the whole line as the message: %s", line)
+		date = time.Now().Format("Jan 2 15:04:05")
+		host = defaultHost
+		app = defaultApp
+		message = line
+	}
+
 	severityStr := "info"
-	if strings.HasPrefix(parts[5], "[DEBUG]") {
+	if strings.HasPrefix(message, "[DEBUG]") {
 		severityStr = "debug"
-	} else if strings.HasPrefix(parts[5], "[WARNING]") {
+	} else if strings.HasPrefix(message, "[WARNING]") {
 		severityStr = "warning"
-	} else if strings.HasPrefix(parts[5], "[ERROR]") {
+	} else if strings.HasPrefix(message, "[ERROR]") {
 		severityStr = "err"
-	} else if strings.HasPrefix(parts[5], "[CRITICAL]") {
+	} else if strings.HasPrefix(message, "[CRITICAL]") {
 		severityStr = "crit"
-	} else if strings.HasPrefix(parts[5], "[ALERT]") {
+	} else if strings.HasPrefix(message, "[ALERT]") {
 		severityStr = "alert"
-	} else if strings.HasPrefix(parts[5], "[EMERG]") {
+	} else if strings.HasPrefix(message, "[EMERG]") {
 		severityStr = "emerg"
 	}
 
-	message := parts[5]
 	log.Printf("Parsed syslog message: date %s host %s app %s severity %s message %s", date, host, app, severityStr, message)
 	severity := parseSeverity(severityStr)
 	priority := facility*8 + severity
@@ -167,27 +378,69 @@ func parseSyslogLine(line string, facility int) string {
 	return fmt.Sprintf("<%d>%s %s %s: %s", priority, date, host, app, message)
 }
 
-// parseSeverity converts severity string to integer.
+// parseSeverity converts severity string to integer, defaulting to info.
 func parseSeverity(severityStr string) int {
+	severity, err := ParseSeverity(severityStr)
+	if err != nil {
+		log.Printf("Unknown severity: %s. Defaulting to 'info' (6)", severityStr)
+		return 6
+	}
+	return severity
+}
+
+// ParseSeverity converts a severity keyword such as "warning" or "err", or a
+// number from 0 to 7, to the numeric severity.
+func ParseSeverity(severityStr string) (int, error) {
 	switch strings.ToLower(severityStr) {
 	case "emerg":
-		return 0
+		return 0, nil
 	case "alert":
-		return 1
+		return 1, nil
 	case "crit":
-		return 2
+		return 2, nil
 	case "err":
-		return 3
+		return 3, nil
 	case "warning":
-		return 4
+		return 4, nil
 	case "notice":
-		return 5
+		return 5, nil
 	case "info":
-		return 6
+		return 6, nil
 	case "debug":
-		return 7
-	default:
-		log.Printf("Unknown severity: %s. Defaulting to 'info' (6)", severityStr)
-		return 6
+		return 7, nil
+	}
+	severity, err := strconv.Atoi(severityStr)
+	if err != nil || severity < 0 || severity > 7 {
+		return 0, fmt.Errorf("invalid severity %q", severityStr)
+	}
+	return severity, nil
+}
+
+// facilityNames are the RFC 5424 facility keywords for facilities 0 to 23.
+var facilityNames = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "audit", "alert", "clock",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+// FacilityName returns the keyword of a numeric facility, or the number
+// itself if it is out of range.
+func FacilityName(facility int) string {
+	if facility < 0 || facility >= len(facilityNames) {
+		return strconv.Itoa(facility)
+	}
+	return facilityNames[facility]
+}
+
+// ParseFacility converts a facility keyword such as "auth" or "local0", or a
+// number from 0 to 23, to the numeric facility.
+func ParseFacility(facilityStr string) (int, error) {
+	for facility, name := range facilityNames {
+		if strings.EqualFold(facilityStr, name) {
+			return facility, nil
+		}
+	}
+	facility, err := strconv.Atoi(facilityStr)
+	if err != nil || facility < 0 || facility > 23 {
+		return 0, fmt.Errorf("invalid facility %q", facilityStr)
 	}
+	return facility, nil
 }
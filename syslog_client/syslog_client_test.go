@@ -0,0 +1,218 @@
+package syslog_client
+
+import (
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslogLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"Jan 26 00:00:05 lb-01 haproxy[3456]: [WARNING] Server web-02 is DOWN", "<12>Jan 26 00:00:05 lb-01 haproxy[3456]: [WARNING] Server web-02 is DOWN"},
+		{"Jan  1 00:00:00 localhost kernel: [ERROR] disk failure", "<11>Jan 1 00:00:00 localhost kernel: [ERROR] disk failure"},
+		{"Feb 3 12:30:45 db-01 mysqld: started", "<14>Feb 3 12:30:45 db-01 mysqld: started"},
+	}
+	for _, tt := range tests {
+		if got := parseSyslogLine(tt.line, 1, "myhost", "myapp"); got != tt.want {
+			t.Errorf("parseSyslogLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseSyslogLineWithoutTimestamp(t *testing.T) {
+	for _, line := range []string{
+		"something happened on the box",
+		"Foo 26 00:00:05 not really a date",
+		"Jan 32 00:00:05 invalid day",
+		"[ERROR] 2024-01-26T00:00:05Z iso timestamps are not RFC 3164",
+	} {
+		got := parseSyslogLine(line, 1, "myhost", "myapp")
+		date, rest, ok := splitTimestamp(strings.SplitN(got, ">", 2)[1])
+		if !ok {
+			t.Errorf("expected a synthesized RFC 3164 timestamp in %q", got)
+			continue
+		}
+		if _, err := time.Parse("Jan 2 15:04:05", date); err != nil {
+			t.Errorf("invalid synthesized timestamp %q: %v", date, err)
+		}
+		if rest != "myhost myapp: "+line {
+			t.Errorf("expected the whole line as the message body, got %q", rest)
+		}
+	}
+	if got := parseSyslogLine("[ERROR] no timestamp", 1, "h", "a"); !strings.HasPrefix(got, "<11>") {
+		t.Errorf("expected error severity for %q", got)
+	}
+}
+
+func TestRunReadsStdin(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	go func() {
+		// The last line has no trailing newline.
+		io.WriteString(w, "Jan 1 00:00:00 host app: first\r\n\nJan 1 00:00:01 host app: second")
+		w.Close()
+	}()
+
+	if err := Run([]string{"-stdin", "-a", conn.LocalAddr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	for _, want := range []string{"<14>Jan 1 00:00:00 host app: first", "<14>Jan 1 00:00:01 host app: second"} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestFormatSyslogMessageRFC5424(t *testing.T) {
+	rfc5424 := &RFC5424{
+		ProcID: "1234",
+		MsgID:  "ID47",
+		SDID:   "exampleSDID@32473",
+		Params: []SDParam{{Name: "iut", Value: "3"}, {Name: "note", Value: `say "hi" [ok]\`}},
+	}
+	msg := formatSyslogMessage(165, time.Time{}, "mymachine.example.com", "evntslog", "An application event", rfc5424)
+
+	re := regexp.MustCompile(`^<165>1 (\S+) mymachine\.example\.com evntslog 1234 ID47 (\[.*\]) An application event$`)
+	m := re.FindStringSubmatch(msg)
+	if m == nil {
+		t.Fatalf("message does not match RFC 5424 framing: %q", msg)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, m[1]); err != nil {
+		t.Errorf("invalid RFC 5424 timestamp %q: %v", m[1], err)
+	}
+	if want := `[exampleSDID@32473 iut="3" note="say \"hi\" [ok\]\\"]`; m[2] != want {
+		t.Errorf("structured data = %s, want %s", m[2], want)
+	}
+
+	nilMsg := formatSyslogMessage(14, time.Time{}, "", "app", "no sd", &RFC5424{})
+	if !regexp.MustCompile(`^<14>1 \S+ - app - - - no sd$`).MatchString(nilMsg) {
+		t.Errorf("expected NILVALUE fields, got %q", nilMsg)
+	}
+
+	if bsd := formatSyslogMessage(14, time.Time{}, "host", "app", "hello", nil); !regexp.MustCompile(`^<14>[A-Z][a-z]{2} \d{1,2} \d{2}:\d{2}:\d{2} host app: hello$`).MatchString(bsd) {
+		t.Errorf("expected BSD format by default, got %q", bsd)
+	}
+}
+
+func TestRunCustomTimestamp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-m", "replayed"}, "<14>Mar 7 09:30:15 localhost syslog_client: replayed"},
+		{[]string{"-m", "replayed", "-rfc5424", "-procid", "42"}, "<14>1 2023-03-07T09:30:15.250000+02:00 localhost syslog_client 42 - - replayed"},
+	}
+	buf := make([]byte, 1024)
+	for _, tt := range tests {
+		args := append([]string{"-a", conn.LocalAddr().String(), "-time", "2023-03-07T09:30:15.25+02:00"}, tt.args...)
+		if err := Run(args); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+
+	if err := Run([]string{"-a", conn.LocalAddr().String(), "-time", "yesterday"}); err == nil {
+		t.Error("expected an error for a timestamp that is not RFC 3339")
+	}
+}
+
+func TestDryRunMessages(t *testing.T) {
+	input := "Jan 1 00:00:00 host app: [ERROR] disk failure\n" +
+		"Jan 1 00:00:01 truncated\n" +
+		"\n" +
+		"no timestamp here\n" +
+		"Jan 1 00:00:02 host\n"
+	var out strings.Builder
+	err := dryRunMessages(&out, strings.NewReader(input), 1, "myhost", "myapp")
+	if err == nil || err.Error() != "2 malformed lines: 2, 5" {
+		t.Errorf("expected lines 2 and 5 to be reported, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 report lines, got %q", out.String())
+	}
+	if lines[0] != "1: priority 11: <11>Jan 1 00:00:00 host app: [ERROR] disk failure" {
+		t.Errorf("unexpected report %q", lines[0])
+	}
+	if lines[1] != "2: malformed: Jan 1 00:00:01 truncated" {
+		t.Errorf("unexpected report %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "4: priority 14: <14>") || !strings.HasSuffix(lines[2], " myhost myapp: no timestamp here") {
+		t.Errorf("unexpected report %q", lines[2])
+	}
+
+	out.Reset()
+	if err := dryRunMessages(&out, strings.NewReader("Jan 1 00:00:00 host app: ok\n"), 1, "h", "a"); err != nil {
+		t.Errorf("expected no error for valid input, got %v", err)
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	for input, want := range map[string]int{"emerg": 0, "ERR": 3, "warning": 4, "7": 7, "0": 0} {
+		if got, err := ParseSeverity(input); err != nil || got != want {
+			t.Errorf("ParseSeverity(%q) = %d, %v, want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"loud", "8", "-1", ""} {
+		if _, err := ParseSeverity(input); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestParseFacility(t *testing.T) {
+	for input, want := range map[string]int{"kern": 0, "AUTH": 4, "authpriv": 10, "local7": 23, "16": 16} {
+		if got, err := ParseFacility(input); err != nil || got != want {
+			t.Errorf("ParseFacility(%q) = %d, %v, want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"security", "24", "-1", ""} {
+		if _, err := ParseFacility(input); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+	if got := FacilityName(10); got != "authpriv" {
+		t.Errorf("FacilityName(10) = %q, want authpriv", got)
+	}
+	if got := FacilityName(24); got != "24" {
+		t.Errorf("FacilityName(24) = %q, want 24", got)
+	}
+}
@@ -0,0 +1,78 @@
+package syslog_server
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// sourceFilter accepts or drops messages by source IP. Deny takes precedence
+// over allow, and an empty allow list allows every source. Sources that are
+// not IP addresses, such as unix sockets, are always accepted.
+type sourceFilter struct {
+	allow  []netip.Prefix
+	deny   []netip.Prefix
+	denied atomic.Uint64
+}
+
+func newSourceFilter(allow, deny string) (*sourceFilter, error) {
+	allowed, err := parsePrefixes(allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -allow: %w", err)
+	}
+	denied, err := parsePrefixes(deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -deny: %w", err)
+	}
+	return &sourceFilter{allow: allowed, deny: denied}, nil
+}
+
+// parsePrefixes parses a comma separated list of CIDRs or single addresses.
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// permits reports whether messages from remoteAddr are accepted, counting
+// the ones that are not.
+func (f *sourceFilter) permits(remoteAddr string) bool {
+	addr, err := netip.ParseAddr(sourceIP(remoteAddr))
+	if err != nil {
+		return true
+	}
+	addr = addr.Unmap()
+	if containsAddr(f.deny, addr) || (len(f.allow) > 0 && !containsAddr(f.allow, addr)) {
+		f.denied.Add(1)
+		return false
+	}
+	return true
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package syslog_server
+
+import (
+	"testing"
+)
+
+func TestSourceFilter(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.sources, err = newSourceFilter("10.0.0.0/8, 192.168.1.5", "10.6.6.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<14>Jan 1 00:00:00 allowed app: hello", "10.1.2.3:514")
+	handler.logMessage("<14>Jan 1 00:00:00 denied app: in the deny range", "10.6.6.6:514")
+	handler.logMessage("<14>Jan 1 00:00:00 other app: not allowed", "172.16.0.1:514")
+	handler.logMessage("<14>Jan 1 00:00:00 single app: hello", "[::ffff:192.168.1.5]:514")
+	handler.logMessage("<14>Jan 1 00:00:00 local app: hello", "/dev/log")
+
+	messages := rawMessages(handler.messages)
+	if len(messages) != 3 || messages[0] != "<14>Jan 1 00:00:00 allowed app: hello" ||
+		messages[1] != "<14>Jan 1 00:00:00 single app: hello" || messages[2] != "<14>Jan 1 00:00:00 local app: hello" {
+		t.Errorf("unexpected messages %q", messages)
+	}
+	if handler.sources.denied.Load() != 2 {
+		t.Errorf("expected 2 denied messages, got %d", handler.sources.denied.Load())
+	}
+	if handler.received.Load() != 3 {
+		t.Errorf("denied messages must not be counted as received, got %d", handler.received.Load())
+	}
+
+	denyOnly, err := newSourceFilter("", "203.0.113.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !denyOnly.permits("198.51.100.1:514") || denyOnly.permits("203.0.113.9:514") {
+		t.Error("expected an empty allow list to allow everything not denied")
+	}
+
+	for _, bad := range [][2]string{{"10.0.0.0/33", ""}, {"", "not-an-ip"}} {
+		if _, err := newSourceFilter(bad[0], bad[1]); err == nil {
+			t.Errorf("expected an error for allow %q deny %q", bad[0], bad[1])
+		}
+	}
+}
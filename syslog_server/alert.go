@@ -0,0 +1,173 @@
+package syslog_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertNotifier posts severe messages to a Slack or Microsoft Teams
+// incoming webhook in the background. At most limit alerts are sent per
+// window; messages beyond that are counted and reported in a single summary
+// when the window ends, so an alert storm does not flood the channel.
+type alertNotifier struct {
+	url         string
+	teams       bool
+	limit       int
+	window      time.Duration
+	client      *http.Client
+	now         func() time.Time
+	mu          sync.Mutex
+	windowStart time.Time
+	sent        int
+	suppressed  int
+	summary     *time.Timer
+	closed      bool
+	queue       chan string
+	wg          sync.WaitGroup
+}
+
+func newAlertNotifier(url, format string, limit int, window time.Duration) (*alertNotifier, error) {
+	if format != "slack" && format != "teams" {
+		return nil, fmt.Errorf("unsupported -alertformat %q, use 'slack' or 'teams'", format)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("-alertlimit must be positive, got %d", limit)
+	}
+	n := &alertNotifier{
+		url:    url,
+		teams:  format == "teams",
+		limit:  limit,
+		window: window,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+		queue:  make(chan string, 100),
+	}
+	n.wg.Add(1)
+	go n.run()
+	return n, nil
+}
+
+// notify queues an alert for msg, or counts it for the summary if the limit
+// for the current window was reached.
+func (n *alertNotifier) notify(msg syslogMsg, remoteAddr string) {
+	now := n.now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	if now.Sub(n.windowStart) >= n.window {
+		n.windowStart = now
+		n.sent = 0
+	}
+	if n.sent >= n.limit {
+		n.suppressed++
+		if n.summary == nil {
+			n.summary = time.AfterFunc(n.window-now.Sub(n.windowStart), n.sendSummary)
+		}
+		return
+	}
+	n.sent++
+	n.enqueue(alertText(msg, remoteAddr))
+}
+
+// sendSummary queues the number of alerts suppressed since the last summary.
+func (n *alertNotifier) sendSummary() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.flushSummary()
+}
+
+// flushSummary queues the summary unless the notifier is closed. The caller
+// must hold n.mu.
+func (n *alertNotifier) flushSummary() {
+	if n.summary != nil {
+		n.summary.Stop()
+		n.summary = nil
+	}
+	if n.suppressed > 0 && !n.closed {
+		n.enqueue(fmt.Sprintf("%d more alerts suppressed (limit %d per %s)", n.suppressed, n.limit, n.window))
+	}
+	n.suppressed = 0
+}
+
+func (n *alertNotifier) enqueue(text string) {
+	select {
+	case n.queue <- text:
+	default:
+		slog.Warn("Alert webhook queue full, dropping alert", "alert", text)
+	}
+}
+
+// alertText describes msg in one line, e.g. "[crit] db-01 kernel: disk failure".
+func alertText(msg syslogMsg, remoteAddr string) string {
+	severity := fmt.Sprintf("sev-%d", msg.Severity)
+	if msg.Severity >= 0 && msg.Severity < len(severityNames) {
+		severity = severityNames[msg.Severity]
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]", severity)
+	if msg.Hostname != "" {
+		b.WriteString(" " + msg.Hostname)
+	}
+	if msg.Appname != "" {
+		b.WriteString(" " + msg.Appname + ":")
+	}
+	b.WriteString(" " + msg.Message)
+	if ip := sourceIP(remoteAddr); ip != "" {
+		fmt.Fprintf(&b, " (from %s)", ip)
+	}
+	return b.String()
+}
+
+func (n *alertNotifier) run() {
+	defer n.wg.Done()
+	for text := range n.queue {
+		if err := n.post(text); err != nil {
+			slog.Error("Error sending alert to webhook", "error", err)
+		}
+	}
+}
+
+// post sends text as a Slack message or a Teams message card.
+func (n *alertNotifier) post(text string) error {
+	var payload any = map[string]string{"text": text}
+	if n.teams {
+		payload = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  "Syslog alert",
+			"text":     text,
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// close sends the queued alerts and any pending summary, then stops the
+// notifier.
+func (n *alertNotifier) close() {
+	n.mu.Lock()
+	n.flushSummary()
+	n.closed = true
+	close(n.queue)
+	n.mu.Unlock()
+	n.wg.Wait()
+}
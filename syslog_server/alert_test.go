@@ -0,0 +1,88 @@
+package syslog_server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAlertNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var texts []string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		if _, ok := payload["@type"]; ok {
+			t.Errorf("expected a Slack payload, got %v", payload)
+		}
+		mu.Lock()
+		texts = append(texts, payload["text"])
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.config.AlertSeverity = 2 // crit
+	handler.alerter, err = newAlertNotifier(webhook.URL, "slack", 2, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.logMessage("<14>Jan 1 00:00:00 web-01 nginx: request served", "10.0.0.1:514")
+	handler.logMessage("<11>Jan 1 00:00:00 web-01 nginx: upstream error", "10.0.0.1:514")
+	for i := 0; i < 5; i++ {
+		handler.logMessage("<10>Jan 1 00:00:01 db-01 kernel: disk failure", "10.0.0.2:514")
+	}
+	time.Sleep(300 * time.Millisecond)
+	handler.logMessage("<8>Jan 1 00:00:02 db-01 kernel: panic", "10.0.0.2:514")
+	handler.alerter.close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"[crit] db-01 kernel: disk failure (from 10.0.0.2)",
+		"[crit] db-01 kernel: disk failure (from 10.0.0.2)",
+		"3 more alerts suppressed (limit 2 per 100ms)",
+		"[emerg] db-01 kernel: panic (from 10.0.0.2)",
+	}
+	if strings.Join(texts, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got alerts\n%s\nwant\n%s", strings.Join(texts, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestAlertNotifierTeams(t *testing.T) {
+	payloads := make(chan map[string]string, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer webhook.Close()
+
+	n, err := newAlertNotifier(webhook.URL, "teams", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.notify(syslogMsg{Severity: 1, Message: "link down"}, "")
+	n.close()
+	payload := <-payloads
+	if payload["@type"] != "MessageCard" || payload["text"] != "[alert] link down" {
+		t.Errorf("unexpected Teams payload %v", payload)
+	}
+
+	if _, err := newAlertNotifier(webhook.URL, "discord", 1, time.Minute); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+	if _, err := newAlertNotifier(webhook.URL, "slack", 0, time.Minute); err == nil {
+		t.Error("expected an error for a zero limit")
+	}
+}
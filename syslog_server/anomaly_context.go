@@ -0,0 +1,106 @@
+package syslog_server
+
+import (
+	"strings"
+)
+
+// minAnomalyMatch is the lowest word overlap at which an anomaly reported
+// by the LLM is taken to be a reworded buffered message.
+const minAnomalyMatch = 0.5
+
+// anomalyContext holds the buffered message an anomaly was matched to and
+// the messages received around it.
+type anomalyContext struct {
+	Before []syslogMsg `json:"before"`
+	Match  syslogMsg   `json:"match"`
+	After  []syslogMsg `json:"after"`
+}
+
+// newAnomalyContext finds the message the LLM reported as text in messages
+// and returns it with up to n messages before and after it, or nil if no
+// message matches.
+func newAnomalyContext(messages []storedMessage, text string, n int) *anomalyContext {
+	i := matchAnomaly(messages, text)
+	if i < 0 {
+		return nil
+	}
+	ctx := &anomalyContext{Match: contextMessage(&messages[i])}
+	for j := max(i-n, 0); j < i; j++ {
+		ctx.Before = append(ctx.Before, contextMessage(&messages[j]))
+	}
+	for j := i + 1; j < len(messages) && j <= i+n; j++ {
+		ctx.After = append(ctx.After, contextMessage(&messages[j]))
+	}
+	return ctx
+}
+
+func contextMessage(stored *storedMessage) syslogMsg {
+	if stored.Malformed {
+		return stored.malformedMessage()
+	}
+	return stored.Msg
+}
+
+// matchAnomaly returns the index of the message that best matches the text
+// of an anomaly, or -1. The LLM gets the messages without their priority
+// and may echo them whole, only their body, or reworded, so the text is
+// compared ignoring case and spacing, then by containment, then by the
+// share of words in common with the host, app and body of the message. Of
+// equally good matches the latest wins.
+func matchAnomaly(messages []storedMessage, text string) int {
+	text = normalizeAnomalyText(skipNumericPrefix(text))
+	if text == "" {
+		return -1
+	}
+	words := anomalyWords(text)
+	best, bestScore := -1, 0.0
+	for i := range messages {
+		raw := normalizeAnomalyText(skipNumericPrefix(messages[i].Raw))
+		body := normalizeAnomalyText(messages[i].Msg.Message)
+		var score float64
+		switch {
+		case raw == text:
+			score = 1
+		case strings.Contains(raw, text) || body != "" && strings.Contains(text, body):
+			score = 0.9
+		default:
+			msg := &messages[i].Msg
+			score = wordOverlap(words, anomalyWords(normalizeAnomalyText(msg.Hostname+" "+msg.Appname+" "+msg.Message)))
+		}
+		if score >= minAnomalyMatch && score >= bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+func normalizeAnomalyText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// anomalyWords splits normalized text into its distinct words, without
+// surrounding punctuation.
+func anomalyWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.Fields(text) {
+		if w = strings.Trim(w, `.,:;!?"'()[]`); w != "" {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+// wordOverlap returns the number of words a and b have in common relative
+// to the larger of the two.
+func wordOverlap(a, b map[string]bool) float64 {
+	common := 0
+	for w := range a {
+		if b[w] {
+			common++
+		}
+	}
+	if n := max(len(a), len(b)); n > 0 {
+		return float64(common) / float64(n)
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package syslog_server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"syslog/syslog_anomaly"
+)
+
+func TestMatchAnomaly(t *testing.T) {
+	var messages []storedMessage
+	for _, raw := range []string{
+		"<14>Jan 1 00:00:00 web-01 nginx: GET /index.html 200",
+		"<11>Jan 1 00:00:01 db-01 postgres: could not write to file pg_wal: No space left on device",
+		"<14>Jan 1 00:00:02 web-01 nginx: GET /health 200",
+		"<14>Jan 1 00:00:03 web-01 nginx: GET /health 200",
+	} {
+		messages = append(messages, newStoredMessage(raw, "10.0.0.1:514", time.Now(), false))
+	}
+	tests := []struct {
+		text string
+		want int
+	}{
+		// As sent to the LLM, without the priority.
+		{"Jan 1 00:00:01 db-01 postgres: could not write to file pg_wal: No space left on device", 1},
+		// Only the body, with different spacing and case.
+		{"could not write to file pg_wal:  no space left on device", 1},
+		// Reworded by the model.
+		{"db-01 postgres could not write pg_wal: No space left", 1},
+		// Of identical messages the latest is taken.
+		{"GET /health 200", 3},
+		{"kernel panic on host mail-03", -1},
+		{"", -1},
+	}
+	for _, tt := range tests {
+		if got := matchAnomaly(messages, tt.text); got != tt.want {
+			t.Errorf("matchAnomaly(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestAnomalyContextRendered(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := `[{"message": "db-01 postgres: No space left on device", "reason": "disk full", "severity": "high"}]`
+		json.NewEncoder(w).Encode(syslog_anomaly.CompletionResponse{
+			Choices: []syslog_anomaly.Choice{{Message: syslog_anomaly.Message{Content: syslog_anomaly.MessageContent(content)}}},
+		})
+	}))
+	defer llm.Close()
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := handler.getConfig()
+	config.ApiKey = "test"
+	config.Url = llm.URL
+	config.AnomaliesOnly = true
+	config.AnomalyContext = 2
+	for i := 0; i < 3; i++ {
+		handler.logMessage(fmt.Sprintf("<14>Jan 1 00:00:0%d db-01 postgres: checkpoint %d", i, i), "10.0.0.1:514")
+	}
+	handler.logMessage("<11>Jan 1 00:00:03 db-01 postgres: No space left on device", "10.0.0.1:514")
+	handler.logMessage("<14>Jan 1 00:00:04 db-01 postgres: checkpoint 4", "10.0.0.1:514")
+
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(rows)
+	for _, want := range []string{
+		"checkpoint 1<br>",
+		"checkpoint 2<br>",
+		"<strong>Jan 1 00:00:03 db-01 postgres: No space left on device</strong>",
+		"<br>Jan 1 00:00:04 db-01 postgres: checkpoint 4",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected %q in the anomaly context:\n%s", want, html)
+		}
+	}
+	if strings.Contains(html, "checkpoint 0") {
+		t.Errorf("expected only 2 messages of context before the anomaly:\n%s", html)
+	}
+
+	// The context outlives the buffer, which is emptied after analysis.
+	messages, err := filteredMessages(context.Background(), handler, messageOrder{}, messagePage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Context == nil || messages[0].Context.Match.Severity != 3 {
+		t.Errorf("expected the cached anomaly to keep its context, got %+v", messages)
+	}
+}
@@ -0,0 +1,142 @@
+package syslog_server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveInterval is how often the log directories are checked for rotated
+// files to archive.
+var archiveInterval = time.Minute
+
+// archiver moves the compressed backups lumberjack leaves next to the log
+// files into a separate directory, for example on cheaper storage.
+// Lumberjack only prunes backups in its own directory, so archived files are
+// kept until removed by other means.
+type archiver struct {
+	dir   string
+	files []string
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// logFileNames returns the paths of all the log files written by lh.
+func (lh *logFileHandler) logFileNames() []string {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	var names []string
+	if lh.logger != nil {
+		names = append(names, lh.logger.Filename)
+	}
+	for _, route := range lh.routes {
+		names = append(names, route.logger.Filename)
+	}
+	for _, logger := range lh.facilityLogs {
+		names = append(names, logger.Filename)
+	}
+	return names
+}
+
+// startArchiver archives the backups of files into dir every interval,
+// creating dir if needed.
+func startArchiver(dir string, files []string, interval time.Duration) (*archiver, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating archive directory: %w", err)
+	}
+	a := &archiver{dir: dir, files: files, done: make(chan struct{})}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := a.sweep(); err != nil {
+					slog.Error("Error archiving log files", "error", err)
+				} else if n > 0 {
+					slog.Info("Moved rotated log files", "count", n, "dir", a.dir)
+				}
+			case <-a.done:
+				return
+			}
+		}
+	}()
+	return a, nil
+}
+
+// sweep moves the compressed backups of the log files into the archive
+// directory and returns how many were moved. Backups still being compressed
+// are left for the next sweep.
+func (a *archiver) sweep() (int, error) {
+	moved := 0
+	for _, name := range a.files {
+		ext := filepath.Ext(name)
+		prefix := strings.TrimSuffix(filepath.Base(name), ext) + "-"
+		// Backups are named like syslog-2006-01-02T15-04-05.000.log.gz.
+		matches, err := filepath.Glob(filepath.Join(filepath.Dir(name), globEscape(prefix)+"*"+globEscape(ext)+".gz"))
+		if err != nil {
+			return moved, err
+		}
+		for _, backup := range matches {
+			if _, err := os.Stat(strings.TrimSuffix(backup, ".gz")); err == nil {
+				continue
+			}
+			if err := moveFile(backup, filepath.Join(a.dir, filepath.Base(backup))); err != nil {
+				return moved, err
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// globEscape quotes the characters filepath.Glob treats as patterns.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// moveFile renames src to dst, copying it when they are on different file
+// systems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+func (a *archiver) stop() {
+	close(a.done)
+	a.wg.Wait()
+}
@@ -0,0 +1,77 @@
+package syslog_server
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveRotatedLogFiles(t *testing.T) {
+	logDir := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	logFile := filepath.Join(logDir, "syslog.log")
+	handler, err := createLogFileHandler(logFile, 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other files in the log directory are left alone.
+	other := filepath.Join(logDir, "other-2024-01-01T00-00-00.000.log.gz")
+	if err := os.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := startArchiver(archiveDir, handler.logFileNames(), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.stop()
+
+	handler.logMessage("<11>Jan 1 00:00:00 web-01 nginx: upstream timed out", "10.0.0.7:514")
+	if err := handler.logger.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<11>Jan 1 00:00:01 web-01 nginx: after rotation", "10.0.0.7:514")
+	defer handler.logger.Close()
+
+	var archived []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		archived, _ = filepath.Glob(filepath.Join(archiveDir, "syslog-*.log.gz"))
+		if len(archived) > 0 {
+			break
+		}
+	}
+	if len(archived) != 1 {
+		t.Fatalf("expected the rotated file in the archive directory, got %v", archived)
+	}
+	f, err := os.Open(archived[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "upstream timed out") {
+		t.Errorf("unexpected archived content %q", data)
+	}
+
+	left, _ := filepath.Glob(filepath.Join(logDir, "syslog-*"))
+	if len(left) != 0 {
+		t.Errorf("expected no backups left next to the log file, got %v", left)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("expected unrelated files to stay: %v", err)
+	}
+	if current, _ := os.ReadFile(logFile); !strings.Contains(string(current), "after rotation") {
+		t.Errorf("expected the active log file to stay, got %q", current)
+	}
+}
@@ -0,0 +1,41 @@
+package syslog_server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// packetCapture writes every received datagram verbatim to a file, before
+// any parsing, to help diagnose senders whose messages are malformed. Each
+// datagram is written as a header line with the time, source and length
+// followed by a hex dump.
+type packetCapture struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newPacketCapture(path string) (*packetCapture, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	return &packetCapture{file: file}, nil
+}
+
+// record writes data received from remoteAddr at received.
+func (pc *packetCapture) record(data []byte, remoteAddr string, received time.Time) error {
+	entry := fmt.Sprintf("%s %s len=%d\n%s\n", received.UTC().Format(time.RFC3339Nano), remoteAddr, len(data), hex.Dump(data))
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	_, err := pc.file.WriteString(entry)
+	return err
+}
+
+func (pc *packetCapture) close() error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.file.Close()
+}
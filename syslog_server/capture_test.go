@@ -0,0 +1,71 @@
+package syslog_server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPacketCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.txt")
+	capture, err := newPacketCapture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.capture = capture
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- serveUDP(conn, handler, nil) }()
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	datagram := []byte("\x00\xffbad\r\n<13>")
+	if _, err := sender.Write(datagram); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		handler.mu.Lock()
+		n := len(handler.messages)
+		handler.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the datagram to be processed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	conn.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	capture.close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	if !strings.Contains(got, sender.LocalAddr().String()+" len=11\n") {
+		t.Errorf("expected the source and length in the capture, got %q", got)
+	}
+	if !strings.Contains(got, "00 ff 62 61 64 0d 0a 3c  31 33 3e") || !strings.Contains(got, "|..bad..<13>|") {
+		t.Errorf("expected a hex dump of the raw datagram, got %q", got)
+	}
+}
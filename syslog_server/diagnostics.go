@@ -0,0 +1,36 @@
+package syslog_server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+)
+
+// newDiagHandler returns the slog handler for the server's own diagnostics,
+// writing records of level or above to w as "text" (key=value pairs) or
+// "json" (an object per line), with the source file and line.
+func newDiagHandler(w io.Writer, format, level string) (slog.Handler, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid -diaglevel: %w", err)
+	}
+	opts := &slog.HandlerOptions{AddSource: true, Level: minLevel}
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unsupported -diagformat %q, use 'text' or 'json'", format)
+	}
+}
+
+// setDiagHandler makes h the handler of the slog and log package defaults,
+// so the lines still written with the log package get the same format.
+func setDiagHandler(h slog.Handler) {
+	// The file and line of log package calls are only recorded when the
+	// flags ask for them at the time slog.SetDefault is called.
+	log.SetFlags(log.Lshortfile)
+	slog.SetDefault(slog.New(h))
+}
@@ -0,0 +1,65 @@
+package syslog_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJSONDiagnostics(t *testing.T) {
+	var out bytes.Buffer
+	diag, err := newDiagHandler(&out, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := slog.Default()
+	setDiagHandler(diag)
+	defer func() {
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.strictParse = true
+	// The parse diagnostic of a valid message is below the level.
+	handler.logMessage("<13>Jan 1 00:00:00 host app: valid message", "192.0.2.1:514")
+	handler.logMessage("<11>link down", "192.0.2.1:514")
+	log.Printf("Reopening log files")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %q", out.String())
+	}
+	var records []map[string]any
+	for _, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON diagnostic %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if r := records[0]; r["level"] != "WARN" || r["msg"] != "Error parsing message" || r["remote_addr"] != "192.0.2.1:514" || r["error"] == nil {
+		t.Errorf("unexpected parse error diagnostic %v", r)
+	}
+	if source, ok := records[0]["source"].(map[string]any); !ok || !strings.HasSuffix(source["file"].(string), "syslog_server.go") {
+		t.Errorf("expected the source of the diagnostic, got %v", records[0]["source"])
+	}
+	// Lines of the log package get the same format.
+	if r := records[1]; r["level"] != "INFO" || r["msg"] != "Reopening log files" {
+		t.Errorf("unexpected log package diagnostic %v", r)
+	}
+
+	for _, bad := range [][2]string{{"xml", "info"}, {"json", "loud"}} {
+		if _, err := newDiagHandler(&out, bad[0], bad[1]); err == nil {
+			t.Errorf("expected an error for format %q and level %q", bad[0], bad[1])
+		}
+	}
+}
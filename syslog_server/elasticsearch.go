@@ -0,0 +1,250 @@
+package syslog_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// esIndexer buffers parsed syslog messages and ships them to Elasticsearch
+// using the _bulk API. At most maxPending messages are buffered; while bulk
+// requests keep failing, further messages are dropped and counted.
+type esIndexer struct {
+	url           string
+	indexTemplate string
+	batchSize     int
+	maxPending    int
+	flushInterval time.Duration
+	maxRetries    int
+	client        *http.Client
+	mu            sync.Mutex
+	docs          []esDoc
+	dropped       atomic.Uint64
+	flushCh       chan struct{}
+	done          chan struct{}
+	wg            sync.WaitGroup
+
+	// sourceZone returns the zone of RFC 3164 timestamps; Local if nil.
+	sourceZone func() *time.Location
+}
+
+// esDoc is a message with the index it goes to, named when it is queued so
+// that a batch spanning midnight or retried late is split by day.
+type esDoc struct {
+	index string
+	msg   syslogMsg
+}
+
+type esBulkResponse struct {
+	Errors bool                            `json:"errors"`
+	Items  []map[string]esBulkItemResponse `json:"items"`
+}
+
+type esBulkItemResponse struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func newESIndexer(url, indexTemplate string) *esIndexer {
+	if indexTemplate == "" {
+		indexTemplate = "syslog-{date}"
+	}
+	return &esIndexer{
+		url:           strings.TrimSuffix(url, "/"),
+		indexTemplate: indexTemplate,
+		batchSize:     500,
+		maxPending:    10000,
+		flushInterval: 5 * time.Second,
+		maxRetries:    3,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		flushCh:       make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+}
+
+// indexName expands the {date} placeholder of the index template.
+func (es *esIndexer) indexName(t time.Time) string {
+	return strings.ReplaceAll(es.indexTemplate, "{date}", t.UTC().Format("2006.01.02"))
+}
+
+func (es *esIndexer) start() {
+	es.wg.Add(1)
+	go func() {
+		defer es.wg.Done()
+		ticker := time.NewTicker(es.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				es.flush()
+			case <-es.flushCh:
+				es.flush()
+			case <-es.done:
+				es.flush()
+				return
+			}
+		}
+	}()
+}
+
+// add queues msg for the index of the day of t, unless maxPending messages
+// are already waiting.
+func (es *esIndexer) add(msg syslogMsg, t time.Time) {
+	es.mu.Lock()
+	if len(es.docs) >= es.maxPending {
+		es.mu.Unlock()
+		if es.dropped.Add(1) == 1 {
+			slog.Warn("Elasticsearch output is full, dropping further messages", "pending", es.maxPending)
+		}
+		return
+	}
+	es.docs = append(es.docs, esDoc{index: es.indexName(t), msg: msg})
+	full := len(es.docs) >= es.batchSize
+	es.mu.Unlock()
+	if full {
+		select {
+		case es.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Write queues a parsed message for indexing by its timestamp, or by when
+// it was received if the timestamp does not parse; malformed messages are
+// not indexed.
+func (es *esIndexer) Write(stored storedMessage) error {
+	if !stored.Malformed {
+		source := time.Local
+		if es.sourceZone != nil {
+			source = es.sourceZone()
+		}
+		t, ok := resolveTimestamp(stored.Msg.Timestamp, source, stored.Received)
+		if !ok {
+			t = stored.Received
+		}
+		es.add(stored.Msg, t)
+	}
+	return nil
+}
+
+// Close indexes the buffered messages and stops the indexer.
+func (es *esIndexer) Close() error {
+	close(es.done)
+	es.wg.Wait()
+	if n := es.dropped.Load(); n > 0 {
+		slog.Warn("Elasticsearch output dropped messages due to a full buffer", "count", n)
+	}
+	return nil
+}
+
+// flush sends all buffered documents, retrying the request and any items
+// that failed with a transient status.
+func (es *esIndexer) flush() {
+	es.mu.Lock()
+	docs := es.docs
+	es.docs = nil
+	es.mu.Unlock()
+	if len(docs) == 0 {
+		return
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 0; len(docs) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > es.maxRetries {
+				slog.Error("Dropping documents after failed Elasticsearch bulk attempts", "count", len(docs), "attempts", attempt)
+				return
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		failed, err := es.sendBulk(docs)
+		if err != nil {
+			slog.Warn("Error sending Elasticsearch bulk request", "error", err)
+			continue
+		}
+		docs = failed
+	}
+}
+
+// sendBulk posts docs as one NDJSON bulk request and returns the documents
+// that should be retried.
+func (es *esIndexer) sendBulk(docs []esDoc) ([]esDoc, error) {
+	body, err := buildBulkBody(docs)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", es.url+"/_bulk", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := es.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("bulk request failed with status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Elasticsearch rejected bulk request", "status", resp.StatusCode, "response", string(respBody))
+		return nil, nil
+	}
+
+	var bulkResp esBulkResponse
+	if err := json.Unmarshal(respBody, &bulkResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if !bulkResp.Errors {
+		return nil, nil
+	}
+	var retry []esDoc
+	for i, item := range bulkResp.Items {
+		if i >= len(docs) {
+			break
+		}
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+			if result.Status == http.StatusTooManyRequests || result.Status >= 500 {
+				retry = append(retry, docs[i])
+			} else {
+				slog.Error("Elasticsearch failed to index document", "status", result.Status, "error", result.Error)
+			}
+		}
+	}
+	return retry, nil
+}
+
+func buildBulkBody(docs []esDoc) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		action, err := json.Marshal(map[string]map[string]string{"index": {"_index": doc.index}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal bulk action: %w", err)
+		}
+		source, err := json.Marshal(doc.msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal document: %w", err)
+		}
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(source)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
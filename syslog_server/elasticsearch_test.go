@@ -0,0 +1,128 @@
+package syslog_server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestESIndexerBulkBody(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("unexpected content type %s", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		first := len(bodies) == 1
+		mu.Unlock()
+		if first {
+			// Second document hits a transient error and must be retried.
+			io.WriteString(w, `{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}]}`)
+			return
+		}
+		io.WriteString(w, `{"errors":false,"items":[{"index":{"status":201}}]}`)
+	}))
+	defer srv.Close()
+
+	es := newESIndexer(srv.URL, "logs-{date}")
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	es.add(syslogMsg{Timestamp: "Jan 1 00:00:00", Hostname: "host1", Appname: "app1", Message: "first"}, day)
+	es.add(syslogMsg{Timestamp: "Jan 1 00:00:01", Hostname: "host2", Appname: "app2", Message: "second"}, day)
+	es.flush()
+
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 bulk requests, got %d", len(bodies))
+	}
+	lines := strings.Split(strings.TrimSuffix(bodies[0], "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 NDJSON lines, got %d: %q", len(lines), bodies[0])
+	}
+	var action map[string]map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &action); err != nil {
+		t.Fatal(err)
+	}
+	if wantIndex := "logs-2024.01.01"; action["index"]["_index"] != wantIndex {
+		t.Errorf("expected index %s, got %s", wantIndex, action["index"]["_index"])
+	}
+	var doc syslogMsg
+	if err := json.Unmarshal([]byte(lines[1]), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Hostname != "host1" || doc.Message != "first" {
+		t.Errorf("unexpected document %+v", doc)
+	}
+	if !strings.Contains(bodies[1], `"message":"second"`) || strings.Contains(bodies[1], `"message":"first"`) {
+		t.Errorf("retry should only contain the failed document: %q", bodies[1])
+	}
+}
+
+func TestESIndexerIndexByMessageTime(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		io.WriteString(w, `{"errors":false}`)
+	}))
+	defer srv.Close()
+
+	es := newESIndexer(srv.URL, "logs-{date}")
+	// Flushed the day after, the batch still goes to the indexes of the days
+	// its messages were sent.
+	received := time.Date(2024, 1, 3, 0, 0, 5, 0, time.UTC)
+	for _, timestamp := range []string{"2024-01-01T23:59:59Z", "2024-01-02T00:00:01+00:00", "not a timestamp"} {
+		es.Write(storedMessage{Received: received, Msg: syslogMsg{Timestamp: timestamp, Message: timestamp}})
+	}
+	es.flush()
+
+	var indexes []string
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	for i := 0; i < len(lines); i += 2 {
+		var action map[string]map[string]string
+		if err := json.Unmarshal([]byte(lines[i]), &action); err != nil {
+			t.Fatal(err)
+		}
+		indexes = append(indexes, action["index"]["_index"])
+	}
+	if got := strings.Join(indexes, " "); got != "logs-2024.01.01 logs-2024.01.02 logs-2024.01.03" {
+		t.Errorf("unexpected indexes %s", got)
+	}
+}
+
+func TestESIndexerMaxPending(t *testing.T) {
+	es := newESIndexer("http://127.0.0.1:0", "")
+	es.maxPending = 3
+	for i := 0; i < 5; i++ {
+		es.add(syslogMsg{Message: "queued"}, time.Now())
+	}
+	if len(es.docs) != 3 || es.dropped.Load() != 2 {
+		t.Errorf("expected 3 pending and 2 dropped, got %d and %d", len(es.docs), es.dropped.Load())
+	}
+}
+
+func TestESIndexerSourceTimezone(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.getConfig().SourceTimezone = "Asia/Tokyo"
+	es := newESIndexer("http://127.0.0.1:0", "logs-{date}")
+	es.sourceZone = handler.sourceLocation
+
+	// 03:00 in Tokyo is still the previous day in UTC.
+	received := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	es.Write(storedMessage{Received: received, Msg: syslogMsg{Timestamp: "Jan 2 03:00:00", Message: "early"}})
+	if len(es.docs) != 1 || es.docs[0].index != "logs-2024.01.01" {
+		t.Errorf("expected the message in logs-2024.01.01, got %+v", es.docs)
+	}
+}
@@ -0,0 +1,110 @@
+package syslog_server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"syslog/syslog_client"
+)
+
+// eventKeepAlive is how often an idle /events stream gets a comment, so
+// proxies do not time it out and disconnected clients are noticed.
+var eventKeepAlive = 30 * time.Second
+
+// eventSubscriber is an /events client. Messages are dropped rather than
+// blocking the handler when a client does not keep up.
+type eventSubscriber struct {
+	severity int
+	messages chan syslogMsg
+}
+
+// eventHub fans new messages out to the /events subscribers.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+}
+
+// subscribe registers a subscriber to messages of severity or worse.
+func (h *eventHub) subscribe(severity int) *eventSubscriber {
+	sub := &eventSubscriber{severity: severity, messages: make(chan syslogMsg, 64)}
+	h.mu.Lock()
+	if h.subscribers == nil {
+		h.subscribers = make(map[*eventSubscriber]struct{})
+	}
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+}
+
+// publish sends msg to the subscribers whose threshold it meets.
+func (h *eventHub) publish(msg syslogMsg) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		if msg.Severity > sub.severity {
+			continue
+		}
+		select {
+		case sub.messages <- msg:
+		default:
+		}
+	}
+}
+
+// eventsHandler streams new messages of EventSeverity or worse, or of the
+// severity query parameter, as Server-Sent Events until the client goes
+// away. Each event is a "message" with the message ID and its JSON.
+func eventsHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		severity := handler.getConfig().EventSeverity
+		if name := r.URL.Query().Get("severity"); name != "" {
+			var err error
+			if severity, err = syslog_client.ParseSeverity(name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		rc := http.NewResponseController(w)
+		sub := handler.events.subscribe(severity)
+		defer handler.events.unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		keepAlive := time.NewTicker(eventKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case msg := <-sub.messages:
+				data, err := json.Marshal(msg)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: message\nid: %d\ndata: %s\n\n", msg.ID, data)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case <-r.Context().Done():
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
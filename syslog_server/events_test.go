@@ -0,0 +1,90 @@
+package syslog_server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsStream(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.getConfig().EventSeverity = 3
+	server := httptest.NewServer(eventsHandler(handler))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+
+	// Below the threshold, so only the second message is pushed.
+	handler.logMessage("<14>Jan 1 00:00:00 web-01 nginx: all good", "10.0.0.1:514")
+	handler.logMessage("<10>Jan 1 00:00:01 db-01 postgres: disk failure", "10.0.0.2:514")
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	var event []string
+	for len(event) < 3 {
+		select {
+		case line := <-lines:
+			event = append(event, line)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for an event, got %q", event)
+		}
+	}
+	if event[0] != "event: message" || event[1] != "id: 2" ||
+		!strings.HasPrefix(event[2], "data: {") || !strings.Contains(event[2], `"message":"disk failure"`) {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	// A disconnected client is unsubscribed.
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		handler.events.mu.Lock()
+		n := len(handler.events.subscribers)
+		handler.events.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the subscriber to be removed after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventsSeverityParameter(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	eventsHandler(handler)(rec, httptest.NewRequest(http.MethodGet, "/events?severity=loud", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown severity, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,450 @@
+package syslog_server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// forwardKeepAlive is the TCP keep-alive idle time and probe interval of
+// forward connections, so half-open links, for example after a NAT timeout,
+// are detected by the kernel.
+const forwardKeepAlive = 30 * time.Second
+
+// forwardHealthInterval is how often idle TCP forward connections are
+// checked for having been closed or reset by the peer.
+var forwardHealthInterval = 10 * time.Second
+
+// forwardRetryInterval is how long a forwarder that cannot reach its
+// upstream server waits before trying again. Messages are held in the queue
+// meanwhile, and dropped only once it is full.
+var forwardRetryInterval = time.Second
+
+// forwardBatch, when size is positive, makes TCP forwarders coalesce
+// messages into writes of about size bytes instead of writing each message
+// on its own. A batch is written once it reaches the size, or delay after its
+// first message, whichever comes first.
+type forwardBatch struct {
+	size  int
+	delay time.Duration
+}
+
+// forwarder relays messages to an upstream syslog server from a dedicated
+// goroutine. The connection is owned by that goroutine, so logMessage only
+// has to enqueue and never waits on the network.
+type forwarder struct {
+	addr           string
+	proto          string
+	conn           net.Conn
+	queue          chan string
+	closing        chan struct{}
+	healthInterval time.Duration
+	retryInterval  time.Duration
+	batchSize      int
+	batchDelay     time.Duration
+	batch          []byte
+	batchCount     int
+	pending        atomic.Int64 // enqueued, not yet written or given up on
+	dropped        atomic.Uint64
+	writes         atomic.Uint64
+	wg             sync.WaitGroup
+
+	// closeMu guards closed, so that enqueue never sends on the closed queue,
+	// for example from an HTTP request still running at shutdown.
+	closeMu sync.RWMutex
+	closed  bool
+
+	// origin, when set, numbers the enqueued messages with addSequence.
+	// seqMu keeps the numbers in queue order.
+	origin string
+	seqMu  sync.Mutex
+	seq    uint64
+}
+
+// newForwarder connects to the upstream server and starts the forwarding
+// goroutine. Messages are dropped when more than queueSize are pending.
+func newForwarder(proto, addr string, queueSize int, batch forwardBatch) (*forwarder, error) {
+	fw := &forwarder{
+		addr:           addr,
+		proto:          proto,
+		queue:          make(chan string, queueSize),
+		closing:        make(chan struct{}),
+		healthInterval: forwardHealthInterval,
+		retryInterval:  forwardRetryInterval,
+	}
+	if proto == "tcp" {
+		// Datagrams carry one message each, so only TCP is batched.
+		fw.batchSize, fw.batchDelay = batch.size, batch.delay
+	}
+	if err := fw.connect(); err != nil {
+		return nil, err
+	}
+	fw.wg.Add(1)
+	go fw.run()
+	return fw, nil
+}
+
+func (fw *forwarder) connect() error {
+	dialer := net.Dialer{KeepAliveConfig: net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     forwardKeepAlive,
+		Interval: forwardKeepAlive,
+		Count:    3,
+	}}
+	conn, err := dialer.Dial(fw.proto, fw.addr)
+	if err != nil {
+		return err
+	}
+	fw.conn = conn
+	slog.Info("Connected to upstream syslog server", "addr", fw.addr, "proto", fw.proto)
+	return nil
+}
+
+// enqueue schedules message for forwarding without blocking. It returns
+// false if the queue is full or the forwarder closed, and the message was
+// dropped.
+func (fw *forwarder) enqueue(message string) bool {
+	fw.closeMu.RLock()
+	defer fw.closeMu.RUnlock()
+	if fw.closed {
+		fw.dropped.Add(1)
+		return false
+	}
+	if fw.origin != "" {
+		// A dropped message keeps its number, so the receiver sees the gap.
+		fw.seqMu.Lock()
+		defer fw.seqMu.Unlock()
+		fw.seq++
+		message = addSequence(message, fw.origin, fw.seq)
+	}
+	fw.pending.Add(1)
+	select {
+	case fw.queue <- message:
+		return true
+	default:
+		fw.pending.Add(-1)
+		fw.dropped.Add(1)
+		return false
+	}
+}
+
+// drain waits up to timeout for the pending messages to be forwarded. It
+// returns how many were forwarded meanwhile and how many are still pending.
+func (fw *forwarder) drain(timeout time.Duration) (drained, remaining int) {
+	start := fw.pending.Load()
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for fw.pending.Load() > 0 && time.Now().Before(deadline) {
+		<-ticker.C
+	}
+	left := max(fw.pending.Load(), 0)
+	return int(max(start-left, 0)), int(left)
+}
+
+func (fw *forwarder) run() {
+	defer fw.wg.Done()
+	var health <-chan time.Time
+	if fw.proto == "tcp" && fw.healthInterval > 0 {
+		ticker := time.NewTicker(fw.healthInterval)
+		defer ticker.Stop()
+		health = ticker.C
+	}
+	// A write that failed because the upstream server is unreachable is
+	// kept in retry and tried again before reading more of the queue.
+	var retry []byte
+	var retryCount int
+	var retryAt <-chan time.Time
+	deliver := func(data []byte, n int) {
+		if err := fw.write(data); err != nil {
+			retry, retryCount = append([]byte(nil), data...), n
+			retryAt = time.After(fw.retryInterval)
+			return
+		}
+		fw.pending.Add(-int64(n))
+	}
+	var flushTimer *time.Timer
+	var flush <-chan time.Time
+	flushBatch := func() {
+		if flushTimer != nil {
+			flushTimer.Stop()
+			flushTimer, flush = nil, nil
+		}
+		if len(fw.batch) > 0 {
+			deliver(fw.batch, fw.batchCount)
+			fw.batch, fw.batchCount = fw.batch[:0], 0
+		}
+	}
+	stop := func() {
+		if n := fw.pending.Swap(0); n > 0 {
+			slog.Warn("Dropping messages that could not be forwarded", "addr", fw.addr, "count", n)
+		}
+		if fw.conn != nil {
+			fw.conn.Close()
+		}
+	}
+	for {
+		if retry != nil {
+			select {
+			case <-retryAt:
+				data, n := retry, retryCount
+				retry = nil
+				deliver(data, n)
+			case <-fw.closing:
+				// Try once more, then give up on the rest of the queue.
+				if err := fw.write(retry); err != nil {
+					stop()
+					return
+				}
+				fw.pending.Add(-int64(retryCount))
+				retry = nil
+			}
+			continue
+		}
+		select {
+		case message, ok := <-fw.queue:
+			if !ok {
+				flushBatch()
+				stop()
+				return
+			}
+			if fw.batchSize <= 0 {
+				deliver([]byte(message+"\n"), 1)
+				continue
+			}
+			fw.batch = append(fw.batch, message...)
+			fw.batch = append(fw.batch, '\n')
+			fw.batchCount++
+			if len(fw.batch) >= fw.batchSize {
+				flushBatch()
+			} else if flushTimer == nil {
+				flushTimer = time.NewTimer(fw.batchDelay)
+				flush = flushTimer.C
+			}
+		case <-flush:
+			flushTimer, flush = nil, nil
+			flushBatch()
+		case <-health:
+			fw.checkHealth()
+		}
+	}
+}
+
+// checkHealth reconnects if the TCP connection was closed or reset by the
+// upstream server, instead of finding out when the next message fails to
+// send. Upstream servers do not send data other than acks, which are
+// discarded, so a read that times out means the connection is still up.
+func (fw *forwarder) checkHealth() {
+	if fw.conn != nil {
+		err := fw.probe()
+		if err == nil {
+			return
+		}
+		slog.Warn("Forward connection is down, reconnecting", "addr", fw.addr, "error", err)
+		fw.conn.Close()
+		fw.conn = nil
+	}
+	if err := fw.connect(); err != nil {
+		slog.Error("Failed to reconnect to upstream syslog server", "addr", fw.addr, "error", err)
+	}
+}
+
+// probe reads from the connection, waiting briefly, and returns the error
+// if it is closed. A deadline already in the past would fail without trying
+// to read.
+func (fw *forwarder) probe() error {
+	defer fw.conn.SetReadDeadline(time.Time{})
+	fw.conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	buf := make([]byte, 512)
+	for {
+		if _, err := fw.conn.Read(buf); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// write sends one or more LF-terminated messages, reconnecting and trying
+// once more if the write fails. It returns an error if the upstream server
+// could not be reached.
+func (fw *forwarder) write(data []byte) error {
+	if fw.conn == nil {
+		slog.Warn("Forward connection is not available, reconnecting", "addr", fw.addr)
+		if err := fw.connect(); err != nil {
+			slog.Error("Failed to reconnect to upstream syslog server", "addr", fw.addr, "error", err)
+			return err
+		}
+	}
+	fw.writes.Add(1)
+	_, err := fw.conn.Write(data)
+	if err != nil {
+		slog.Warn("Error forwarding message, reconnecting", "addr", fw.addr, "error", err)
+		fw.conn.Close()
+		fw.conn = nil
+		if err := fw.connect(); err != nil {
+			slog.Error("Failed to reconnect to upstream syslog server", "addr", fw.addr, "error", err)
+			return err
+		}
+		fw.writes.Add(1)
+		if _, err := fw.conn.Write(data); err != nil {
+			slog.Error("Failed to forward message after reconnecting", "addr", fw.addr, "error", err)
+			fw.conn.Close()
+			fw.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// close stops accepting messages and waits for the queue to be flushed. If
+// the upstream server is unreachable, the messages still queued are dropped.
+func (fw *forwarder) close() {
+	fw.closeMu.Lock()
+	fw.closed = true
+	close(fw.closing)
+	close(fw.queue)
+	fw.closeMu.Unlock()
+	fw.wg.Wait()
+	if n := fw.dropped.Load(); n > 0 {
+		slog.Warn("Forwarder dropped messages due to a full queue", "addr", fw.addr, "count", n)
+	}
+}
+
+// forwardProtocols are the protocols messages can be forwarded over.
+var forwardProtocols = []string{"udp", "tcp"}
+
+// checkForwardProto reports an unsupported forwarding protocol, listing the
+// valid ones, instead of leaving it to fail as a dial error.
+func checkForwardProto(proto string) error {
+	if !slices.Contains(forwardProtocols, proto) {
+		return fmt.Errorf("unsupported forwarding protocol %q, use one of: %s", proto, strings.Join(forwardProtocols, ", "))
+	}
+	return nil
+}
+
+// forwardRoute sends messages whose app name matches pattern to a dedicated
+// upstream server instead of the default one.
+type forwardRoute struct {
+	pattern   *regexp.Regexp
+	forwarder *forwarder
+}
+
+// forwardRouteFlag collects repeated -fwdroute pattern=[proto://]addr flags.
+type forwardRouteFlag []string
+
+func (f *forwardRouteFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *forwardRouteFlag) Set(value string) error {
+	if _, _, _, err := parseForwardRoute(value, "udp"); err != nil {
+		return err
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// parseForwardRoute parses "pattern=[proto://]addr". The protocol defaults
+// to defaultProto.
+func parseForwardRoute(value, defaultProto string) (*regexp.Regexp, string, string, error) {
+	expr, dest, ok := strings.Cut(value, "=")
+	if !ok || expr == "" || dest == "" {
+		return nil, "", "", fmt.Errorf("forward route must be pattern=[proto://]addr, got %q", value)
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid forward route pattern: %w", err)
+	}
+	proto := defaultProto
+	if p, addr, ok := strings.Cut(dest, "://"); ok {
+		proto, dest = p, addr
+	}
+	if err := checkForwardProto(proto); err != nil {
+		return nil, "", "", fmt.Errorf("invalid forward route: %w", err)
+	}
+	return pattern, proto, dest, nil
+}
+
+// connectForwarder connects the default forwarder to the upstream server.
+func (lh *logFileHandler) connectForwarder(proto, addr string, batch forwardBatch) error {
+	fw, err := newForwarder(proto, addr, 10000, batch)
+	if err != nil {
+		return fmt.Errorf("failed to connect to upstream syslog server: %w", err)
+	}
+	lh.forwardAddr, lh.forwardProto = addr, proto
+	lh.forwarder = fw
+	lh.disableForwarding = false
+	return nil
+}
+
+// addForwardRoute connects to the upstream server for a route. Routes are
+// evaluated in the order they are added.
+func (lh *logFileHandler) addForwardRoute(pattern *regexp.Regexp, proto, addr string, batch forwardBatch) error {
+	fw, err := newForwarder(proto, addr, 10000, batch)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	lh.forwardRoutes = append(lh.forwardRoutes, forwardRoute{pattern: pattern, forwarder: fw})
+	return nil
+}
+
+// forwarders returns the default forwarder, if any, and the route
+// forwarders.
+func (lh *logFileHandler) forwarders() []*forwarder {
+	forwarders := make([]*forwarder, 0, len(lh.forwardRoutes)+1)
+	if lh.forwarder != nil {
+		forwarders = append(forwarders, lh.forwarder)
+	}
+	for _, route := range lh.forwardRoutes {
+		forwarders = append(forwarders, route.forwarder)
+	}
+	return forwarders
+}
+
+// drainForwarders waits up to timeout in total for the default and route
+// forwarders to forward their pending messages.
+func (lh *logFileHandler) drainForwarders(timeout time.Duration) (drained, remaining int) {
+	deadline := time.Now().Add(timeout)
+	for _, fw := range lh.forwarders() {
+		d, r := fw.drain(time.Until(deadline))
+		drained += d
+		remaining += r
+	}
+	return drained, remaining
+}
+
+// closeForwarders forwards what is left in the queues of the default and
+// route forwarders and closes them. Messages enqueued afterwards are dropped.
+func (lh *logFileHandler) closeForwarders() {
+	for _, fw := range lh.forwarders() {
+		fw.close()
+	}
+}
+
+// routeForwarder returns the forwarder of the first route matching the app
+// name of message, or nil.
+func (lh *logFileHandler) routeForwarder(message string) *forwarder {
+	if len(lh.forwardRoutes) == 0 {
+		return nil
+	}
+	msg, err := parseSyslogMessage(message)
+	if err != nil {
+		return nil
+	}
+	for _, route := range lh.forwardRoutes {
+		if route.pattern.MatchString(msg.Appname) {
+			return route.forwarder
+		}
+	}
+	return nil
+}
@@ -0,0 +1,614 @@
+package syslog_server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"syslog/syslog_client"
+)
+
+func BenchmarkLogMessageForwarding(b *testing.B) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			if _, _, err := upstream.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	handler, err := createLogFileHandler("", 10, upstream.LocalAddr().String(), "udp", 7)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.logMessage("<11>Jan 1 00:00:00 web-01 nginx: upstream timed out", "127.0.0.1:5140")
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(handler.forwarder.dropped.Load())/float64(b.N), "dropped/op")
+	handler.forwarder.close()
+}
+
+func TestForwarderDeliversMessages(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	handler, err := createLogFileHandler("", 10, ln.Addr().String(), "tcp", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<11>Jan 1 00:00:00 web-01 nginx: error one", "127.0.0.1:5140")
+	handler.logMessage("<14>Jan 1 00:00:01 web-01 nginx: info two", "127.0.0.1:5140")
+	handler.logMessage("<12>Jan 1 00:00:02 web-01 nginx: warning three", "127.0.0.1:5140")
+	handler.forwarder.close()
+
+	select {
+	case lines := <-received:
+		want := []string{
+			"<11>Jan 1 00:00:00 web-01 nginx: error one",
+			"<14>Jan 1 00:00:01 web-01 nginx: info two",
+			"<12>Jan 1 00:00:02 web-01 nginx: warning three",
+		}
+		if strings.Join(lines, "|") != strings.Join(want, "|") {
+			t.Errorf("expected %q, got %q", want, lines)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for forwarded messages")
+	}
+}
+
+func TestForwarderReconnectsDroppedConnection(t *testing.T) {
+	defer func(interval time.Duration) { forwardHealthInterval = interval }(forwardHealthInterval)
+	forwardHealthInterval = 20 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	fw, err := newForwarder("tcp", ln.Addr().String(), 10, forwardBatch{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fw.close()
+	first := <-conns
+	// The upstream drops the idle link; the forwarder should notice without
+	// a message having to fail first.
+	first.Close()
+
+	var second net.Conn
+	select {
+	case second = <-conns:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the forwarder to reconnect after the connection was dropped")
+	}
+	defer second.Close()
+
+	fw.enqueue("<13>Jan 1 00:00:00 host app: after reconnect")
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(second).ReadString('\n')
+	if err != nil || line != "<13>Jan 1 00:00:00 host app: after reconnect\n" {
+		t.Errorf("expected the message on the new connection, got %q, %v", line, err)
+	}
+}
+
+// forwardLines forwards n messages over TCP with the given batch size and
+// returns the lines the upstream received and the number of writes made.
+func forwardLines(tb testing.TB, n, batchSize int) ([]string, uint64) {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}()
+
+	fw, err := newForwarder("tcp", ln.Addr().String(), n,
+		forwardBatch{size: batchSize, delay: 100 * time.Millisecond})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		fw.enqueue(fmt.Sprintf("<13>Jan 1 00:00:00 host app: message %d", i))
+	}
+	fw.close()
+	return <-received, fw.writes.Load()
+}
+
+func TestForwarderBatchesWrites(t *testing.T) {
+	for _, batchSize := range []int{0, 16 << 10} {
+		lines, writes := forwardLines(t, 1000, batchSize)
+		if len(lines) != 1000 {
+			t.Fatalf("batch size %d: expected 1000 lines, got %d", batchSize, len(lines))
+		}
+		for i, line := range lines {
+			if want := fmt.Sprintf("<13>Jan 1 00:00:00 host app: message %d", i); line != want {
+				t.Fatalf("batch size %d: line %d = %q, want %q", batchSize, i, line, want)
+			}
+		}
+		t.Logf("batch size %d: 1000 messages in %d writes", batchSize, writes)
+		if batchSize == 0 && writes != 1000 {
+			t.Errorf("expected one write per message without batching, got %d", writes)
+		}
+		// About 40 bytes per message, so a full batch holds about 400.
+		if batchSize > 0 && writes > 10 {
+			t.Errorf("expected batching to coalesce 1000 messages into a few writes, got %d", writes)
+		}
+	}
+}
+
+func TestForwarderBatchDelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	fw, err := newForwarder("tcp", ln.Addr().String(), 10,
+		forwardBatch{size: 1 << 20, delay: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fw.close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A batch far from full is still written after the delay.
+	fw.enqueue("<13>Jan 1 00:00:00 host app: lonely")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "<13>Jan 1 00:00:00 host app: lonely\n" {
+		t.Errorf("expected the message once the batch delay passed, got %q, %v", line, err)
+	}
+}
+
+func BenchmarkForwarderTCP(b *testing.B) {
+	for _, batchSize := range []int{0, 16 << 10} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			var writes uint64
+			for i := 0; i < b.N; i++ {
+				_, n := forwardLines(b, 10000, batchSize)
+				writes += n
+			}
+			b.ReportMetric(float64(writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func TestForwardLevelByName(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	level, err := syslog_client.ParseSeverity("warning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := createLogFileHandler("", 10, upstream.LocalAddr().String(), "udp", level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<11>Jan 1 00:00:00 web-01 nginx: error one", "127.0.0.1:5140")
+	handler.logMessage("<14>Jan 1 00:00:01 web-01 nginx: info two", "127.0.0.1:5140")
+	handler.logMessage("<12>Jan 1 00:00:02 web-01 nginx: warning three", "127.0.0.1:5140")
+	handler.logMessage("<15>Jan 1 00:00:03 web-01 nginx: debug four", "127.0.0.1:5140")
+	handler.forwarder.close()
+
+	got := readDatagrams(upstream)
+	want := []string{
+		"<11>Jan 1 00:00:00 web-01 nginx: error one",
+		"<12>Jan 1 00:00:02 web-01 nginx: warning three",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected only warning and above to be forwarded, got %q", got)
+	}
+
+	if err := Run([]string{"-l", "loud", "-a", "127.0.0.1:0", "-w", "127.0.0.1:0"}); err == nil || !strings.Contains(err.Error(), "forwarding level") {
+		t.Errorf("expected an invalid level to fail at startup, got %v", err)
+	}
+}
+
+func TestForwardProtocolValidated(t *testing.T) {
+	err := Run([]string{"-p", "tpc", "-r", "127.0.0.1:514", "-a", "127.0.0.1:0", "-w", "127.0.0.1:0"})
+	if err == nil || !strings.Contains(err.Error(), `unsupported forwarding protocol "tpc", use one of: udp, tcp`) {
+		t.Errorf("expected a typo in -p to fail at startup listing the valid protocols, got %v", err)
+	}
+	if _, _, _, err := parseForwardRoute("nginx=tpc://127.0.0.1:514", "udp"); err == nil || !strings.Contains(err.Error(), "use one of") {
+		t.Errorf("expected an invalid route protocol to be rejected, got %v", err)
+	}
+}
+
+// readDatagrams returns the datagrams received on conn until it is idle.
+func readDatagrams(conn net.PacketConn) []string {
+	var got []string
+	buf := make([]byte, 2048)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return got
+		}
+		got = append(got, strings.TrimSpace(string(buf[:n])))
+	}
+}
+
+func TestForwardRoutesByAppname(t *testing.T) {
+	upstreams := map[string]net.PacketConn{}
+	for _, name := range []string{"nginx", "sshd", "default"} {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		upstreams[name] = conn
+	}
+
+	handler, err := createLogFileHandler("", 10, upstreams["default"].LocalAddr().String(), "udp", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"nginx", "sshd"} {
+		pattern, proto, addr, err := parseForwardRoute("^"+name+"$=udp://"+upstreams[name].LocalAddr().String(), "tcp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handler.addForwardRoute(pattern, proto, addr, forwardBatch{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler.logMessage("<14>Jan 1 00:00:00 web-01 nginx: GET /", "127.0.0.1:5140")
+	handler.logMessage("<14>Jan 1 00:00:01 web-01 sshd: login", "127.0.0.1:5140")
+	handler.logMessage("<14>Jan 1 00:00:02 web-01 cron: job", "127.0.0.1:5140")
+	for _, route := range handler.forwardRoutes {
+		route.forwarder.close()
+	}
+	handler.forwarder.close()
+
+	want := map[string]string{
+		"nginx":   "<14>Jan 1 00:00:00 web-01 nginx: GET /",
+		"sshd":    "<14>Jan 1 00:00:01 web-01 sshd: login",
+		"default": "<14>Jan 1 00:00:02 web-01 cron: job",
+	}
+	for name, conn := range upstreams {
+		if got := readDatagrams(conn); len(got) != 1 || got[0] != want[name] {
+			t.Errorf("%s upstream: expected %q, got %q", name, want[name], got)
+		}
+	}
+}
+
+func TestForwardedFlag(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	handler, err := createLogFileHandler("", 10, upstream.LocalAddr().String(), "udp", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<11>Jan 1 00:00:00 web-01 nginx: error one", "127.0.0.1:5140")
+	handler.logMessage("<14>Jan 1 00:00:01 web-01 nginx: info two", "127.0.0.1:5140")
+	handler.forwarder.close()
+
+	if !handler.messages[0].Msg.Forwarded {
+		t.Error("expected the error message to be marked as forwarded")
+	}
+	if handler.messages[1].Msg.Forwarded {
+		t.Error("expected the info message below the forward level not to be marked as forwarded")
+	}
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(rows), "&#10003;") != 1 {
+		t.Errorf("expected one forwarded mark in %s", rows)
+	}
+}
+
+func TestReplayHandler(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	// Level 0 only forwards emergencies, so nothing is forwarded at ingest.
+	handler, err := createLogFileHandler("", 10, upstream.LocalAddr().String(), "udp", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<11>Jan 1 00:00:00 web-01 nginx: error one", "127.0.0.1:5140")
+	handler.logMessage("<14>Jan 1 00:00:01 db-01 mysqld: info two", "127.0.0.1:5140")
+	handler.logMessage("<12>Jan 1 00:00:02 web-01 nginx: warning three", "127.0.0.1:5140")
+
+	replay := func(query string) map[string]int {
+		rec := httptest.NewRecorder()
+		replayHandler(handler)(rec, httptest.NewRequest(http.MethodPost, "/replay"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("replay returned %d: %s", rec.Code, rec.Body.String())
+		}
+		var result map[string]int
+		json.NewDecoder(rec.Body).Decode(&result)
+		return result
+	}
+	if got := replay(""); got["replayed"] != 3 {
+		t.Errorf("expected 3 replayed messages, got %v", got)
+	}
+	handler.getConfig().AppName = "nginx"
+	if got := replay("?filter=true"); got["replayed"] != 2 {
+		t.Errorf("expected 2 filtered replayed messages, got %v", got)
+	}
+	handler.forwarder.close()
+	if got := readDatagrams(upstream); len(got) != 5 {
+		t.Errorf("expected 5 datagrams upstream, got %q", got)
+	}
+
+	disabled, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	replayHandler(disabled)(rec, httptest.NewRequest(http.MethodPost, "/replay", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 when forwarding is disabled, got %d", rec.Code)
+	}
+}
+
+func TestDrainHandler(t *testing.T) {
+	defer func(health, retry time.Duration) {
+		forwardHealthInterval, forwardRetryInterval = health, retry
+	}(forwardHealthInterval, forwardRetryInterval)
+	forwardHealthInterval, forwardRetryInterval = 10*time.Millisecond, 20*time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	handler, err := createLogFileHandler("", 10, addr, "tcp", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.forwarder.close()
+	first, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stop the upstream and give the health check time to notice.
+	first.Close()
+	ln.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		handler.logMessage(fmt.Sprintf("<13>Jan 1 00:00:00 host app: queued %d", i), "127.0.0.1:5140")
+	}
+	drain := func(timeout string) (int, map[string]int) {
+		rec := httptest.NewRecorder()
+		drainHandler(handler)(rec, httptest.NewRequest(http.MethodPost, "/drain?timeout="+timeout, nil))
+		var result map[string]int
+		json.NewDecoder(rec.Body).Decode(&result)
+		return rec.Code, result
+	}
+	if code, got := drain("50ms"); code != http.StatusGatewayTimeout || got["remaining"] != 5 {
+		t.Errorf("expected 5 messages to remain while the upstream is down, got %d %v", code, got)
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for len(lines) < 5 && scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	if code, got := drain("5s"); code != http.StatusOK || got["drained"] != 5 || got["remaining"] != 0 {
+		t.Errorf("expected all 5 messages drained once the upstream is back, got %d %v", code, got)
+	}
+	select {
+	case lines := <-received:
+		if len(lines) != 5 || lines[0] != "<13>Jan 1 00:00:00 host app: queued 0" {
+			t.Errorf("expected the queued messages in order, got %q", lines)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the drained messages")
+	}
+}
+
+func TestRunForwardsQueueOnShutdown(t *testing.T) {
+	// Each upstream server collects the lines of its connection until the
+	// server closes it.
+	type upstream struct {
+		ln    net.Listener
+		lines chan []string
+	}
+	upstreams := map[string]upstream{}
+	for _, name := range []string{"default", "sshd"} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		u := upstream{ln, make(chan []string, 1)}
+		go func() {
+			conn, err := u.ln.Accept()
+			if err != nil {
+				u.lines <- nil
+				return
+			}
+			defer conn.Close()
+			var lines []string
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				lines = append(lines, scanner.Text())
+			}
+			u.lines <- lines
+		}()
+		upstreams[name] = u
+	}
+
+	// Batches are only written when full, so the messages are still queued
+	// when the server shuts down.
+	addr, apiAddr := freeAddr(t, "udp"), freeAddr(t, "tcp")
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Run([]string{"-a", addr, "-w", apiAddr, "-p", "tcp",
+			"-r", upstreams["default"].ln.Addr().String(),
+			"-fwdroute", "^sshd$=tcp://" + upstreams["sshd"].ln.Addr().String(),
+			"-fwdbatch", "1048576", "-fwdbatchdelay", "1h"})
+	}()
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	messages := []string{
+		"<14>Jan 1 00:00:00 web-01 cron: job",
+		"<14>Jan 1 00:00:01 web-01 sshd: login",
+		"<14>Jan 1 00:00:02 web-01 cron: done",
+	}
+	// Debug messages are not forwarded, so they tell when the server is up
+	// without reaching the upstream servers.
+	received := func() int {
+		resp, err := http.Get("http://" + apiAddr + "/counters")
+		if err != nil {
+			return 0
+		}
+		defer resp.Body.Close()
+		var counters struct{ Total int }
+		json.NewDecoder(resp.Body).Decode(&counters)
+		return counters.Total
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	ready := 0
+	for ; ready == 0; ready = received() {
+		if time.Now().After(deadline) {
+			t.Fatal("the server did not start")
+		}
+		conn.Write([]byte("<15>Jan 1 00:00:00 web-01 test: ready"))
+		time.Sleep(20 * time.Millisecond)
+	}
+	for _, message := range messages {
+		conn.Write([]byte(message))
+	}
+	for received() < ready+len(messages) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the server received %d of %d messages", received()-ready, len(messages))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not shut down")
+	}
+	want := map[string][]string{
+		"default": {messages[0], messages[2]},
+		"sshd":    {messages[1]},
+	}
+	for name, u := range upstreams {
+		select {
+		case lines := <-u.lines:
+			if strings.Join(lines, "\n") != strings.Join(want[name], "\n") {
+				t.Errorf("%s upstream: expected %q, got %q", name, want[name], lines)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s upstream: the connection was not closed", name)
+		}
+	}
+}
+
+func TestForwarderEnqueueAfterClose(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	fw, err := newForwarder("udp", upstream.LocalAddr().String(), 10, forwardBatch{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.close()
+	// A request still running at shutdown may log a message after the
+	// forwarders are closed; it is dropped rather than sent on the queue.
+	if fw.enqueue("<13>Jan 1 00:00:00 host app: late") || fw.dropped.Load() != 1 {
+		t.Errorf("expected the late message to be dropped, got %d dropped", fw.dropped.Load())
+	}
+}
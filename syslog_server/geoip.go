@@ -0,0 +1,39 @@
+package syslog_server
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// geoIP resolves source addresses to a location using a MaxMind GeoIP2 or
+// GeoLite2 City database.
+type geoIP struct {
+	db *geoip2.Reader
+}
+
+func openGeoIP(path string) (*geoIP, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &geoIP{db: db}, nil
+}
+
+// lookup returns the ISO country code and English city name for the IP of
+// remoteAddr. Private, loopback and unparsable addresses are skipped.
+func (g *geoIP) lookup(remoteAddr string) (country, city string) {
+	ip := net.ParseIP(sourceIP(remoteAddr))
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return "", ""
+	}
+	record, err := g.db.City(ip)
+	if err != nil {
+		return "", ""
+	}
+	return record.Country.IsoCode, record.City.Names["en"]
+}
+
+func (g *geoIP) close() {
+	g.db.Close()
+}
@@ -0,0 +1,111 @@
+package syslog_server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mmdbValue encodes a value in the MaxMind DB data section format. Only the
+// types needed for a minimal City database are supported.
+func mmdbValue(v any) []byte {
+	var buf bytes.Buffer
+	switch v := v.(type) {
+	case string:
+		buf.WriteByte(2<<5 | byte(len(v)))
+		buf.WriteString(v)
+	case uint16:
+		buf.WriteByte(5<<5 | 2)
+		binary.Write(&buf, binary.BigEndian, v)
+	case uint32:
+		buf.WriteByte(6<<5 | 4)
+		binary.Write(&buf, binary.BigEndian, v)
+	case uint64:
+		buf.Write([]byte{0<<5 | 8, 9 - 7})
+		binary.Write(&buf, binary.BigEndian, v)
+	case []string:
+		buf.Write([]byte{0<<5 | byte(len(v)), 11 - 7})
+		for _, s := range v {
+			buf.Write(mmdbValue(s))
+		}
+	case [][2]any:
+		buf.WriteByte(7<<5 | byte(len(v)))
+		for _, kv := range v {
+			buf.Write(mmdbValue(kv[0]))
+			buf.Write(mmdbValue(kv[1]))
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeTestGeoIPDB writes an IPv4 City database containing a single record
+// for ip and returns its path.
+func writeTestGeoIPDB(t *testing.T, ip net.IP, country, city string) string {
+	t.Helper()
+	const nodeCount = 32
+	var db bytes.Buffer
+	ip4 := ip.To4()
+	for i := 0; i < nodeCount; i++ {
+		next := uint32(i + 1)
+		if i == nodeCount-1 {
+			next = nodeCount + 16 // pointer to offset 0 of the data section
+		}
+		records := [2]uint32{nodeCount, nodeCount}
+		records[ip4[i/8]>>(7-i%8)&1] = next
+		for _, r := range records {
+			db.Write([]byte{byte(r >> 16), byte(r >> 8), byte(r)})
+		}
+	}
+	db.Write(make([]byte, 16))
+	db.Write(mmdbValue([][2]any{
+		{"city", [][2]any{{"names", [][2]any{{"en", city}}}}},
+		{"country", [][2]any{{"iso_code", country}}},
+	}))
+	db.WriteString("\xab\xcd\xefMaxMind.com")
+	db.Write(mmdbValue([][2]any{
+		{"binary_format_major_version", uint16(2)},
+		{"binary_format_minor_version", uint16(0)},
+		{"build_epoch", uint64(0)},
+		{"database_type", "GeoLite2-City"},
+		{"ip_version", uint16(4)},
+		{"languages", []string{"en"}},
+		{"node_count", uint32(nodeCount)},
+		{"record_size", uint16(24)},
+	}))
+
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	if err := os.WriteFile(path, db.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGeoIPEnrichment(t *testing.T) {
+	geo, err := openGeoIP(writeTestGeoIPDB(t, net.ParseIP("81.2.69.142"), "GB", "London"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer geo.close()
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.geoIP = geo
+	handler.logMessage("<13>Jan 1 00:00:00 web-01 sshd: login", "81.2.69.142:514")
+	handler.logMessage("<13>Jan 1 00:00:01 web-02 sshd: login", "192.168.1.10:514")
+	handler.logMessage("<13>Jan 1 00:00:02 web-03 sshd: login", "8.8.8.8:514")
+
+	msg := handler.messages[0].Msg
+	if msg.Country != "GB" || msg.City != "London" {
+		t.Errorf("expected London, GB for a known address, got %q, %q", msg.City, msg.Country)
+	}
+	for _, stored := range handler.messages[1:] {
+		if stored.Msg.Country != "" || stored.Msg.City != "" {
+			t.Errorf("expected no location for %q, got %q, %q", stored.Raw, stored.Msg.City, stored.Msg.Country)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package syslog_server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaWriter is the subset of *kafka.Writer used by kafkaOutput, so tests
+// can substitute a stub for a real broker.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaOutput publishes parsed messages to a Kafka topic asynchronously.
+// Messages are queued in a bounded buffer and dropped when it is full so
+// that a slow or unavailable broker never blocks ingest.
+type kafkaOutput struct {
+	writer    kafkaWriter
+	queue     chan kafka.Message
+	batchSize int
+	dropped   atomic.Uint64
+	wg        sync.WaitGroup
+}
+
+func newKafkaWriter(brokers, topic string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: 10 * time.Millisecond,
+	}
+}
+
+func newKafkaOutput(writer kafkaWriter, bufferSize int) *kafkaOutput {
+	ko := &kafkaOutput{
+		writer:    writer,
+		queue:     make(chan kafka.Message, bufferSize),
+		batchSize: 100,
+	}
+	ko.wg.Add(1)
+	go ko.run()
+	return ko
+}
+
+// publish queues msg keyed by hostname so a host's messages share a partition.
+func (ko *kafkaOutput) publish(msg syslogMsg) {
+	value, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("Error marshaling message for Kafka", "error", err)
+		return
+	}
+	select {
+	case ko.queue <- kafka.Message{Key: []byte(msg.Hostname), Value: value}:
+	default:
+		ko.dropped.Add(1)
+	}
+}
+
+func (ko *kafkaOutput) run() {
+	defer ko.wg.Done()
+	for msg := range ko.queue {
+		batch := []kafka.Message{msg}
+	drain:
+		for len(batch) < ko.batchSize {
+			select {
+			case next, ok := <-ko.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := ko.writer.WriteMessages(ctx, batch...); err != nil {
+			slog.Error("Error publishing messages to Kafka", "count", len(batch), "error", err)
+		}
+		cancel()
+	}
+}
+
+// Write queues a parsed message for publishing; malformed messages are not
+// published.
+func (ko *kafkaOutput) Write(stored storedMessage) error {
+	if !stored.Malformed {
+		ko.publish(stored.Msg)
+	}
+	return nil
+}
+
+// Close publishes the queued messages and closes the writer.
+func (ko *kafkaOutput) Close() error {
+	close(ko.queue)
+	ko.wg.Wait()
+	if n := ko.dropped.Load(); n > 0 {
+		slog.Warn("Kafka output dropped messages due to a full buffer", "count", n)
+	}
+	if err := ko.writer.Close(); err != nil {
+		return fmt.Errorf("error closing Kafka writer: %w", err)
+	}
+	return nil
+}
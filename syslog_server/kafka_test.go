@@ -0,0 +1,67 @@
+package syslog_server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type stubKafkaWriter struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	block    chan struct{}
+}
+
+func (w *stubKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.block != nil {
+		<-w.block
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *stubKafkaWriter) Close() error { return nil }
+
+func TestKafkaOutputPublish(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &stubKafkaWriter{}
+	handler.addOutput(newKafkaOutput(writer, 10))
+	handler.logMessage("<14>Jan 1 00:00:00 web-01 nginx: GET /index.html", "127.0.0.1:5140")
+	handler.logMessage("<11>Jan 1 00:00:01 db-01 mysqld: connection lost", "127.0.0.1:5140")
+	handler.closeOutputs()
+
+	if len(writer.messages) != 2 {
+		t.Fatalf("expected 2 published messages, got %d", len(writer.messages))
+	}
+	if string(writer.messages[0].Key) != "web-01" || string(writer.messages[1].Key) != "db-01" {
+		t.Errorf("messages should be keyed by hostname, got %q and %q", writer.messages[0].Key, writer.messages[1].Key)
+	}
+	var msg syslogMsg
+	if err := json.Unmarshal(writer.messages[1].Value, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Appname != "mysqld" || msg.Message != "connection lost" {
+		t.Errorf("unexpected message value %+v", msg)
+	}
+}
+
+func TestKafkaOutputDropsWhenFull(t *testing.T) {
+	writer := &stubKafkaWriter{block: make(chan struct{})}
+	ko := newKafkaOutput(writer, 2)
+	for i := 0; i < 10; i++ {
+		ko.publish(syslogMsg{Hostname: "h", Message: "m"})
+	}
+	if ko.dropped.Load() == 0 {
+		t.Errorf("expected messages to be dropped when the buffer is full")
+	}
+	close(writer.block)
+	ko.Close()
+}
@@ -0,0 +1,76 @@
+package syslog_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// logLine holds the fields available to a -logformat template.
+type logLine struct {
+	RemoteAddr string
+	Timestamp  string
+	Host       string
+	App        string
+	Severity   string
+	Message    string
+}
+
+// parseLogFormat parses a -logformat template. A trailing newline is added
+// if the template does not end with one.
+func parseLogFormat(format string) (*template.Template, error) {
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	tmpl, err := template.New("logformat").Option("missingkey=error").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log format: %w", err)
+	}
+	// Catch references to unknown fields at startup rather than per message.
+	if err := tmpl.Execute(&bytes.Buffer{}, logLine{}); err != nil {
+		return nil, fmt.Errorf("invalid log format: %w", err)
+	}
+	return tmpl, nil
+}
+
+// logEntry renders the line written to the log files for stored, a JSON
+// object with -logformat json.
+func (lh *logFileHandler) logEntry(stored storedMessage) string {
+	if !lh.jsonLog {
+		return lh.formatLogEntry(stored.Raw, stored.RemoteAddr)
+	}
+	msg := stored.Msg
+	if stored.Malformed {
+		msg = stored.malformedMessage()
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return skipNumericPrefix(stored.Raw) + "\n"
+	}
+	return string(line) + "\n"
+}
+
+// formatLogEntry renders the line written to the log files for message. It
+// is the message without its priority unless a -logformat is configured.
+func (lh *logFileHandler) formatLogEntry(message, remoteAddr string) string {
+	if lh.logFormat == nil {
+		return skipNumericPrefix(message) + "\n"
+	}
+	line := logLine{RemoteAddr: remoteAddr, Message: skipNumericPrefix(message)}
+	if msg, err := parseSyslogMessage(message); err == nil {
+		line.Timestamp = msg.Timestamp
+		line.Host = msg.Hostname
+		line.App = msg.Appname
+		if msg.Severity >= 0 && msg.Severity < len(severityNames) {
+			line.Severity = severityNames[msg.Severity]
+		}
+		line.Message = msg.Message
+	}
+	var buf bytes.Buffer
+	if err := lh.logFormat.Execute(&buf, line); err != nil {
+		return skipNumericPrefix(message) + "\n"
+	}
+	return buf.String()
+}
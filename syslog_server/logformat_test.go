@@ -0,0 +1,110 @@
+package syslog_server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogFormat(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "syslog.log")
+	handler, err := createLogFileHandler(logFile, 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logFormat, err = parseLogFormat("{{.RemoteAddr}} {{.Timestamp}} {{.Host}} {{.App}} [{{.Severity}}] {{.Message}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<11>Jan 1 00:00:00 web-01 nginx: upstream timed out", "10.0.0.7:514")
+	handler.logMessage("<13>not syslog", "10.0.0.8:514")
+	handler.logger.Close()
+
+	got, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "10.0.0.7:514 Jan 1 00:00:00 web-01 nginx [err] upstream timed out\n" +
+		"10.0.0.8:514    [] not syslog\n"
+	if string(got) != want {
+		t.Errorf("log file = %q, want %q", got, want)
+	}
+
+	// A priority out of range is treated as a missing one, user.notice.
+	if got := handler.formatLogEntry("<-9>Jan 1 00:00:00 web-01 nginx: negative", "10.0.0.9:514"); !strings.HasSuffix(got, "[notice] negative\n") {
+		t.Errorf("unexpected line %q for a negative priority", got)
+	}
+
+	if _, err := parseLogFormat("{{.Hostname}}"); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+	if _, err := parseLogFormat("{{.Message"); err == nil {
+		t.Error("expected an error for a malformed template")
+	}
+}
+
+func TestIncludeRawInJSON(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "syslog.log")
+	handler, err := createLogFileHandler(logFile, 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.jsonLog = true
+	handler.config.IncludeRaw = true
+	raw := "<11>Jan 1 00:00:00 web-01 nginx[42]: upstream  timed out"
+	handler.logMessage(raw, "10.0.0.7:514")
+	handler.logMessage("<13>not syslog", "10.0.0.8:514")
+	handler.logger.Close()
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a JSON line per message, got %q", data)
+	}
+	var logged []syslogMsg
+	for _, line := range lines {
+		var msg syslogMsg
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		logged = append(logged, msg)
+	}
+
+	rec := httptest.NewRecorder()
+	messagesHandler(handler, testTemplates(t))(rec, httptest.NewRequest(http.MethodGet, "/messages?format=json", nil))
+	var listed []syslogMsg
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 {
+		t.Fatalf("expected both messages from the API, got %+v", listed)
+	}
+
+	for _, msg := range []syslogMsg{logged[0], listed[0]} {
+		if msg.Hostname != "web-01" || msg.Appname != "nginx" || msg.Pid != "42" || msg.Severity != 3 || msg.Message != "upstream  timed out" {
+			t.Errorf("unexpected parsed fields %+v", msg)
+		}
+		if msg.Raw != raw {
+			t.Errorf("raw = %q, want %q", msg.Raw, raw)
+		}
+	}
+	for _, msg := range []syslogMsg{logged[1], listed[1]} {
+		if msg.Raw != "<13>not syslog" || msg.Message != "not syslog" {
+			t.Errorf("expected the minimal message with its raw text, got %+v", msg)
+		}
+	}
+
+	// Without -includeraw the field is left out.
+	handler.config.IncludeRaw = false
+	handler.logMessage(raw, "10.0.0.7:514")
+	if body, _ := json.Marshal(handler.messages[len(handler.messages)-1].Msg); strings.Contains(string(body), `"raw"`) {
+		t.Errorf("expected no raw field, got %s", body)
+	}
+}
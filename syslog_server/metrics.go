@@ -0,0 +1,56 @@
+package syslog_server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"syslog/syslog_client"
+)
+
+// metricsHandler exposes the server counters in the Prometheus text format.
+func metricsHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetric(w, "syslog_messages_received_total", "counter", "Messages received since startup.", handler.received.Load())
+		writePriorityMetric(w, handler)
+		writeMetric(w, "syslog_tcp_connections", "gauge", "TCP connections currently open.", handler.tcpConnections.Load())
+		writeMetric(w, "syslog_tcp_connections_refused_total", "counter", "TCP connections closed because the -tcpmaxconns limit was reached.", handler.tcpRefused.Load())
+		if handler.sampler != nil {
+			writeMetric(w, "syslog_messages_sampled_out_total", "counter", "Messages dropped by flood sampling.", handler.sampler.sampledOut.Load())
+		}
+		if handler.sources != nil {
+			writeMetric(w, "syslog_messages_denied_total", "counter", "Messages dropped because of the source allow and deny lists.", handler.sources.denied.Load())
+		}
+		if pool := handler.workerPool; pool != nil {
+			writeMetric(w, "syslog_queue_depth", "gauge", "Messages waiting in the worker queues.", pool.depth())
+			writeMetric(w, "syslog_queue_high_water", "gauge", "Most messages seen waiting in a single worker queue.", pool.highWater.Load())
+			writeMetric(w, "syslog_queue_dropped_total", "counter", "Messages dropped because a worker queue was full.", pool.dropped.Load())
+		}
+	}
+}
+
+// writePriorityMetric writes the messages received by facility and
+// severity. Messages without a valid priority count as user.notice. Only
+// the combinations seen so far are written.
+func writePriorityMetric(w io.Writer, handler *logFileHandler) {
+	const name = "syslog_messages_received_by_priority_total"
+	fmt.Fprintf(w, "# HELP %s Messages received since startup by facility and severity.\n# TYPE %s counter\n", name, name)
+	for facility := range handler.priorityCounts {
+		for severity := range handler.priorityCounts[facility] {
+			if n := handler.priorityCounts[facility][severity].Load(); n > 0 {
+				fmt.Fprintf(w, "%s{facility=%q,severity=%q} %d\n", name,
+					syslog_client.FacilityName(facility), severityNames[severity], n)
+			}
+		}
+	}
+}
+
+// writeMetric writes a single unlabeled metric with its HELP and TYPE lines.
+func writeMetric(w io.Writer, name, kind, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
@@ -0,0 +1,40 @@
+package syslog_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsByPriority(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<34>Jan 1 00:00:00 web-01 sshd: authentication failure", "10.0.0.1:514")
+	handler.logMessage("<34>Jan 1 00:00:01 web-01 sshd: authentication failure", "10.0.0.1:514")
+	handler.logMessage("<165>Jan 1 00:00:02 web-01 app: started", "10.0.0.1:514")
+	handler.logMessage("no priority at all", "10.0.0.1:514")
+	// Priorities out of range count as notice too, rather than indexing
+	// the counters out of bounds.
+	handler.processMessage("<-9>Jan 1 00:00:03 web-01 app: negative", "10.0.0.1:514")
+	handler.processMessage("<192>Jan 1 00:00:04 web-01 app: too large", "10.0.0.1:514")
+
+	rec := httptest.NewRecorder()
+	metricsHandler(handler)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE syslog_messages_received_by_priority_total counter\n",
+		`syslog_messages_received_by_priority_total{facility="auth",severity="crit"} 2` + "\n",
+		`syslog_messages_received_by_priority_total{facility="local4",severity="notice"} 1` + "\n",
+		`syslog_messages_received_by_priority_total{facility="user",severity="notice"} 3` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in the metrics:\n%s", want, body)
+		}
+	}
+	if n := strings.Count(body, "syslog_messages_received_by_priority_total{"); n != 3 {
+		t.Errorf("expected only the 3 priorities seen, got %d series:\n%s", n, body)
+	}
+}
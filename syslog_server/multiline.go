@@ -0,0 +1,96 @@
+package syslog_server
+
+import (
+	"sync"
+	"time"
+)
+
+// maxMultilineLines bounds how many continuation lines are joined into one
+// message, so a source that never sends a new message cannot grow it forever.
+const maxMultilineLines = 1000
+
+// multilineBuffer reassembles messages split over several lines, such as
+// stack traces sent one datagram per line. Lines without a <pri> prefix that
+// arrive within window of a message from the same source IP are appended to
+// its body. Each message is held until window passes without a continuation
+// line, or the source sends its next message, and is then passed to emit.
+type multilineBuffer struct {
+	window  time.Duration
+	emit    func(message, remoteAddr string)
+	mu      sync.Mutex
+	pending map[string]*pendingMessage
+}
+
+type pendingMessage struct {
+	message    string
+	remoteAddr string
+	lines      int
+	timer      *time.Timer
+}
+
+func newMultilineBuffer(window time.Duration, emit func(message, remoteAddr string)) *multilineBuffer {
+	return &multilineBuffer{
+		window:  window,
+		emit:    emit,
+		pending: map[string]*pendingMessage{},
+	}
+}
+
+// add buffers message or appends it to the pending message of its source.
+func (mb *multilineBuffer) add(message, remoteAddr string) {
+	source := sourceIP(remoteAddr)
+	_, _, err := parsePriority(message)
+	continuation := err != nil
+
+	mb.mu.Lock()
+	previous := mb.pending[source]
+	if continuation && previous != nil && previous.lines < maxMultilineLines {
+		previous.message += "\n" + message
+		previous.lines++
+		previous.timer.Reset(mb.window)
+		mb.mu.Unlock()
+		return
+	}
+	if previous != nil {
+		previous.timer.Stop()
+		delete(mb.pending, source)
+	}
+	if !continuation {
+		p := &pendingMessage{message: message, remoteAddr: remoteAddr, lines: 1}
+		p.timer = time.AfterFunc(mb.window, func() { mb.expire(source, p) })
+		mb.pending[source] = p
+	}
+	mb.mu.Unlock()
+
+	if previous != nil {
+		mb.emit(previous.message, previous.remoteAddr)
+	}
+	if continuation {
+		// A stray line with no message to attach to.
+		mb.emit(message, remoteAddr)
+	}
+}
+
+// expire emits p once no continuation line arrived within the window.
+func (mb *multilineBuffer) expire(source string, p *pendingMessage) {
+	mb.mu.Lock()
+	if mb.pending[source] != p {
+		mb.mu.Unlock()
+		return
+	}
+	delete(mb.pending, source)
+	mb.mu.Unlock()
+	mb.emit(p.message, p.remoteAddr)
+}
+
+// flush emits all pending messages immediately.
+func (mb *multilineBuffer) flush() {
+	mb.mu.Lock()
+	pending := mb.pending
+	mb.pending = map[string]*pendingMessage{}
+	mb.mu.Unlock()
+	for _, p := range pending {
+		p.timer.Stop()
+		mb.emit(p.message, p.remoteAddr)
+	}
+}
@@ -0,0 +1,69 @@
+package syslog_server
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMultilineReassemblesStackTrace(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.multiline = newMultilineBuffer(300*time.Millisecond, handler.processMessage)
+
+	for _, line := range []struct{ message, remoteAddr string }{
+		{"<11>Jan 1 00:00:00 web-01 java: Exception in thread \"main\" java.lang.NullPointerException", "10.0.0.1:40000"},
+		{"at com.example.Foo.bar(Foo.java:10)", "10.0.0.1:40000"},
+		// Another source interleaving does not break the trace.
+		{"<14>Jan 1 00:00:00 db-01 postgres: checkpoint complete", "10.0.0.2:40000"},
+		{"at com.example.Main.main(Main.java:5)", "10.0.0.1:40001"},
+		{"<14>Jan 1 00:00:01 web-01 java: recovered", "10.0.0.1:40000"},
+	} {
+		handler.logMessage(line.message, line.remoteAddr)
+	}
+	// The trace is complete once its source sends the next message.
+	handler.mu.Lock()
+	messages := rawMessages(handler.messages)
+	handler.mu.Unlock()
+	want := "<11>Jan 1 00:00:00 web-01 java: Exception in thread \"main\" java.lang.NullPointerException\n" +
+		"at com.example.Foo.bar(Foo.java:10)\n" +
+		"at com.example.Main.main(Main.java:5)"
+	if len(messages) != 1 || messages[0] != want {
+		t.Fatalf("expected the reassembled trace, got %q", messages)
+	}
+	msg, err := parseSyslogMessage(messages[0])
+	if err != nil || msg.Appname != "java" || msg.Severity != 3 {
+		t.Errorf("expected the trace to parse as one java error, got %+v, %v", msg, err)
+	}
+
+	// The others are emitted once the window passes without continuations.
+	time.Sleep(600 * time.Millisecond)
+	handler.mu.Lock()
+	messages = rawMessages(handler.messages)
+	handler.mu.Unlock()
+	if len(messages) != 3 || !slices.Contains(messages, "<14>Jan 1 00:00:01 web-01 java: recovered") {
+		t.Errorf("expected the pending messages to expire, got %q", messages)
+	}
+	if handler.received.Load() != 3 {
+		t.Errorf("expected 3 messages counted, got %d", handler.received.Load())
+	}
+}
+
+func TestMultilineStrayAndFlush(t *testing.T) {
+	var got []string
+	mb := newMultilineBuffer(time.Hour, func(message, remoteAddr string) {
+		got = append(got, message)
+	})
+	mb.add("continuation without a message", "10.0.0.1:514")
+	mb.add("<14>Jan 1 00:00:00 host app: first", "10.0.0.1:514")
+	mb.add("second line", "10.0.0.1:514")
+	if len(got) != 1 || got[0] != "continuation without a message" {
+		t.Fatalf("expected the stray line to pass through, got %q", got)
+	}
+	mb.flush()
+	if len(got) != 2 || got[1] != "<14>Jan 1 00:00:00 host app: first\nsecond line" {
+		t.Errorf("expected flush to emit the pending message, got %q", got)
+	}
+}
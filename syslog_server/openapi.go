@@ -0,0 +1,413 @@
+package syslog_server
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// openAPISchemaNames are the Go types described as reusable component
+// schemas of the OpenAPI document, by name. Other structs are inlined.
+var openAPISchemaNames = map[reflect.Type]string{
+	reflect.TypeFor[syslogMsg]():      "Message",
+	reflect.TypeFor[anomalyContext](): "AnomalyContext",
+	reflect.TypeFor[Config]():         "Config",
+	reflect.TypeFor[FilterPreset]():   "FilterPreset",
+	reflect.TypeFor[SeverityRule]():   "SeverityRule",
+	reflect.TypeFor[RedactRule]():     "RedactRule",
+	reflect.TypeFor[tailResponse]():   "TailResponse",
+	reflect.TypeFor[llmTestResult]():  "LLMTestResult",
+	reflect.TypeFor[BuildInfo]():      "BuildInfo",
+	reflect.TypeFor[MessageRequest](): "MessageRequest",
+}
+
+// openAPIHandler serves the OpenAPI 3 description of the REST API, for
+// generating clients.
+func openAPIHandler(info BuildInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, r, openAPISpec(info))
+	}
+}
+
+// openAPISpec returns the OpenAPI document of the handlers in apiHandlers.
+// Parameters and status codes are written by hand next to each other here;
+// the schemas of JSON bodies are generated from the Go types the handlers
+// encode, so they follow changes to those types.
+func openAPISpec(info BuildInfo) map[string]any {
+	schemas := openAPISchemas{names: openAPISchemaNames, schemas: map[string]any{}}
+	message := schemas.of(reflect.TypeFor[syslogMsg]())
+	messages := map[string]any{"type": "array", "items": message}
+	counts := func(names ...string) map[string]any {
+		properties := map[string]any{}
+		for _, name := range names {
+			properties[name] = map[string]any{"type": "integer"}
+		}
+		return map[string]any{"type": "object", "properties": properties, "required": names}
+	}
+	forwardingDisabled := textResponse("Forwarding is disabled")
+
+	paths := map[string]any{
+		"/messages": map[string]any{
+			"get": operation("listMessages", "List the buffered messages matching the config filters",
+				"Returns JSON with format=json or an Accept: application/json header, otherwise the HTML table rows of the web UI. limit and offset only apply to JSON.",
+				[]any{
+					queryParam("sort", "Field to sort by; without it messages are in arrival order.", enumSchema("time", "host", "app", "severity")),
+					queryParam("order", "Sort direction.", enumSchema("asc", "desc")),
+					queryParam("format", "Return JSON instead of HTML.", enumSchema("json")),
+					queryParam("limit", "Most messages to return; 0 returns all.", intSchema(0)),
+					queryParam("offset", "Messages to skip.", intSchema(0)),
+				},
+				map[string]any{
+					"200": map[string]any{
+						"description": "The matching messages.",
+						"content": map[string]any{
+							"application/json": map[string]any{"schema": messages},
+							"text/html":        map[string]any{"schema": map[string]any{"type": "string"}},
+						},
+					},
+					"400": textResponse("Invalid sort, order, limit or offset"),
+				}),
+			"post": withBody(operation("postMessages", "Receive syslog messages",
+				"Each message is processed as if received over the network from the client address.",
+				nil,
+				map[string]any{
+					"200": jsonResponse("The messages were received.", map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"status":  map[string]any{"type": "string"},
+							"message": map[string]any{"type": "string"},
+						},
+					}),
+					"400": textResponse("Invalid request body"),
+					"413": textResponse("The body is larger than -maxbody"),
+				}),
+				schemas.of(reflect.TypeFor[MessageRequest]()), "application/json"),
+		},
+		"/messages/{id}": map[string]any{
+			"get": operation("getMessage", "Get a message by ID",
+				"Looks in the SQLite store with -db when the message is no longer buffered.",
+				[]any{pathParam("id", "Message ID.", intSchema(1))},
+				map[string]any{
+					"200": jsonResponse("The message.", message),
+					"400": textResponse("Invalid message ID"),
+					"404": textResponse("Message not found"),
+				}),
+		},
+		"/messages/tail": map[string]any{
+			"get": operation("tailMessages", "Poll for new messages",
+				"Returns the buffered messages after the since cursor that match the config filters. Pass the returned cursor as since on the next poll.",
+				[]any{queryParam("since", "Cursor returned by the previous poll.", intSchema(0))},
+				map[string]any{
+					"200": jsonResponse("The new messages.", schemas.of(reflect.TypeFor[tailResponse]())),
+					"400": textResponse("Invalid cursor"),
+				}),
+		},
+		"/events": map[string]any{
+			"get": operation("streamEvents", "Stream new messages as Server-Sent Events",
+				"Each \"message\" event has the message ID and the message as JSON data.",
+				[]any{queryParam("severity", "Least severe message to stream; defaults to the configured event severity.",
+					enumSchema(severityNames...))},
+				map[string]any{
+					"200": map[string]any{
+						"description": "The event stream.",
+						"content":     map[string]any{"text/event-stream": map[string]any{"schema": map[string]any{"type": "string"}}},
+					},
+					"400": textResponse("Invalid severity"),
+				}),
+		},
+		"/config": map[string]any{
+			"get": operation("getConfig", "Get the config",
+				"The API key and webhook URLs are left out.",
+				nil,
+				map[string]any{"200": jsonResponse("The config.", schemas.of(reflect.TypeFor[Config]()))}),
+			"post": withBody(operation("updateConfig", "Update the message filters",
+				"Sets the filters from the settings form, or applies a saved preset with applyPreset.",
+				nil,
+				map[string]any{
+					"200": map[string]any{"description": "The config was updated."},
+					"400": textResponse("Invalid form value"),
+					"404": textResponse("Unknown preset"),
+				}),
+				map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"applyPreset":       map[string]any{"type": "string", "description": "Name of a saved preset to apply; the other fields are ignored."},
+						"severity":          map[string]any{"type": "integer", "minimum": 0, "maximum": 7},
+						"maxMessages":       intSchema(0),
+						"messagepattern":    map[string]any{"type": "string", "description": "Substring, or regular expression if it has metacharacters."},
+						"appname":           map[string]any{"type": "string", "description": "Comma separated app names, matching any."},
+						"hostname":          map[string]any{"type": "string", "description": "Comma separated host names, matching any."},
+						"anomaliesOnly":     checkboxSchema(),
+						"hostFromSource":    checkboxSchema(),
+						"showMalformedOnly": checkboxSchema(),
+						"displayTimezone":   map[string]any{"type": "string"},
+						"sourceTimezone":    map[string]any{"type": "string"},
+						"savePreset":        map[string]any{"type": "string", "description": "Save the filters as a preset with this name."},
+					},
+				}, "application/x-www-form-urlencoded"),
+		},
+		"/config/test-llm": map[string]any{
+			"post": operation("testLLM", "Test the LLM connection",
+				"Sends a trivial anomaly request with the configured LLM settings.",
+				nil,
+				map[string]any{
+					"200": jsonResponse("The LLM answered.", schemas.of(reflect.TypeFor[llmTestResult]())),
+					"502": jsonResponse("The LLM request failed.", schemas.of(reflect.TypeFor[llmTestResult]())),
+				}),
+		},
+		"/stats": map[string]any{
+			"get": operation("getStats", "Get sampling, access list and worker queue statistics",
+				"Only the statistics of enabled features are included.",
+				nil,
+				map[string]any{"200": jsonResponse("The statistics.", map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"sampledOut":     map[string]any{"type": "integer"},
+						"denied":         map[string]any{"type": "integer"},
+						"workerDropped":  map[string]any{"type": "integer"},
+						"queueDepth":     map[string]any{"type": "integer"},
+						"queueHighWater": map[string]any{"type": "integer"},
+					},
+				})}),
+		},
+		"/counters": map[string]any{
+			"get": operation("getCounters", "Count the messages received since startup",
+				"",
+				nil,
+				map[string]any{"200": jsonResponse("The counters.", map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"total":    map[string]any{"type": "integer"},
+						"severity": map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "integer"}},
+					},
+				})}),
+		},
+		"/metrics": map[string]any{
+			"get": operation("getMetrics", "Get the counters in the Prometheus text format",
+				"",
+				nil,
+				map[string]any{"200": map[string]any{
+					"description": "The metrics.",
+					"content":     map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}},
+				}}),
+		},
+		"/search": map[string]any{
+			"get": operation("searchMessages", "Search the raw text of the buffered messages",
+				"Matches ignore case.",
+				[]any{
+					queryParam("q", "Text to search for.", map[string]any{"type": "string"}),
+					queryParam("regex", "Treat q as a regular expression.", map[string]any{"type": "boolean"}),
+				},
+				map[string]any{
+					"200": jsonResponse("The matching messages.", messages),
+					"400": textResponse("Invalid regular expression"),
+				}),
+		},
+		"/ingest": map[string]any{
+			"post": withBody(operation("ingestMessages", "Receive newline-delimited messages from log agents",
+				"A text/plain body has one raw message per line; with application/x-ndjson each line is an object whose message or log field is the raw message. The body may be gzip encoded.",
+				[]any{map[string]any{
+					"name":   "Content-Encoding",
+					"in":     "header",
+					"schema": enumSchema("gzip"),
+				}},
+				map[string]any{
+					"200": jsonResponse("The messages were received.", counts("received")),
+					"400": textResponse("Invalid body"),
+				}),
+				map[string]any{"type": "string"}, "text/plain", "application/x-ndjson"),
+		},
+		"/replay": map[string]any{
+			"post": operation("replayMessages", "Forward the buffered messages again",
+				"",
+				[]any{queryParam("filter", "Only replay messages matching the config filters.", map[string]any{"type": "boolean"})},
+				map[string]any{
+					"200": jsonResponse("The messages were replayed.", counts("replayed")),
+					"409": forwardingDisabled,
+				}),
+		},
+		"/drain": map[string]any{
+			"post": operation("drainForwarders", "Wait until the forward queues are empty",
+				"",
+				[]any{queryParam("timeout", "How long to wait, as a Go duration.", map[string]any{"type": "string", "default": "30s"})},
+				map[string]any{
+					"200": jsonResponse("The queues were drained.", counts("drained", "remaining")),
+					"400": textResponse("Invalid timeout"),
+					"409": forwardingDisabled,
+					"504": jsonResponse("The timeout passed first.", counts("drained", "remaining")),
+				}),
+		},
+		"/version": map[string]any{
+			"get": operation("getVersion", "Get the build information",
+				"",
+				nil,
+				map[string]any{"200": jsonResponse("The build information.", schemas.of(reflect.TypeFor[BuildInfo]()))}),
+		},
+		"/echo": map[string]any{
+			"post": withBody(operation("echo", "Return the request body",
+				"For checking that the API is up.",
+				nil,
+				map[string]any{
+					"200": map[string]any{
+						"description": "The request body, with its content type.",
+						"content":     map[string]any{"*/*": map[string]any{"schema": map[string]any{}}},
+					},
+					"413": textResponse("The body is larger than 1 MiB"),
+				}),
+				map[string]any{}, "*/*"),
+		},
+		"/openapi.json": map[string]any{
+			"get": operation("getOpenAPI", "Get this OpenAPI document",
+				"",
+				nil,
+				map[string]any{"200": jsonResponse("The OpenAPI document.", map[string]any{"type": "object"})}),
+		},
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "syslog server",
+			"description": "REST API of the syslog server web UI.",
+			"version":     info.Version,
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas.schemas},
+	}
+}
+
+// operation describes an HTTP method of a path.
+func operation(id, summary, description string, parameters []any, responses map[string]any) map[string]any {
+	op := map[string]any{
+		"operationId": id,
+		"summary":     summary,
+		"responses":   responses,
+	}
+	if description != "" {
+		op["description"] = description
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+	return op
+}
+
+// withBody adds a required request body with schema in each of the media
+// types to op.
+func withBody(op map[string]any, schema map[string]any, mediaTypes ...string) map[string]any {
+	content := map[string]any{}
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = map[string]any{"schema": schema}
+	}
+	op["requestBody"] = map[string]any{"required": true, "content": content}
+	return op
+}
+
+func queryParam(name, description string, schema map[string]any) map[string]any {
+	return map[string]any{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+func pathParam(name, description string, schema map[string]any) map[string]any {
+	return map[string]any{"name": name, "in": "path", "required": true, "description": description, "schema": schema}
+}
+
+func jsonResponse(description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+// textResponse describes the plain text error responses of http.Error.
+func textResponse(description string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}},
+	}
+}
+
+func enumSchema(values ...string) map[string]any {
+	return map[string]any{"type": "string", "enum": values}
+}
+
+func intSchema(minimum int) map[string]any {
+	return map[string]any{"type": "integer", "minimum": minimum}
+}
+
+// checkboxSchema is an HTML checkbox, sent as "on" when checked.
+func checkboxSchema() map[string]any {
+	return enumSchema("on")
+}
+
+// openAPISchemas generates JSON schemas from Go types the way encoding/json
+// encodes them. Named types are added to schemas and referenced.
+type openAPISchemas struct {
+	names   map[reflect.Type]string
+	schemas map[string]any
+}
+
+func (s *openAPISchemas) of(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.of(t.Elem())
+	case reflect.Struct:
+		name, ok := s.names[t]
+		if !ok {
+			return s.object(t)
+		}
+		if _, found := s.schemas[name]; !found {
+			// Added before its fields, for types that refer to themselves.
+			s.schemas[name] = nil
+			s.schemas[name] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return intSchema(0)
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{}
+}
+
+// object describes the exported fields of a struct. Fields without
+// omitempty are always present, so they are required.
+func (s *openAPISchemas) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.of(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
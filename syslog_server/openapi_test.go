@@ -0,0 +1,156 @@
+package syslog_server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := testTemplates(t)
+	mux := newMux(handler, tmpl)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a JSON document, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") || spec.Info.Title == "" || spec.Info.Version == "" {
+		t.Errorf("expected an OpenAPI 3 document with a title and version, got %q %+v", spec.OpenAPI, spec.Info)
+	}
+
+	// Every operation is well formed and every reference resolves.
+	var refs []string
+	var collectRefs func(v any)
+	collectRefs = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for key, value := range v {
+				if ref, ok := value.(string); ok && key == "$ref" {
+					refs = append(refs, ref)
+				}
+				collectRefs(value)
+			}
+		case []any:
+			for _, value := range v {
+				collectRefs(value)
+			}
+		}
+	}
+	status := regexp.MustCompile(`^[1-5]\d\d$`)
+	operationIDs := map[string]bool{}
+	for path, methods := range spec.Paths {
+		for method, raw := range methods {
+			var op struct {
+				OperationID string `json:"operationId"`
+				Parameters  []struct {
+					Name     string          `json:"name"`
+					In       string          `json:"in"`
+					Required bool            `json:"required"`
+					Schema   json.RawMessage `json:"schema"`
+				} `json:"parameters"`
+				Responses map[string]struct {
+					Description *string `json:"description"`
+				} `json:"responses"`
+			}
+			if err := json.Unmarshal(raw, &op); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+			if method != "get" && method != "post" {
+				t.Errorf("%s %s: unexpected method", method, path)
+			}
+			if op.OperationID == "" || operationIDs[op.OperationID] {
+				t.Errorf("%s %s: missing or duplicate operationId %q", method, path, op.OperationID)
+			}
+			operationIDs[op.OperationID] = true
+			if len(op.Responses) == 0 {
+				t.Errorf("%s %s: no responses", method, path)
+			}
+			for code, response := range op.Responses {
+				if !status.MatchString(code) || response.Description == nil {
+					t.Errorf("%s %s: invalid response %q", method, path, code)
+				}
+			}
+			pathParams := map[string]bool{}
+			for _, param := range op.Parameters {
+				if param.Name == "" || param.Schema == nil || !strings.Contains("query path header", param.In) {
+					t.Errorf("%s %s: invalid parameter %+v", method, path, param)
+				}
+				if param.In == "path" && param.Required {
+					pathParams[param.Name] = true
+				}
+			}
+			for _, m := range regexp.MustCompile(`\{(\w+)\}`).FindAllStringSubmatch(path, -1) {
+				if !pathParams[m[1]] {
+					t.Errorf("%s %s: path parameter %q is not described", method, path, m[1])
+				}
+			}
+			var v any
+			json.Unmarshal(raw, &v)
+			collectRefs(v)
+		}
+	}
+	for _, schema := range spec.Components.Schemas {
+		collectRefs(schema)
+	}
+	for _, ref := range refs {
+		name, ok := strings.CutPrefix(ref, "#/components/schemas/")
+		if !ok || spec.Components.Schemas[name] == nil {
+			t.Errorf("unresolved reference %q", ref)
+		}
+	}
+	message := spec.Components.Schemas["Message"]["properties"].(map[string]any)
+	if message["timestamp"].(map[string]any)["type"] != "string" || message["context"].(map[string]any)["$ref"] != "#/components/schemas/AnomalyContext" {
+		t.Errorf("unexpected Message schema %v", message)
+	}
+
+	// The document describes the routes and methods the handlers serve.
+	routes := apiHandlers(handler, tmpl)
+	for pattern := range routes {
+		if _, ok := spec.Paths[pattern]; !ok {
+			t.Errorf("route %s is not described", pattern)
+		}
+	}
+	for path, methods := range spec.Paths {
+		if _, ok := routes[path]; !ok {
+			t.Errorf("described path %s is not a route", path)
+			continue
+		}
+		target := strings.ReplaceAll(path, "{id}", "1")
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			req := httptest.NewRequest(method, target, nil)
+			if _, described := methods[strings.ToLower(method)]; described {
+				if _, pattern := mux.Handler(req); pattern != path {
+					t.Errorf("%s %s is routed to %q", method, target, pattern)
+				}
+				continue
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s is not described but answered %d", method, path, rec.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package syslog_server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// Output is a sink that every accepted message is written to, such as the
+// log files, the database, Elasticsearch or Kafka. Write is called with the
+// handler's lock held, in the order messages were accepted, so it must not
+// block; outputs that talk to a remote service should queue the message
+// and send it in the background. Write gets malformed messages too, with
+// only the raw text and ingest metadata, and may skip them.
+//
+// Forwarding is not an output: whether a message was forwarded is decided
+// first and recorded in it, so the other outputs see the Forwarded flag.
+type Output interface {
+	Write(stored storedMessage) error
+	Close() error
+}
+
+// addOutput registers out to receive every message accepted from now on.
+// Outputs are added during setup, before messages are received.
+func (lh *logFileHandler) addOutput(out Output) {
+	lh.outputs = append(lh.outputs, out)
+}
+
+// writeOutputs writes stored to every output. A failing output is logged
+// and does not keep the message from the others. The caller must hold
+// lh.mu.
+func (lh *logFileHandler) writeOutputs(stored storedMessage) {
+	for _, out := range lh.outputs {
+		if err := out.Write(stored); err != nil {
+			slog.Error("Error writing message to output", "output", fmt.Sprintf("%T", out), "error", err)
+		}
+	}
+}
+
+// closeOutputs flushes and closes the outputs in the reverse order they
+// were added. Messages accepted afterwards are no longer written to them.
+func (lh *logFileHandler) closeOutputs() error {
+	lh.mu.Lock()
+	outputs := lh.outputs
+	lh.outputs = nil
+	lh.mu.Unlock()
+	var errs []error
+	for i := len(outputs) - 1; i >= 0; i-- {
+		if err := outputs[i].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing %T output: %w", outputs[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fileOutput writes messages to the main log file, or to the facility's log
+// file, and to the severity routes, all formatted by -logformat.
+type fileOutput struct {
+	lh *logFileHandler
+}
+
+func (f fileOutput) Write(stored storedMessage) error {
+	lh := f.lh
+	facility, severity, err := parsePriority(stored.Raw)
+	var errs []error
+	if logger := lh.logFileFor(facility, err); logger != nil {
+		logEntry := lh.logEntry(stored)
+		if _, err := logger.Write([]byte(logEntry)); err != nil {
+			errs = append(errs, fmt.Errorf("log file %s: %w", logger.Filename, err))
+		}
+	}
+	if len(lh.routes) > 0 {
+		if err != nil {
+			severity = 5
+		}
+		logEntry := lh.logEntry(stored)
+		for _, route := range lh.routes {
+			if severity > route.severity {
+				continue
+			}
+			if _, err := route.logger.Write([]byte(logEntry)); err != nil {
+				errs = append(errs, fmt.Errorf("log file %s: %w", route.logger.Filename, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the log files.
+func (f fileOutput) Close() error {
+	f.lh.reopenLogFiles()
+	return nil
+}
@@ -0,0 +1,75 @@
+package syslog_server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memoryOutput is a custom output that keeps the messages written to it.
+type memoryOutput struct {
+	messages []syslogMsg
+	fail     error
+	closed   bool
+}
+
+func (m *memoryOutput) Write(stored storedMessage) error {
+	if stored.Malformed {
+		return nil
+	}
+	m.messages = append(m.messages, stored.Msg)
+	return m.fail
+}
+
+func (m *memoryOutput) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestCustomOutput(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "syslog.log")
+	handler, err := createLogFileHandler(logFile, 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := &memoryOutput{fail: errors.New("sink unavailable")}
+	sink := &memoryOutput{}
+	handler.addOutput(failing)
+	handler.addOutput(sink)
+
+	handler.logMessage("<11>Jan 1 00:00:00 web-01 nginx: upstream timed out", "10.0.0.1:514")
+	handler.logMessage("not a syslog message", "10.0.0.2:514")
+	handler.logMessage("<14>Jan 1 00:00:01 db-01 postgres: checkpoint complete", "10.0.0.3:514")
+	// Dropped by the severity filter before reaching any output.
+	handler.getConfig().Severity = 3
+	handler.logMessage("<13>Jan 1 00:00:02 db-01 postgres: too chatty", "10.0.0.3:514")
+
+	if len(sink.messages) != 2 {
+		t.Fatalf("expected the 2 parsed messages despite the failing output, got %+v", sink.messages)
+	}
+	if got := sink.messages[1]; got.ID != 3 || got.Appname != "postgres" || got.Source != "10.0.0.3" {
+		t.Errorf("unexpected message %+v", got)
+	}
+
+	if err := handler.closeOutputs(); err != nil {
+		t.Fatal(err)
+	}
+	if !sink.closed || !failing.closed {
+		t.Error("expected closeOutputs to close every output")
+	}
+	handler.logMessage("<11>Jan 1 00:00:03 web-01 nginx: after close", "10.0.0.1:514")
+	if len(sink.messages) != 2 {
+		t.Errorf("expected no writes after the outputs were closed, got %d messages", len(sink.messages))
+	}
+
+	// The log file is an output too and got every accepted message.
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("expected 3 lines in the log file, got %d:\n%s", lines, data)
+	}
+}
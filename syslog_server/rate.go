@@ -0,0 +1,49 @@
+package syslog_server
+
+import (
+	"sync"
+	"time"
+)
+
+// throughputWindow is the interval the message rate in the UI is averaged
+// over. It must not exceed the seconds kept by rateCounter.
+const throughputWindow = 10 * time.Second
+
+// rateCounter counts events in one-second buckets over the last minute, so
+// the rate over a recent interval can be read without keeping timestamps.
+type rateCounter struct {
+	mu      sync.Mutex
+	seconds [60]int64
+	counts  [60]uint64
+}
+
+// add counts an event at now.
+func (rc *rateCounter) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % int64(len(rc.seconds))
+	rc.mu.Lock()
+	if rc.seconds[i] != sec {
+		rc.seconds[i], rc.counts[i] = sec, 0
+	}
+	rc.counts[i]++
+	rc.mu.Unlock()
+}
+
+// rate returns the events per second over the whole seconds in window
+// before now. The current second is left out as it is still being counted.
+func (rc *rateCounter) rate(now time.Time, window time.Duration) float64 {
+	n := int64(window / time.Second)
+	if n <= 0 {
+		return 0
+	}
+	end := now.Unix()
+	var total uint64
+	rc.mu.Lock()
+	for i := range rc.seconds {
+		if sec := rc.seconds[i]; sec >= end-n && sec < end {
+			total += rc.counts[i]
+		}
+	}
+	rc.mu.Unlock()
+	return float64(total) / float64(n)
+}
@@ -0,0 +1,55 @@
+package syslog_server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateCounter(t *testing.T) {
+	var rc rateCounter
+	start := time.Unix(1700000000, 0)
+	for i := range 30 {
+		rc.add(start.Add(time.Duration(i%3) * time.Second))
+	}
+	// 30 events over 3 seconds, the current second not counted.
+	if got := rc.rate(start.Add(3*time.Second), 10*time.Second); got != 3 {
+		t.Errorf("expected 3 events/s, got %v", got)
+	}
+	if got := rc.rate(start.Add(2*time.Second), 10*time.Second); got != 2 {
+		t.Errorf("expected the current second to be left out, got %v", got)
+	}
+	if got := rc.rate(start.Add(20*time.Second), 10*time.Second); got != 0 {
+		t.Errorf("expected old events to be outside the window, got %v", got)
+	}
+	// A bucket reused a minute later starts over.
+	rc.add(start.Add(60 * time.Second))
+	if got := rc.rate(start.Add(61*time.Second), 10*time.Second); got != 0.1 {
+		t.Errorf("expected 0.1 events/s, got %v", got)
+	}
+}
+
+func TestLogsPageShowsThroughput(t *testing.T) {
+	handler, err := createLogFileHandler("", 100, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	handler.now = func() time.Time { return now }
+	for i := range 50 {
+		handler.logMessage(fmt.Sprintf("<14>Mar 1 12:00:00 web-01 nginx: request %d", i), "10.0.0.1:514")
+	}
+	now = now.Add(time.Second)
+	handler.getConfig().AppName = "nginx"
+
+	rec := httptest.NewRecorder()
+	renderPage(rec, "logs", testTemplates(t), handler)
+	body := rec.Body.String()
+	for _, want := range []string{"50 messages buffered", "5.0 msg/s over the last 10s", `filters: app &#34;nginx&#34;`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q on the logs page:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package syslog_server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RedactRule replaces the text matching Pattern in incoming messages, so
+// secrets never reach memory, the log files or the forwarding servers.
+// Replacement may refer to submatches as $1 or ${name}.
+type RedactRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+	re          *regexp.Regexp
+}
+
+// redactPresets are the built-in rules enabled by name with -redactpreset.
+var redactPresets = map[string]RedactRule{
+	"creditcard": newRedactRule(`\b(?:\d[ -]?){12,18}\d\b`, "[REDACTED-CARD]"),
+	"email":      newRedactRule(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, "[REDACTED-EMAIL]"),
+	"password":   newRedactRule(`(?i)\b(password|passwd|pwd|secret|token|api[_-]?key)(\s*[=:]\s*)\S+`, "${1}${2}[REDACTED]"),
+}
+
+func newRedactRule(pattern, replacement string) RedactRule {
+	return RedactRule{Pattern: pattern, Replacement: replacement, re: regexp.MustCompile(pattern)}
+}
+
+// redactRuleFlag collects repeated -redact pattern=replacement flags.
+type redactRuleFlag []RedactRule
+
+func (f *redactRuleFlag) String() string {
+	var rules []string
+	for _, rule := range *f {
+		rules = append(rules, rule.Pattern+"="+rule.Replacement)
+	}
+	return strings.Join(rules, ",")
+}
+
+func (f *redactRuleFlag) Set(value string) error {
+	rule, err := parseRedactRule(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, rule)
+	return nil
+}
+
+// parseRedactRule parses "pattern=replacement" where pattern is a regexp.
+// The pattern may itself contain '=' but the replacement may not.
+func parseRedactRule(value string) (RedactRule, error) {
+	ix := strings.LastIndex(value, "=")
+	if ix <= 0 {
+		return RedactRule{}, fmt.Errorf("redaction rule must be pattern=replacement, got %q", value)
+	}
+	re, err := regexp.Compile(value[:ix])
+	if err != nil {
+		return RedactRule{}, fmt.Errorf("invalid redaction rule pattern: %w", err)
+	}
+	return RedactRule{Pattern: value[:ix], Replacement: value[ix+1:], re: re}, nil
+}
+
+// parseRedactPresets returns the rules of a comma separated list of preset
+// names, e.g. "creditcard,email".
+func parseRedactPresets(names string) ([]RedactRule, error) {
+	var rules []RedactRule
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		preset, ok := redactPresets[name]
+		if !ok {
+			var known []string
+			for preset := range redactPresets {
+				known = append(known, preset)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown redaction preset %q, expected one of %s", name, strings.Join(known, ", "))
+		}
+		rules = append(rules, preset)
+	}
+	return rules, nil
+}
+
+// redact applies every rule to message in order. The whole line is
+// rewritten, header included, as that is what is stored and forwarded.
+func redact(rules []RedactRule, message string) string {
+	for _, rule := range rules {
+		message = rule.re.ReplaceAllString(message, rule.Replacement)
+	}
+	return message
+}
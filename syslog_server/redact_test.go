@@ -0,0 +1,84 @@
+package syslog_server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	rules, err := parseRedactPresets("creditcard, Email,password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var custom redactRuleFlag
+	if err := custom.Set(`sk_live_\w+=[KEY]`); err != nil {
+		t.Fatal(err)
+	}
+	rules = append(rules, custom...)
+	tests := []struct {
+		message, want string
+	}{
+		{"<14>Jan 1 00:00:00 host pay: charged 4111 1111 1111 1111 ok", "<14>Jan 1 00:00:00 host pay: charged [REDACTED-CARD] ok"},
+		{"<14>Jan 1 00:00:00 host pay: charged 4111-1111-1111-1111", "<14>Jan 1 00:00:00 host pay: charged [REDACTED-CARD]"},
+		{"<14>Jan 1 00:00:00 host mail: sent to jane.doe@example.com", "<14>Jan 1 00:00:00 host mail: sent to [REDACTED-EMAIL]"},
+		{"<14>Jan 1 00:00:00 host app: login Password=hunter2 user=bob", "<14>Jan 1 00:00:00 host app: login Password=[REDACTED] user=bob"},
+		{"<14>Jan 1 00:00:00 host app: api_key: abc123", "<14>Jan 1 00:00:00 host app: api_key: [REDACTED]"},
+		{"<14>Jan 1 00:00:00 host app: using sk_live_4eC39HqLyjWD", "<14>Jan 1 00:00:00 host app: using [KEY]"},
+		// Timestamps and short numbers are left alone.
+		{"<14>1 2024-01-02T03:04:05Z host app 1234 - - order 1234567 shipped", "<14>1 2024-01-02T03:04:05Z host app 1234 - - order 1234567 shipped"},
+	}
+	for _, tt := range tests {
+		if got := redact(rules, tt.message); got != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+
+	if _, err := parseRedactPresets("ssn"); err == nil {
+		t.Error("expected an error for an unknown preset")
+	}
+	for _, bad := range []string{"secret", "=x", "(=x"} {
+		if _, err := parseRedactRule(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestRedactBeforeAllOutputs(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	logFile := filepath.Join(t.TempDir(), "syslog.log")
+	handler, err := createLogFileHandler(logFile, 10, upstream.LocalAddr().String(), "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.config.RedactRules, err = parseRedactPresets("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<11>Jan 1 00:00:00 web-01 api: db connect failed password=s3cr3t", "127.0.0.1:5140")
+	handler.forwarder.close()
+	handler.logger.Close()
+
+	want := "<11>Jan 1 00:00:00 web-01 api: db connect failed password=[REDACTED]"
+	if got := readDatagrams(upstream); strings.Join(got, "|") != want {
+		t.Errorf("forwarded %q, want %q", got, want)
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cr3t") || !strings.Contains(string(data), "password=[REDACTED]") {
+		t.Errorf("expected the secret to be redacted in the log file, got %q", data)
+	}
+	stored := handler.messages[0]
+	if stored.Raw != want || stored.Msg.Message != "db connect failed password=[REDACTED]" {
+		t.Errorf("expected the secret to be redacted in memory, got %+v", stored)
+	}
+}
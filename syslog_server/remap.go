@@ -0,0 +1,85 @@
+package syslog_server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"syslog/syslog_client"
+)
+
+// SeverityRule overrides the severity of messages whose body matches
+// Pattern, for apps that log at the wrong level.
+type SeverityRule struct {
+	Pattern  string `json:"pattern"`
+	Severity int    `json:"severity"`
+	re       *regexp.Regexp
+}
+
+// severityRuleFlag collects repeated -remap pattern=severity flags.
+type severityRuleFlag []SeverityRule
+
+func (f *severityRuleFlag) String() string {
+	var rules []string
+	for _, rule := range *f {
+		rules = append(rules, rule.Pattern+"="+strconv.Itoa(rule.Severity))
+	}
+	return strings.Join(rules, ",")
+}
+
+func (f *severityRuleFlag) Set(value string) error {
+	rule, err := parseSeverityRule(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, rule)
+	return nil
+}
+
+// parseSeverityRule parses "pattern=severity" where pattern is a regexp and
+// severity a number from 0 to 7 or a keyword such as "crit". The pattern may
+// itself contain '='.
+func parseSeverityRule(value string) (SeverityRule, error) {
+	ix := strings.LastIndex(value, "=")
+	if ix <= 0 {
+		return SeverityRule{}, fmt.Errorf("severity rule must be pattern=severity, got %q", value)
+	}
+	severity, err := syslog_client.ParseSeverity(value[ix+1:])
+	if err != nil {
+		return SeverityRule{}, fmt.Errorf("invalid severity rule: %w", err)
+	}
+	re, err := regexp.Compile(value[:ix])
+	if err != nil {
+		return SeverityRule{}, fmt.Errorf("invalid severity rule pattern: %w", err)
+	}
+	return SeverityRule{Pattern: value[:ix], Severity: severity, re: re}, nil
+}
+
+// remapSeverity rewrites the priority of message to the severity of the
+// first rule matching its body, keeping the facility. Messages matching no
+// rule are returned unchanged.
+func remapSeverity(rules []SeverityRule, message string) string {
+	if len(rules) == 0 {
+		return message
+	}
+	body := skipNumericPrefix(message)
+	if msg, err := parseSyslogMessage(message); err == nil {
+		body = msg.Message
+	}
+	for _, rule := range rules {
+		if !rule.re.MatchString(body) {
+			continue
+		}
+		facility, severity, err := parsePriority(message)
+		if err != nil {
+			// Messages without a priority are user.notice (RFC 3164 4.3.3).
+			return fmt.Sprintf("<%d>%s", 1*8+rule.Severity, message)
+		}
+		if severity == rule.Severity {
+			return message
+		}
+		return fmt.Sprintf("<%d>%s", facility*8+rule.Severity, message[strings.Index(message, ">")+1:])
+	}
+	return message
+}
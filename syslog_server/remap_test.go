@@ -0,0 +1,73 @@
+package syslog_server
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRemapSeverity(t *testing.T) {
+	var rules severityRuleFlag
+	for _, value := range []string{"panic=crit", "(?i)error=err", "timeout=warning", "key=value=debug"} {
+		if err := rules.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		message, want string
+	}{
+		{"<14>Jan 1 00:00:00 host app: goroutine panic: nil map", "<10>Jan 1 00:00:00 host app: goroutine panic: nil map"},
+		// The first matching rule wins.
+		{"<14>Jan 1 00:00:00 host app: ERROR caused a panic", "<10>Jan 1 00:00:00 host app: ERROR caused a panic"},
+		{"<30>Jan 1 00:00:00 host app: Error: disk full", "<27>Jan 1 00:00:00 host app: Error: disk full"},
+		{"<14>Jan 1 00:00:00 host app: set key=value", "<15>Jan 1 00:00:00 host app: set key=value"},
+		{"<14>Jan 1 00:00:00 host app: all good", "<14>Jan 1 00:00:00 host app: all good"},
+		// Only the message body is matched, not the host or app.
+		{"<14>Jan 1 00:00:00 panic-01 app: all good", "<14>Jan 1 00:00:00 panic-01 app: all good"},
+		{"Jan 1 00:00:00 host app: request timeout", "<12>Jan 1 00:00:00 host app: request timeout"},
+	}
+	for _, tt := range tests {
+		if got := remapSeverity(rules, tt.message); got != tt.want {
+			t.Errorf("remapSeverity(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"panic", "=crit", "panic=loud", "(=crit"} {
+		if _, err := parseSeverityRule(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestRemapSeverityAffectsForwarding(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	// Forward err and above only.
+	handler, err := createLogFileHandler("", 10, upstream.LocalAddr().String(), "udp", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := parseSeverityRule("panic=crit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.config.SeverityRules = []SeverityRule{rule}
+	handler.logMessage("<14>Jan 1 00:00:00 web-01 api: panic: runtime error", "127.0.0.1:5140")
+	handler.logMessage("<14>Jan 1 00:00:01 web-01 api: request served", "127.0.0.1:5140")
+	handler.forwarder.close()
+
+	got := readDatagrams(upstream)
+	if want := "<10>Jan 1 00:00:00 web-01 api: panic: runtime error"; strings.Join(got, "|") != want {
+		t.Errorf("expected only the remapped message to be forwarded as crit, got %q", got)
+	}
+	if handler.severityCounts[2].Load() != 1 || handler.severityCounts[6].Load() != 1 {
+		t.Error("expected the counters to use the remapped severity")
+	}
+	if stored := handler.messages[0]; stored.Malformed || stored.Msg.Severity != 2 {
+		t.Errorf("expected the stored message to have severity 2, got %+v", stored)
+	}
+}
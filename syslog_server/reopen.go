@@ -0,0 +1,72 @@
+package syslog_server
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reopenLogFiles closes the log files so the next write creates them again
+// at their configured paths. This lets external tools such as logrotate
+// rename the files away.
+func (lh *logFileHandler) reopenLogFiles() {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	if lh.logger != nil {
+		if err := lh.logger.Close(); err != nil {
+			slog.Error("Error closing log file", "file", lh.logger.Filename, "error", err)
+		}
+	}
+	for _, route := range lh.routes {
+		if err := route.logger.Close(); err != nil {
+			slog.Error("Error closing log file", "file", route.logger.Filename, "error", err)
+		}
+	}
+	for _, logger := range lh.facilityLogs {
+		if err := logger.Close(); err != nil {
+			slog.Error("Error closing log file", "file", logger.Filename, "error", err)
+		}
+	}
+}
+
+// truncateLogFile empties the main log file, for a fresh file each run
+// instead of appending to the last one. It is called at startup, before the
+// first message is written.
+func (lh *logFileHandler) truncateLogFile() error {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	if lh.logger == nil {
+		return nil
+	}
+	// Close it in case it was already opened for appending.
+	lh.logger.Close()
+	if err := os.Truncate(lh.logger.Filename, 0); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("truncating log file: %w", err)
+	}
+	return nil
+}
+
+// reopenOnSIGHUP reopens the log files whenever SIGHUP is received until
+// the returned function is called.
+func reopenOnSIGHUP(handler *logFileHandler) (stop func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hup:
+				slog.Info("Received SIGHUP, reopening log files")
+				handler.reopenLogFiles()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(hup)
+		close(done)
+	}
+}
@@ -0,0 +1,93 @@
+package syslog_server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReopenOnSIGHUP(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "syslog.log")
+	handler, err := createLogFileHandler(logFile, 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := reopenOnSIGHUP(handler)
+	defer stop()
+
+	handler.logMessage("<13>Jan 1 00:00:00 host app: before rotation", "127.0.0.1:514")
+	rotated := logFile + ".1"
+	if err := os.Rename(logFile, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep logging until a write lands in the recreated file.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		handler.logMessage("<13>Jan 1 00:00:01 host app: after rotation", "127.0.0.1:514")
+		if _, err := os.Stat(logFile); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("log file was not reopened after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	handler.logger.Close()
+
+	old, err := os.ReadFile(rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(old), "Jan 1 00:00:00 host app: before rotation\n") {
+		t.Errorf("unexpected rotated file contents %q", old)
+	}
+	current, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(current) != "Jan 1 00:00:01 host app: after rotation\n" {
+		t.Errorf("expected only the post-rotation write in the new file, got %q", current)
+	}
+}
+
+func TestTruncateLogFile(t *testing.T) {
+	for _, truncate := range []bool{false, true} {
+		logFile := filepath.Join(t.TempDir(), "syslog.log")
+		if err := os.WriteFile(logFile, []byte("Jan 1 00:00:00 host app: last run\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		handler, err := createLogFileHandler(logFile, 10, "", "udp", 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if truncate {
+			if err := handler.truncateLogFile(); err != nil {
+				t.Fatal(err)
+			}
+			if info, err := os.Stat(logFile); err != nil || info.Size() != 0 {
+				t.Fatalf("expected an empty log file at startup, got %v, %v", info, err)
+			}
+		}
+		handler.logMessage("<13>Jan 1 00:00:01 host app: this run", "127.0.0.1:514")
+		handler.logger.Close()
+
+		data, err := os.ReadFile(logFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "Jan 1 00:00:00 host app: last run\nJan 1 00:00:01 host app: this run\n"
+		if truncate {
+			want = "Jan 1 00:00:01 host app: this run\n"
+		}
+		if string(data) != want {
+			t.Errorf("truncate %v: got %q, want %q", truncate, data, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package syslog_server
+
+import (
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// retentionSweeper drops buffered messages older than the MaxAge config
+// setting, so a quiet server does not show stale messages until MaxMessages
+// newer ones push them out. Messages may outlive MaxAge by up to one
+// sweep interval.
+type retentionSweeper struct {
+	handler *logFileHandler
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// retentionInterval returns how often to sweep for a maximum age: a tenth
+// of it, between one second and one minute.
+func retentionInterval(maxAge time.Duration) time.Duration {
+	return min(max(maxAge/10, time.Second), time.Minute)
+}
+
+func startRetentionSweeper(handler *logFileHandler, interval time.Duration) *retentionSweeper {
+	s := &retentionSweeper{handler: handler, done: make(chan struct{})}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if n := s.sweep(); n > 0 {
+					slog.Info("Dropped old messages from memory", "count", n, "max_age", s.handler.getConfig().MaxAge)
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// sweep drops the messages received more than MaxAge ago and returns how
+// many were dropped.
+func (s *retentionSweeper) sweep() int {
+	maxAge := s.handler.getConfig().MaxAge
+	if maxAge <= 0 {
+		return 0
+	}
+	s.handler.mu.Lock()
+	defer s.handler.mu.Unlock()
+	return s.handler.dropBefore(s.handler.now().Add(-maxAge))
+}
+
+// dropBefore drops the buffered messages received before cutoff. Messages
+// are buffered in the order they were received. The caller must hold lh.mu.
+func (lh *logFileHandler) dropBefore(cutoff time.Time) int {
+	i := sort.Search(len(lh.messages), func(i int) bool {
+		return !lh.messages[i].Received.Before(cutoff)
+	})
+	if i > 0 {
+		lh.dropOldest(i)
+		lh.messages = append([]storedMessage(nil), lh.messages...)
+	}
+	return i
+}
+
+// debugSeverity is the least severe syslog severity.
+const debugSeverity = 7
+
+// evictionRank returns the severity a buffered message is evicted by.
+// Unparsed messages rank with debug messages.
+func evictionRank(stored storedMessage) int {
+	if stored.Malformed || stored.Msg.Severity < 0 || stored.Msg.Severity > debugSeverity {
+		return debugSeverity
+	}
+	return stored.Msg.Severity
+}
+
+// unbuffer updates lh.bufferedByRank for messages about to be removed from
+// the buffer. The caller must hold lh.mu.
+func (lh *logFileHandler) unbuffer(messages []storedMessage) {
+	for i := range messages {
+		lh.bufferedByRank[evictionRank(messages[i])]--
+	}
+}
+
+// dropLeastSevere removes n buffered messages, each time the oldest of the
+// least severe ones, so that under a flood of low severity messages the
+// important ones are kept. The counts in lh.bufferedByRank give the rank to
+// evict, so the search stops at the first message of that rank. Under a
+// flood that message is near the front of the buffer, and only the messages
+// before it are moved, so an eviction costs about as much as the distance
+// to it. The caller must hold lh.mu.
+func (lh *logFileHandler) dropLeastSevere(n int) {
+	for ; n > 0 && len(lh.messages) > 0; n-- {
+		rank := debugSeverity
+		for rank > 0 && lh.bufferedByRank[rank] <= 0 {
+			rank--
+		}
+		victim := slices.IndexFunc(lh.messages, func(stored storedMessage) bool {
+			return evictionRank(stored) == rank
+		})
+		if victim < 0 {
+			// The counts are out of step with the buffer.
+			victim = 0
+		}
+		lh.evictedID = max(lh.evictedID, lh.messages[victim].Msg.ID)
+		lh.unbuffer(lh.messages[victim : victim+1])
+		if victim < len(lh.messages)/2 {
+			// Shift the older messages up over the victim instead of the
+			// newer ones down.
+			copy(lh.messages[1:victim+1], lh.messages[:victim])
+			lh.messages[0] = storedMessage{}
+			lh.messages = lh.messages[1:]
+		} else {
+			lh.messages = slices.Delete(lh.messages, victim, victim+1)
+		}
+	}
+}
+
+// stop stops the sweeper.
+func (s *retentionSweeper) stop() {
+	close(s.done)
+	s.wg.Wait()
+}
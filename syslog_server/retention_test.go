@@ -0,0 +1,169 @@
+package syslog_server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetentionSweeperDropsOldMessages(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler.now = func() time.Time { return clock }
+	handler.getConfig().MaxAge = time.Hour
+	for i := 0; i < 3; i++ {
+		handler.logMessage(fmt.Sprintf("<13>Jan 1 00:%02d:00 host app: message %d", i*20, i), "127.0.0.1:514")
+		clock = clock.Add(20 * time.Minute)
+	}
+	// Messages were received at 00:00, 00:20 and 00:40.
+	sweeper := &retentionSweeper{handler: handler}
+
+	clock = time.Date(2024, 1, 1, 0, 59, 0, 0, time.UTC)
+	if n := sweeper.sweep(); n != 0 || len(handler.messages) != 3 {
+		t.Errorf("expected nothing to be dropped within the hour, dropped %d", n)
+	}
+	clock = clock.Add(10 * time.Minute)
+	if n := sweeper.sweep(); n != 1 || len(handler.messages) != 2 || handler.messages[0].Msg.Message != "message 1" {
+		t.Errorf("expected the first message to be dropped, dropped %d, %d left", n, len(handler.messages))
+	}
+	clock = clock.Add(time.Hour)
+	if n := sweeper.sweep(); n != 2 || len(handler.messages) != 0 {
+		t.Errorf("expected all messages to be dropped, dropped %d, %d left", n, len(handler.messages))
+	}
+
+	// MaxAge of zero keeps messages however old they are.
+	handler.logMessage("<13>Jan 1 02:00:00 host app: kept", "127.0.0.1:514")
+	handler.getConfig().MaxAge = 0
+	clock = clock.Add(24 * time.Hour)
+	if n := sweeper.sweep(); n != 0 || len(handler.messages) != 1 {
+		t.Errorf("expected no eviction without MaxAge, dropped %d", n)
+	}
+}
+
+func TestRetentionSweeperRunsPeriodically(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.getConfig().MaxAge = time.Millisecond
+	handler.logMessage("<13>Jan 1 00:00:00 host app: stale", "127.0.0.1:514")
+	sweeper := startRetentionSweeper(handler, 10*time.Millisecond)
+	defer sweeper.stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		handler.mu.Lock()
+		n := len(handler.messages)
+		handler.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the sweeper to drop the stale message")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := retentionInterval(time.Hour); got != time.Minute {
+		t.Errorf("retentionInterval(1h) = %v, want 1m", got)
+	}
+	if got := retentionInterval(time.Second); got != time.Second {
+		t.Errorf("retentionInterval(1s) = %v, want 1s", got)
+	}
+}
+
+func TestDropLeastSevere(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.config.Severity = 8
+	handler.config.MaxMessages = 20
+	handler.evictBySeverity = true
+
+	// A flood of debug and info messages with a few errors and an emergency.
+	important := map[string]bool{}
+	for i := 0; i < 500; i++ {
+		priority := 15 // user.debug
+		switch {
+		case i%100 == 50:
+			priority = 8 // user.emerg
+		case i%50 == 10:
+			priority = 11 // user.err
+		case i%3 == 0:
+			priority = 14 // user.info
+		}
+		body := fmt.Sprintf("message %d", i)
+		if priority < 14 {
+			important[body] = true
+		}
+		handler.logMessage(fmt.Sprintf("<%d>Jan 1 00:00:00 host app: %s", priority, body), "127.0.0.1:514")
+	}
+
+	if len(handler.messages) != 20 {
+		t.Fatalf("expected the buffer to stay at 20 messages, got %d", len(handler.messages))
+	}
+	var byRank [8]int
+	for _, stored := range handler.messages {
+		byRank[evictionRank(stored)]++
+	}
+	if byRank != handler.bufferedByRank {
+		t.Errorf("expected the rank counts %v to match the buffer, got %v", byRank, handler.bufferedByRank)
+	}
+	kept := map[string]bool{}
+	var lastID uint64
+	for _, stored := range handler.messages {
+		kept[stored.Msg.Message] = true
+		if stored.Msg.ID <= lastID {
+			t.Errorf("expected the buffer to stay in ID order, got %d after %d", stored.Msg.ID, lastID)
+		}
+		lastID = stored.Msg.ID
+		if stored.Msg.Severity == debugSeverity {
+			t.Errorf("expected debug messages to be dropped first, kept %q", stored.Msg.Message)
+		}
+	}
+	for body := range important {
+		if !kept[body] {
+			t.Errorf("expected %q to survive the flood", body)
+		}
+	}
+	if handler.messages[len(handler.messages)-1].Msg.Message != "message 498" {
+		t.Errorf("expected the newest info message to be kept, got %q", handler.messages[len(handler.messages)-1].Msg.Message)
+	}
+
+	// With the default policy the oldest messages go first.
+	handler.evictBySeverity = false
+	handler.logMessage("<15>Jan 1 00:00:00 host app: last", "127.0.0.1:514")
+	if kept := handler.messages[0].Msg.Message; kept == "message 10" {
+		t.Errorf("expected the oldest message to be dropped, still have %q", kept)
+	}
+}
+
+func BenchmarkEvictLeastSevere(b *testing.B) {
+	for _, bySeverity := range []bool{false, true} {
+		b.Run(fmt.Sprintf("bySeverity=%t", bySeverity), func(b *testing.B) {
+			handler, err := createLogFileHandler("", 10, "", "udp", 6)
+			if err != nil {
+				b.Fatal(err)
+			}
+			handler.config.MaxMessages = 10000
+			handler.evictBySeverity = bySeverity
+			// A full buffer of info messages with an error in every hundred.
+			message := func(i int) string {
+				if i%100 == 0 {
+					return fmt.Sprintf("<11>Jan 1 00:00:00 web-01 app: failed %d", i)
+				}
+				return fmt.Sprintf("<14>Jan 1 00:00:00 web-01 app: request %d", i)
+			}
+			for i := 0; i < handler.config.MaxMessages; i++ {
+				handler.logMessage(message(i), "127.0.0.1:514")
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				handler.logMessage(message(i), "127.0.0.1:514")
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package syslog_server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// utf8BOM marks an RFC 5424 message body as UTF-8 (RFC 5424 6.4).
+const utf8BOM = "\ufeff"
+
+// parseRFC5424 parses the part of an RFC 5424 message after the priority:
+// VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG].
+// PROCID is kept as the Pid; MSGID and the structured data are not kept.
+func parseRFC5424(msg string, facility, severity int) (*syslogMsg, error) {
+	parts := strings.SplitN(msg, " ", 7)
+	if len(parts) < 7 || parts[0] != "1" {
+		return nil, fmt.Errorf("not an RFC 5424 message")
+	}
+	end, err := structuredDataEnd(parts[6])
+	if err != nil {
+		return nil, err
+	}
+	pid := parts[4]
+	if pid == "-" {
+		pid = ""
+	}
+	return &syslogMsg{
+		Timestamp: cleanString(parts[1]),
+		Hostname:  cleanString(parts[2]),
+		Appname:   cleanString(parts[3]),
+		Pid:       cleanString(pid),
+		Message:   messageBody(strings.TrimPrefix(parts[6][end:], " ")),
+		Facility:  facility,
+		Severity:  severity,
+	}, nil
+}
+
+// structuredDataEnd returns the length of the STRUCTURED-DATA field at the
+// start of s: "-" or one or more [id param="value"] elements, where values
+// may contain escaped quotes and brackets.
+func structuredDataEnd(s string) (int, error) {
+	if strings.HasPrefix(s, "-") {
+		return 1, nil
+	}
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		inValue := false
+		for i++; ; i++ {
+			if i >= len(s) {
+				return 0, fmt.Errorf("unterminated structured data")
+			}
+			if inValue && s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				inValue = !inValue
+			} else if s[i] == ']' && !inValue {
+				i++
+				break
+			}
+		}
+	}
+	if i == 0 {
+		return 0, fmt.Errorf("invalid structured data")
+	}
+	return i, nil
+}
+
+// messageBody cleans a message body: a leading UTF-8 BOM is removed and
+// invalid UTF-8 is replaced so the body displays and encodes cleanly.
+func messageBody(body string) string {
+	body = strings.TrimPrefix(body, utf8BOM)
+	return cleanString(strings.ToValidUTF8(body, "\ufffd"))
+}
@@ -0,0 +1,42 @@
+package syslog_server
+
+import "testing"
+
+func TestParseRFC5424(t *testing.T) {
+	tests := []struct {
+		raw, host, app, message string
+	}{
+		{"<165>1 2024-01-01T00:00:00.000Z web-01 api 1234 ID47 - \xef\xbb\xbfcafé ready", "web-01", "api", "café ready"},
+		{"<165>1 2024-01-01T00:00:00Z web-01 api - - [exampleSDID@32473 iut=\"3\" note=\"a \\\"]\\\" b\"][x@1 y=\"z\"] \xef\xbb\xbfstarted", "web-01", "api", "started"},
+		{"<165>1 2024-01-01T00:00:00Z - - - - -", "-", "-", ""},
+		{"<165>1 2024-01-01T00:00:00Z host app - - - bad \xff byte", "host", "app", "bad � byte"},
+		// A BOM is also removed from BSD message bodies.
+		{"<13>Jan 1 00:00:00 host app: \xef\xbb\xbfhello", "host", "app", "hello"},
+	}
+	for _, tt := range tests {
+		msg, err := parseSyslogMessage(tt.raw)
+		if err != nil {
+			t.Errorf("%q: %v", tt.raw, err)
+			continue
+		}
+		if msg.Hostname != tt.host || msg.Appname != tt.app || msg.Message != tt.message {
+			t.Errorf("%q: got host %q app %q message %q, want %q %q %q", tt.raw, msg.Hostname, msg.Appname, msg.Message, tt.host, tt.app, tt.message)
+		}
+		if msg.Severity != 5 {
+			t.Errorf("%q: severity %d, want 5", tt.raw, msg.Severity)
+		}
+	}
+
+	msg, err := parseSyslogMessage("<165>1 2024-01-01T00:00:00.000Z web-01 api 1234 ID47 - \xef\xbb\xbfready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Timestamp != "2024-01-01T00:00:00.000Z" || parseTimestamp(msg.Timestamp).IsZero() {
+		t.Errorf("expected the RFC 5424 timestamp to be kept and sortable, got %q", msg.Timestamp)
+	}
+
+	// Unterminated structured data falls back to the BSD parse.
+	if _, err := structuredDataEnd(`[id a="b`); err == nil {
+		t.Error("expected an error for unterminated structured data")
+	}
+}
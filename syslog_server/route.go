@@ -0,0 +1,126 @@
+package syslog_server
+
+import (
+	"fmt"
+	"strings"
+
+	"syslog/syslog_client"
+
+	"github.com/natefinch/lumberjack"
+)
+
+// logRoute writes messages of a given severity or more severe to a separate
+// rotating log file, in addition to the main log file.
+type logRoute struct {
+	severity int
+	logger   *lumberjack.Logger
+}
+
+// routeFlag collects repeated -route severity=file flags.
+type routeFlag []string
+
+func (f *routeFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *routeFlag) Set(value string) error {
+	if _, _, err := parseRoute(value); err != nil {
+		return err
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// parseRoute parses "severity=file" where severity is a number from 0 to 7
+// or a keyword such as "err".
+func parseRoute(value string) (int, string, error) {
+	name, filename, ok := strings.Cut(value, "=")
+	if !ok || filename == "" {
+		return 0, "", fmt.Errorf("route must be severity=file, got %q", value)
+	}
+	severity, err := syslog_client.ParseSeverity(name)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid route: %w", err)
+	}
+	return severity, filename, nil
+}
+
+// addRoute sends messages at severity or above to filename.
+func (lh *logFileHandler) addRoute(severity int, filename string) {
+	lh.routes = append(lh.routes, logRoute{
+		severity: severity,
+		logger: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    lh.maxSize,
+			MaxBackups: 3,
+			MaxAge:     28,
+			Compress:   true,
+		},
+	})
+}
+
+// facilityRouteFlag collects repeated -facilitylog facility=file flags.
+type facilityRouteFlag []string
+
+func (f *facilityRouteFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *facilityRouteFlag) Set(value string) error {
+	if _, _, err := parseFacilityRoute(value); err != nil {
+		return err
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// parseFacilityRoute parses "facility=file" where facility is a number from
+// 0 to 23 or a keyword such as "auth".
+func parseFacilityRoute(value string) (int, string, error) {
+	name, filename, ok := strings.Cut(value, "=")
+	if !ok || filename == "" {
+		return 0, "", fmt.Errorf("facility log must be facility=file, got %q", value)
+	}
+	facility, err := syslog_client.ParseFacility(name)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid facility log: %w", err)
+	}
+	return facility, filename, nil
+}
+
+// addFacilityRoute writes messages of facility to filename instead of the
+// main log file. Facilities mapped to the same file share its logger.
+func (lh *logFileHandler) addFacilityRoute(facility int, filename string) {
+	if lh.facilityLogs == nil {
+		lh.facilityLogs = map[int]*lumberjack.Logger{}
+	}
+	for _, logger := range lh.facilityLogs {
+		if logger.Filename == filename {
+			lh.facilityLogs[facility] = logger
+			return
+		}
+	}
+	lh.facilityLogs[facility] = &lumberjack.Logger{
+		Filename:   filename,
+		MaxSize:    lh.maxSize,
+		MaxBackups: 3,
+		MaxAge:     28,
+		Compress:   true,
+	}
+}
+
+// logFileFor returns the log file for messages of facility, or nil when
+// they are not written to a file. Messages without a priority count as
+// facility user.
+func (lh *logFileHandler) logFileFor(facility int, err error) *lumberjack.Logger {
+	if err != nil {
+		facility = 1
+	}
+	if logger, ok := lh.facilityLogs[facility]; ok {
+		return logger
+	}
+	if lh.disableLogging {
+		return nil
+	}
+	return lh.logger
+}
@@ -0,0 +1,104 @@
+package syslog_server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeverityRouting(t *testing.T) {
+	dir := t.TempDir()
+	allLog := filepath.Join(dir, "all.log")
+	errorsLog := filepath.Join(dir, "errors.log")
+	handler, err := createLogFileHandler(allLog, 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	severity, filename, err := parseRoute("err=" + errorsLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.addRoute(severity, filename)
+
+	handler.logMessage("<11>Jan 1 00:00:00 host app: disk failure", "127.0.0.1:514")
+	handler.logMessage("<14>Jan 1 00:00:01 host app: all good", "127.0.0.1:514")
+	handler.logger.Close()
+	handler.routes[0].logger.Close()
+
+	all, err := os.ReadFile(allLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(all) != "Jan 1 00:00:00 host app: disk failure\nJan 1 00:00:01 host app: all good\n" {
+		t.Errorf("unexpected all.log contents %q", all)
+	}
+	errs, err := os.ReadFile(errorsLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(errs) != "Jan 1 00:00:00 host app: disk failure\n" {
+		t.Errorf("unexpected errors.log contents %q", errs)
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	if severity, filename, err := parseRoute("3=errors.log"); err != nil || severity != 3 || filename != "errors.log" {
+		t.Errorf("unexpected route %d %q %v", severity, filename, err)
+	}
+	for _, bad := range []string{"errors.log", "8=x.log", "bogus=x.log", "err="} {
+		if _, _, err := parseRoute(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestFacilityRouting(t *testing.T) {
+	dir := t.TempDir()
+	allLog := filepath.Join(dir, "all.log")
+	authLog := filepath.Join(dir, "auth.log")
+	handler, err := createLogFileHandler(allLog, 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range []string{"auth=" + authLog, "10=" + authLog} {
+		facility, filename, err := parseFacilityRoute(route)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.addFacilityRoute(facility, filename)
+	}
+	if handler.facilityLogs[4] != handler.facilityLogs[10] {
+		t.Error("expected facilities sharing a file to share its logger")
+	}
+
+	handler.logMessage("<38>Jan 1 00:00:00 host sshd: Accepted publickey for alice", "127.0.0.1:514") // auth.info
+	handler.logMessage("<86>Jan 1 00:00:01 host sudo: alice : COMMAND=/bin/ls", "127.0.0.1:514")      // authpriv.info
+	handler.logMessage("<14>Jan 1 00:00:02 host app: all good", "127.0.0.1:514")                      // user.info
+	handler.logMessage("<30>Jan 1 00:00:03 host systemd: Started Session 1", "127.0.0.1:514")         // daemon.info
+	handler.logger.Close()
+	handler.facilityLogs[4].Close()
+
+	all, err := os.ReadFile(allLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(all) != "Jan 1 00:00:02 host app: all good\nJan 1 00:00:03 host systemd: Started Session 1\n" {
+		t.Errorf("unexpected all.log contents %q", all)
+	}
+	auth, err := os.ReadFile(authLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(auth) != "Jan 1 00:00:00 host sshd: Accepted publickey for alice\nJan 1 00:00:01 host sudo: alice : COMMAND=/bin/ls\n" {
+		t.Errorf("unexpected auth.log contents %q", auth)
+	}
+	if len(handler.messages) != 4 {
+		t.Errorf("expected all messages to be kept in memory, got %d", len(handler.messages))
+	}
+
+	for _, bad := range []string{"auth.log", "24=x.log", "security=x.log", "auth="} {
+		if _, _, err := parseFacilityRoute(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
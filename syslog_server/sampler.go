@@ -0,0 +1,70 @@
+package syslog_server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sampler thins out floods by keeping only 1 in rate messages from a source
+// once it has sent more than threshold messages in the current second.
+type sampler struct {
+	rate       int
+	threshold  int
+	mu         sync.Mutex
+	sources    map[string]*sampleWindow
+	lastSweep  time.Time
+	sampledOut atomic.Uint64
+}
+
+type sampleWindow struct {
+	start time.Time
+	count int
+}
+
+func newSampler(rate, threshold int) *sampler {
+	return &sampler{
+		rate:      rate,
+		threshold: threshold,
+		sources:   map[string]*sampleWindow{},
+	}
+}
+
+// keep reports whether a message from source received at now should be kept.
+func (s *sampler) keep(source string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		for src, window := range s.sources {
+			if now.Sub(window.start) > time.Minute {
+				delete(s.sources, src)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	window, ok := s.sources[source]
+	if !ok || now.Sub(window.start) >= time.Second {
+		window = &sampleWindow{start: now}
+		s.sources[source] = window
+	}
+	window.count++
+	excess := window.count - s.threshold
+	if excess <= 0 || (excess-1)%s.rate == 0 {
+		return true
+	}
+	s.sampledOut.Add(1)
+	return false
+}
+
+// sourceIP returns the host part of a remote address, or the address itself
+// if it has no port.
+func sourceIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,36 @@
+package syslog_server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSamplingUnderFlood(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.config.MaxMessages = 0
+	handler.sampler = newSampler(10, 100)
+	for i := 0; i < 2100; i++ {
+		handler.logMessage("<14>Jan 1 00:00:00 host app: flood", "10.0.0.1:514")
+	}
+	handler.logMessage("<14>Jan 1 00:00:00 host app: quiet", "10.0.0.2:514")
+
+	// 100 below the threshold, then 1 in 10 of the remaining 2000, plus the
+	// quiet source which is never sampled.
+	if got := len(handler.messages); got != 100+200+1 {
+		t.Errorf("expected 301 retained messages, got %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	statsHandler(handler)(rec, httptest.NewRequest("GET", "/stats", nil))
+	var stats map[string]uint64
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["sampledOut"] != 1800 {
+		t.Errorf("expected 1800 sampled out messages, got %d", stats["sampledOut"])
+	}
+}
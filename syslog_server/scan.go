@@ -0,0 +1,111 @@
+package syslog_server
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"syslog/syslog_anomaly"
+)
+
+// anomalyScanner looks for anomalies in the messages received since its
+// last scan every interval and adds them to the handler's anomaly cache, so
+// the web UI does not have to wait for the LLM. Stopping it cancels a scan
+// in progress, also while it waits to retry.
+type anomalyScanner struct {
+	handler  *logFileHandler
+	interval time.Duration
+	lastScan time.Time
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func startAnomalyScanner(handler *logFileHandler, interval time.Duration) *anomalyScanner {
+	s := &anomalyScanner{handler: handler, interval: interval}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.scan(s.ctx); err != nil && s.ctx.Err() == nil {
+					slog.Error("Error scanning for anomalies", "error", err)
+				}
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// scan analyzes the messages received since the last successful scan,
+// limited by the anomaly recent and window settings. The handler lock is not
+// held during the LLM call.
+func (s *anomalyScanner) scan(ctx context.Context) error {
+	config := s.handler.getConfig()
+	now := time.Now()
+	s.handler.mu.Lock()
+	messages := s.handler.messages
+	i := sort.Search(len(messages), func(i int) bool {
+		return messages[i].Received.After(s.lastScan)
+	})
+	recent := rawMessages(recentMessages(messages[i:], config.AnomalyRecent, config.AnomalyWindow, now))
+	s.handler.mu.Unlock()
+	if len(recent) == 0 {
+		return nil
+	}
+
+	anomalies, err := findAnomalies(ctx, config.llmConfig(), recent)
+	if err != nil {
+		return err
+	}
+	s.lastScan = now
+	s.handler.mu.Lock()
+	s.handler.recordAnomalies(anomalies)
+	s.handler.mu.Unlock()
+	return nil
+}
+
+func (s *anomalyScanner) stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// recordAnomalies adds newly found anomalies to the cache shown by the web
+// UI and notifies the webhook. With AnomalyContext set, the messages around
+// each anomaly are looked up while they are still buffered. The caller must
+// hold lh.mu.
+func (lh *logFileHandler) recordAnomalies(anomalies []syslog_anomaly.Anomaly) {
+	if lh.notifier != nil {
+		lh.notifier.notify(anomalies)
+	}
+	if n := lh.getConfig().AnomalyContext; n > 0 {
+		if lh.anomalyContexts == nil {
+			lh.anomalyContexts = make(map[string]*anomalyContext)
+		}
+		for _, anomaly := range anomalies {
+			if ctx := newAnomalyContext(lh.messages, anomaly.Message, n); ctx != nil {
+				lh.anomalyContexts[anomaly.Message] = ctx
+			}
+		}
+	}
+	lh.anomalies = syslog_anomaly.DedupAnomalies(append(lh.anomalies, anomalies...))
+	if len(lh.anomalyContexts) > 0 {
+		cached := make(map[string]bool, len(lh.anomalies))
+		for _, anomaly := range lh.anomalies {
+			cached[anomaly.Message] = true
+		}
+		for message := range lh.anomalyContexts {
+			if !cached[message] {
+				delete(lh.anomalyContexts, message)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package syslog_server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"syslog/syslog_anomaly"
+)
+
+func TestAnomalyScannerRunsPeriodically(t *testing.T) {
+	var mu sync.Mutex
+	var prompts []string
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req syslog_anomaly.CompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		prompts = append(prompts, string(req.Messages[0].Content))
+		n := len(prompts)
+		mu.Unlock()
+		content := fmt.Sprintf(`[{"message": "anomaly %d", "reason": "test", "severity": "high"}]`, n)
+		json.NewEncoder(w).Encode(syslog_anomaly.CompletionResponse{
+			Choices: []syslog_anomaly.Choice{{Message: syslog_anomaly.Message{Content: syslog_anomaly.MessageContent(content)}}},
+		})
+	}))
+	defer llm.Close()
+	scans := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(prompts)
+	}
+	waitForScans := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for scans() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d scans, got %d", n, scans())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := handler.getConfig()
+	config.ApiKey = "test"
+	config.Url = llm.URL
+	handler.logMessage("<13>Jan 1 00:00:00 host app: first", "127.0.0.1:514")
+	handler.scanner = startAnomalyScanner(handler, 20*time.Millisecond)
+	defer handler.scanner.stop()
+
+	waitForScans(1)
+	// Without new messages there is nothing to scan.
+	time.Sleep(100 * time.Millisecond)
+	if scans() != 1 {
+		t.Errorf("expected no scan without new messages, got %d", scans())
+	}
+	handler.logMessage("<13>Jan 1 00:00:01 host app: second", "127.0.0.1:514")
+	waitForScans(2)
+
+	mu.Lock()
+	if !strings.Contains(prompts[0], "first") || strings.Contains(prompts[1], "first") || !strings.Contains(prompts[1], "second") {
+		t.Errorf("expected each scan to cover only new messages, got %q", prompts)
+	}
+	mu.Unlock()
+
+	// The web UI shows the cached anomalies without calling the LLM.
+	config.AnomaliesOnly = true
+	messages, err := filteredMessages(context.Background(), handler, messageOrder{}, messagePage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || scans() != 2 {
+		t.Errorf("expected the 2 cached anomalies without another scan, got %+v after %d scans", messages, scans())
+	}
+	handler.mu.Lock()
+	buffered := len(handler.messages)
+	handler.mu.Unlock()
+	if buffered != 2 {
+		t.Errorf("expected background scans to keep the buffered messages, got %d", buffered)
+	}
+}
+
+func TestAnomalyScannerStopCancelsScan(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer llm.Close()
+	defer close(release)
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := handler.getConfig()
+	config.ApiKey = "test"
+	config.Url = llm.URL
+	handler.logMessage("<13>Jan 1 00:00:00 host app: first", "127.0.0.1:514")
+	scanner := startAnomalyScanner(handler, 10*time.Millisecond)
+	<-requested
+
+	stopped := make(chan struct{})
+	go func() {
+		scanner.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected stop to cancel the LLM call in progress")
+	}
+}
@@ -0,0 +1,160 @@
+package syslog_server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"syslog/syslog_client"
+)
+
+const (
+	// selfLogApp is the app name of the server's own diagnostics.
+	selfLogApp = "syslog_server"
+	// selfLogRate limits how many diagnostics are sent per second. A
+	// diagnostic about a self-logged message that is received by this
+	// server again would otherwise loop as fast as it can be sent.
+	selfLogRate = 100
+)
+
+// selfLogger sends the server's diagnostics as syslog messages, so they
+// flow through the same pipeline as other logs. Lines are sent in the
+// background; they are dropped when the queue is full or more than
+// selfLogRate arrive in a second. Debug records, such as the per-message
+// parse diagnostics, are not sent, as they would double the traffic and,
+// when sent to this server, be logged again for every self-logged message.
+type selfLogger struct {
+	client      *syslog_client.Client
+	host        string
+	mu          sync.Mutex
+	windowStart time.Time
+	sent        int
+	dropped     atomic.Uint64
+	queue       chan selfLogLine
+	wg          sync.WaitGroup
+}
+
+// newSelfLogger sends diagnostics to the syslog server at addr over proto.
+func newSelfLogger(proto, addr string) (*selfLogger, error) {
+	client, err := syslog_client.Dial(proto, addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	sl := &selfLogger{client: client, host: host, queue: make(chan selfLogLine, 1000)}
+	sl.wg.Add(1)
+	go sl.run()
+	return sl, nil
+}
+
+// parseSelfLogTarget parses "[proto://]addr", where proto defaults to udp.
+// "loopback" sends to this server's own UDP address.
+func parseSelfLogTarget(value, ownAddr string) (string, string, error) {
+	if value == "loopback" {
+		return "udp", ownAddr, nil
+	}
+	proto, addr := "udp", value
+	if p, a, ok := strings.Cut(value, "://"); ok {
+		proto, addr = p, a
+	}
+	if proto != "udp" && proto != "tcp" {
+		return "", "", fmt.Errorf("unsupported -selflog protocol %q, use udp or tcp", proto)
+	}
+	return proto, addr, nil
+}
+
+// handler returns a slog handler that passes records to next and also
+// sends them.
+func (sl *selfLogger) handler(next slog.Handler) slog.Handler {
+	return selfLogHandler{Handler: next, sl: sl}
+}
+
+// send queues the message of r with its attributes as key=value pairs.
+func (sl *selfLogger) send(r slog.Record) {
+	if r.Level < slog.LevelInfo || !sl.allow(time.Now()) {
+		return
+	}
+	var line strings.Builder
+	line.WriteString(r.Message)
+	r.Attrs(func(attr slog.Attr) bool {
+		fmt.Fprintf(&line, " %s=%v", attr.Key, attr.Value)
+		return true
+	})
+	select {
+	case sl.queue <- selfLogLine{severity: selfLogSeverity(r.Level), text: line.String()}:
+	default:
+		sl.dropped.Add(1)
+	}
+}
+
+type selfLogLine struct {
+	severity int
+	text     string
+}
+
+// selfLogHandler is the slog handler returned by selfLogger.handler.
+type selfLogHandler struct {
+	slog.Handler
+	sl *selfLogger
+}
+
+func (h selfLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.sl.send(r)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h selfLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return selfLogHandler{Handler: h.Handler.WithAttrs(attrs), sl: h.sl}
+}
+
+func (h selfLogHandler) WithGroup(name string) slog.Handler {
+	return selfLogHandler{Handler: h.Handler.WithGroup(name), sl: h.sl}
+}
+
+// allow reports whether another line may be sent in the current second.
+func (sl *selfLogger) allow(now time.Time) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if now.Sub(sl.windowStart) >= time.Second {
+		sl.windowStart = now
+		sl.sent = 0
+	}
+	if sl.sent >= selfLogRate {
+		sl.dropped.Add(1)
+		return false
+	}
+	sl.sent++
+	return true
+}
+
+func (sl *selfLogger) run() {
+	defer sl.wg.Done()
+	for line := range sl.queue {
+		// Errors cannot be logged here without looping back into send.
+		sl.client.Send(3, line.severity, sl.host, selfLogApp, line.text) // daemon
+	}
+}
+
+// selfLogSeverity maps the level of a diagnostic to a syslog severity.
+func selfLogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	default:
+		return 6 // info
+	}
+}
+
+// close sends the queued lines and closes the connection.
+func (sl *selfLogger) close() {
+	close(sl.queue)
+	sl.wg.Wait()
+	sl.client.Close()
+}
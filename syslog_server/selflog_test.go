@@ -0,0 +1,117 @@
+package syslog_server
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSelfLogger(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go serveUDP(conn, handler, nil)
+
+	proto, addr, err := parseSelfLogTarget("loopback", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	sl, err := newSelfLogger(proto, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(sl.handler(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	logger.Error("Error writing to log file", "error", "disk full")
+	logger.Debug("Parsed syslog message", "host", "h", "app", "a", "message", "m")
+	logger.Warn("Worker queue is filling up", "depth", 9000)
+	// The severity follows the level, not the wording.
+	logger.Info("Failed over to the backup server")
+	sl.close()
+
+	if n := strings.Count(out.String(), "\n"); n != 4 {
+		t.Errorf("expected all 4 lines in the log output, got %d:\n%s", n, out.String())
+	}
+	waitForMessages(t, handler, 3)
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if len(handler.messages) != 3 {
+		t.Fatalf("expected the parse diagnostic not to be sent, got %q", rawMessages(handler.messages))
+	}
+	for i, want := range []struct {
+		severity int
+		message  string
+	}{
+		{3, "Error writing to log file error=disk full"},
+		{4, "Worker queue is filling up depth=9000"},
+		{6, "Failed over to the backup server"},
+	} {
+		msg := handler.messages[i].Msg
+		if msg.Appname != selfLogApp || msg.Facility != 3 || msg.Severity != want.severity || msg.Message != want.message {
+			t.Errorf("message %d: got %+v, want daemon.%d %q from %s", i, msg, want.severity, want.message, selfLogApp)
+		}
+	}
+}
+
+func TestSelfLoggerSendsOneDatagramPerDiagnostic(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sl, err := newSelfLogger("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sl.close()
+	diag, err := newDiagHandler(io.Discard, "text", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := slog.Default()
+	setDiagHandler(sl.handler(diag))
+	defer func() {
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	// Sending a line must not log, or the log line would be sent too.
+	slog.Warn("Worker queue is filling up", "depth", 9000)
+	got := readDatagrams(conn)
+	if len(got) != 1 {
+		t.Fatalf("expected exactly one datagram, got %d", len(got))
+	}
+	if !strings.Contains(got[0], "Worker queue is filling up depth=9000") {
+		t.Errorf("unexpected datagram %q", got[0])
+	}
+}
+
+func TestParseSelfLogTarget(t *testing.T) {
+	for _, tt := range []struct {
+		value, proto, addr string
+	}{
+		{"loopback", "udp", ":514"},
+		{"10.0.0.5:514", "udp", "10.0.0.5:514"},
+		{"tcp://collector:601", "tcp", "collector:601"},
+	} {
+		proto, addr, err := parseSelfLogTarget(tt.value, ":514")
+		if err != nil || proto != tt.proto || addr != tt.addr {
+			t.Errorf("parseSelfLogTarget(%q) = %q, %q, %v; want %q, %q", tt.value, proto, addr, err, tt.proto, tt.addr)
+		}
+	}
+	if _, _, err := parseSelfLogTarget("http://collector", ":514"); err == nil {
+		t.Error("expected an error for an unsupported protocol")
+	}
+}
@@ -0,0 +1,51 @@
+package syslog_server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sequenceSDID is the structured data element that numbers forwarded
+// messages, like the client's default element under the example private
+// enterprise number.
+const sequenceSDID = "sequence@32473"
+
+// sequenceMessages makes each forwarder number the messages it forwards,
+// from 1 and in queue order, tagged with origin to identify this server.
+// A receiver can then detect reordered or lost messages per origin.
+func (lh *logFileHandler) sequenceMessages(origin string) {
+	for _, fw := range lh.forwarders() {
+		fw.origin = origin
+	}
+}
+
+// addSequence adds [sequence@32473 origin="..." seq="N"] to message. It is
+// the first structured data element of RFC 5424 messages; other messages
+// have no structured data, so it is put in front of their text, after the
+// tag.
+func addSequence(message, origin string, seq uint64) string {
+	element := fmt.Sprintf(`[%s origin="%s" seq="%d"]`, sequenceSDID, escapeSDValue(origin), seq)
+	end := strings.Index(message, ">") + 1
+	header, rest := message[:end], message[end:]
+	if strings.HasPrefix(rest, "1 ") {
+		parts := strings.SplitN(rest, " ", 7)
+		if len(parts) == 7 {
+			if sd, found := strings.CutPrefix(parts[6], "-"); found && (sd == "" || sd[0] == ' ') {
+				parts[6] = element + sd
+			} else {
+				parts[6] = element + parts[6]
+			}
+			return header + strings.Join(parts, " ")
+		}
+	}
+	if tag, text, found := strings.Cut(rest, ": "); found {
+		return header + tag + ": " + element + " " + text
+	}
+	return header + element + " " + rest
+}
+
+// escapeSDValue escapes '"', '\' and ']', which must not appear unescaped
+// in structured data parameter values (RFC 5424 6.3.3).
+func escapeSDValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
@@ -0,0 +1,68 @@
+package syslog_server
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestAddSequence(t *testing.T) {
+	tests := []struct {
+		message, want string
+	}{
+		{"<14>Jan 1 00:00:00 host app: hello", `<14>Jan 1 00:00:00 host app: [sequence@32473 origin="relay-1" seq="7"] hello`},
+		{"<14>1 2024-01-02T03:04:05Z host app 42 - - hello", `<14>1 2024-01-02T03:04:05Z host app 42 - [sequence@32473 origin="relay-1" seq="7"] hello`},
+		{"<14>1 2024-01-02T03:04:05Z host app 42 - -", `<14>1 2024-01-02T03:04:05Z host app 42 - [sequence@32473 origin="relay-1" seq="7"]`},
+		{`<14>1 2024-01-02T03:04:05Z host app 42 - [x@1 a="b"] hello`, `<14>1 2024-01-02T03:04:05Z host app 42 - [sequence@32473 origin="relay-1" seq="7"][x@1 a="b"] hello`},
+		{"<14>link down", `<14>[sequence@32473 origin="relay-1" seq="7"] link down`},
+	}
+	for _, tt := range tests {
+		if got := addSequence(tt.message, "relay-1", 7); got != tt.want {
+			t.Errorf("addSequence(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+	if got := addSequence("<14>host app: hi", `a"b]`, 1); got != `<14>host app: [sequence@32473 origin="a\"b\]" seq="1"] hi` {
+		t.Errorf("expected the origin to be escaped, got %q", got)
+	}
+}
+
+func TestForwardSequenceNumbers(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	handler, err := createLogFileHandler("", 10, upstream.LocalAddr().String(), "udp", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.sequenceMessages("relay-1")
+	const n = 50
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			handler.logMessage(fmt.Sprintf("<14>Jan 1 00:00:00 web-01 api: request %d", i), "127.0.0.1:5140")
+		} else {
+			handler.logMessage(fmt.Sprintf("<14>1 2024-01-02T03:04:05Z web-01 api - - - request %d", i), "127.0.0.1:5140")
+		}
+	}
+	handler.forwarder.close()
+
+	got := readDatagrams(upstream)
+	if len(got) != n {
+		t.Fatalf("expected %d forwarded messages, got %d", n, len(got))
+	}
+	sequence := regexp.MustCompile(`\[sequence@32473 origin="relay-1" seq="(\d+)"\] request (\d+)$`)
+	for i, message := range got {
+		m := sequence.FindStringSubmatch(message)
+		if m == nil {
+			t.Fatalf("message %d has no sequence: %q", i, message)
+		}
+		seq, _ := strconv.Atoi(m[1])
+		if seq != i+1 || m[2] != strconv.Itoa(i) {
+			t.Errorf("message %d: expected seq %d for request %d, got %q", i, i+1, i, message)
+		}
+	}
+}
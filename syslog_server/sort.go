@@ -0,0 +1,72 @@
+package syslog_server
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// messageOrder is the order of the message table. The zero value keeps
+// arrival order.
+type messageOrder struct {
+	field string
+	desc  bool
+}
+
+// messageSortKeys compare messages by each sortable field. Severity compares
+// by importance, so the most severe messages sort last in ascending order.
+var messageSortKeys = map[string]func(a, b *syslogMsg) int{
+	"time": func(a, b *syslogMsg) int {
+		return parseTimestamp(a.Timestamp).Compare(parseTimestamp(b.Timestamp))
+	},
+	"host": func(a, b *syslogMsg) int { return strings.Compare(a.Hostname, b.Hostname) },
+	"app":  func(a, b *syslogMsg) int { return strings.Compare(a.Appname, b.Appname) },
+	"severity": func(a, b *syslogMsg) int {
+		return cmp.Compare(b.Severity, a.Severity)
+	},
+}
+
+// parseMessageOrder parses the sort and order query parameters of /messages.
+func parseMessageOrder(field, order string) (messageOrder, error) {
+	if field == "" {
+		return messageOrder{}, nil
+	}
+	if _, ok := messageSortKeys[field]; !ok {
+		return messageOrder{}, fmt.Errorf("unsupported sort %q, use time, host, app or severity", field)
+	}
+	switch order {
+	case "", "asc":
+		return messageOrder{field: field}, nil
+	case "desc":
+		return messageOrder{field: field, desc: true}, nil
+	}
+	return messageOrder{}, fmt.Errorf("unsupported order %q, use asc or desc", order)
+}
+
+// sort sorts messages in place. Ties keep their arrival order.
+func (o messageOrder) sort(messages []syslogMsg) {
+	compare, ok := messageSortKeys[o.field]
+	if !ok {
+		return
+	}
+	slices.SortStableFunc(messages, func(a, b syslogMsg) int {
+		if o.desc {
+			return compare(&b, &a)
+		}
+		return compare(&a, &b)
+	})
+}
+
+// parseTimestamp parses an RFC 3164 or RFC 5424 timestamp. Timestamps that
+// do not parse sort before all others.
+func parseTimestamp(timestamp string) time.Time {
+	t, err := time.Parse("Jan 2 15:04:05", timestamp)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return time.Time{}
+		}
+	}
+	return t
+}
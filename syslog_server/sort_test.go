@@ -0,0 +1,76 @@
+package syslog_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMessagesHandlerSortsBySeverityDescending(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{
+		"<14>Jan 1 00:00:00 host app: info-first",
+		"<11>Jan 1 00:00:01 host app: err",
+		"<8>Jan 1 00:00:02 host kernel: emerg",
+		"<15>Jan 1 00:00:03 host app: debug",
+		"<14>Jan 1 00:00:04 host app: info-second",
+	} {
+		handler.logMessage(msg, "127.0.0.1:514")
+	}
+
+	rec := httptest.NewRecorder()
+	messagesHandler(handler, testTemplates(t))(rec, httptest.NewRequest(http.MethodGet, "/messages?sort=severity&order=desc", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var got []string
+	for _, m := range regexp.MustCompile(`(emerg|err|info-first|info-second|debug)</td>`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		got = append(got, m[1])
+	}
+	if want := "emerg err info-first info-second debug"; strings.Join(got, " ") != want {
+		t.Errorf("got order %v, want %s", got, want)
+	}
+}
+
+func TestMessageOrderSort(t *testing.T) {
+	messages := []syslogMsg{
+		{Timestamp: "Jan 10 00:00:00", Hostname: "b", Appname: "y"},
+		{Timestamp: "Jan 2 00:00:00", Hostname: "a", Appname: "z"},
+		{Timestamp: "Feb 1 00:00:00", Hostname: "c", Appname: "x"},
+	}
+	for _, tt := range []struct {
+		field, order string
+		want         string
+	}{
+		{"time", "asc", "abc"},
+		{"time", "desc", "cba"},
+		{"host", "", "abc"},
+		{"app", "", "cba"},
+		{"", "", "bac"},
+	} {
+		order, err := parseMessageOrder(tt.field, tt.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sorted := append([]syslogMsg(nil), messages...)
+		order.sort(sorted)
+		var hosts string
+		for _, m := range sorted {
+			hosts += m.Hostname
+		}
+		if hosts != tt.want {
+			t.Errorf("sort=%s order=%s: got %s, want %s", tt.field, tt.order, hosts, tt.want)
+		}
+	}
+
+	for _, bad := range [][2]string{{"size", ""}, {"time", "up"}} {
+		if _, err := parseMessageOrder(bad[0], bad[1]); err == nil {
+			t.Errorf("expected an error for sort=%s order=%s", bad[0], bad[1])
+		}
+	}
+}
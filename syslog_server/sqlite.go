@@ -0,0 +1,316 @@
+package syslog_server
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"modernc.org/sqlite"
+)
+
+func init() {
+	// SQLite has the REGEXP operator but no implementation of it, which
+	// message patterns need.
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, sqliteRegexp)
+}
+
+// sqliteRegexpCache holds the last compiled pattern, as a query matches
+// every row against the same one.
+var sqliteRegexpCache struct {
+	sync.Mutex
+	re *regexp.Regexp
+}
+
+func sqliteRegexp(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, _ := args[0].(string)
+	value, _ := args[1].(string)
+	sqliteRegexpCache.Lock()
+	re := sqliteRegexpCache.re
+	if re == nil || re.String() != pattern {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			sqliteRegexpCache.Unlock()
+			return nil, err
+		}
+		sqliteRegexpCache.re = re
+	}
+	sqliteRegexpCache.Unlock()
+	if re.MatchString(value) {
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id          INTEGER PRIMARY KEY,
+	received    INTEGER NOT NULL,
+	timestamp   TEXT NOT NULL,
+	host        TEXT NOT NULL,
+	app         TEXT NOT NULL,
+	facility    INTEGER NOT NULL,
+	severity    INTEGER NOT NULL,
+	message     TEXT NOT NULL,
+	remote_addr TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_received ON messages (received);
+`
+
+// sqliteStore keeps every parsed message in a SQLite database, so history
+// survives restarts and is not limited to the in-memory buffer. Messages are
+// stored with the ID they were given at ingest. They are queued and inserted
+// in batches from a goroutine, so slow disks do not block ingest; when the
+// queue is full, messages are dropped and counted.
+type sqliteStore struct {
+	db      *sql.DB
+	queue   chan sqliteRow
+	dropped atomic.Uint64
+	wg      sync.WaitGroup
+}
+
+// sqliteRow is a message queued for insertion, or, with done set, a marker
+// closed once the messages queued before it are stored.
+type sqliteRow struct {
+	stored storedMessage
+	done   chan struct{}
+}
+
+// sqliteBatchSize is the most messages inserted in one transaction.
+const sqliteBatchSize = 500
+
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// Writes are serialized by the insert goroutine; one connection also
+	// keeps ":memory:" databases shared between queries.
+	db.SetMaxOpenConns(1)
+	for _, pragma := range []string{"PRAGMA journal_mode=WAL", "PRAGMA synchronous=NORMAL", "PRAGMA busy_timeout=5000"} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to configure database: %w", err)
+		}
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create messages table: %w", err)
+	}
+	s := &sqliteStore{db: db, queue: make(chan sqliteRow, 10000)}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// lastID returns the highest stored message ID, so IDs keep increasing
+// across restarts.
+func (s *sqliteStore) lastID() (uint64, error) {
+	var id sql.NullInt64
+	if err := s.db.QueryRow("SELECT MAX(id) FROM messages").Scan(&id); err != nil {
+		return 0, err
+	}
+	return uint64(id.Int64), nil
+}
+
+// run inserts the queued messages, batching those that queued up during the
+// previous insert.
+func (s *sqliteStore) run() {
+	defer s.wg.Done()
+	for row := range s.queue {
+		var batch []storedMessage
+		var done []chan struct{}
+	drain:
+		for {
+			if row.done != nil {
+				done = append(done, row.done)
+			} else {
+				batch = append(batch, row.stored)
+			}
+			if len(batch) >= sqliteBatchSize {
+				break
+			}
+			select {
+			case next, ok := <-s.queue:
+				if !ok {
+					break drain
+				}
+				row = next
+			default:
+				break drain
+			}
+		}
+		if err := s.insert(batch); err != nil {
+			slog.Error("Error storing messages in the database", "count", len(batch), "error", err)
+		}
+		for _, ch := range done {
+			close(ch)
+		}
+	}
+}
+
+// insert stores parsed messages in one transaction.
+func (s *sqliteStore) insert(batch []storedMessage) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO messages (id, received, timestamp, host, app, facility, severity, message, remote_addr)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, stored := range batch {
+		msg := stored.Msg
+		if _, err := stmt.Exec(int64(msg.ID), stored.Received.UnixNano(), msg.Timestamp, msg.Hostname, msg.Appname,
+			msg.Facility, msg.Severity, msg.Message, stored.RemoteAddr); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// flush waits until the messages queued so far are stored.
+func (s *sqliteStore) flush() {
+	done := make(chan struct{})
+	s.queue <- sqliteRow{done: done}
+	<-done
+}
+
+const sqliteColumns = "id, timestamp, host, app, facility, severity, message, remote_addr"
+
+func scanMessage(row interface{ Scan(...any) error }) (syslogMsg, error) {
+	var msg syslogMsg
+	var id int64
+	var remoteAddr string
+	err := row.Scan(&id, &msg.Timestamp, &msg.Hostname, &msg.Appname, &msg.Facility, &msg.Severity, &msg.Message, &remoteAddr)
+	msg.ID = uint64(id)
+	msg.Source = sourceIP(remoteAddr)
+	return msg, err
+}
+
+// get returns the stored message with id.
+func (s *sqliteStore) get(id uint64) (syslogMsg, bool, error) {
+	msg, err := scanMessage(s.db.QueryRow("SELECT "+sqliteColumns+" FROM messages WHERE id = ?", int64(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return syslogMsg{}, false, nil
+	}
+	return msg, err == nil, err
+}
+
+// sqliteOrderBy maps the sortable fields of the message table to columns.
+// Severity sorts by importance like the in-memory sort, and time by when
+// messages were received.
+var sqliteOrderBy = map[string]string{
+	"time":     "received %s, id %[1]s",
+	"host":     "host %s, id",
+	"app":      "app %s, id",
+	"severity": "severity %s, id",
+}
+
+// query returns the stored messages matching the config filters, sorted by
+// order. Without a sort field the most recent page of messages is returned
+// in arrival order.
+func (s *sqliteStore) query(config *Config, order messageOrder, page messagePage) ([]syslogMsg, error) {
+	var where []string
+	var args []any
+	for _, filter := range [][2]string{{"app", config.AppName}, {"host", config.HostName}} {
+		values := filterValues(filter[1])
+		if len(values) == 0 {
+			continue
+		}
+		var matches []string
+		for _, value := range values {
+			matches = append(matches, "instr("+filter[0]+", ?) > 0")
+			args = append(args, value)
+		}
+		where = append(where, "("+strings.Join(matches, " OR ")+")")
+	}
+	if config.MessagePattern != "" {
+		if isRegexp(config.MessagePattern) {
+			where = append(where, "message REGEXP ?")
+		} else {
+			where = append(where, "instr(message, ?) > 0")
+		}
+		args = append(args, config.MessagePattern)
+	}
+
+	query := "SELECT " + sqliteColumns + " FROM messages"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if orderBy, ok := sqliteOrderBy[order.field]; ok {
+		direction := "ASC"
+		if order.desc != (order.field == "severity") {
+			direction = "DESC"
+		}
+		query += " ORDER BY " + fmt.Sprintf(orderBy, direction)
+	} else {
+		query += " ORDER BY id DESC"
+	}
+	// A zero limit does not limit the result; SQLite takes -1 for that.
+	limit := page.limit
+	if limit == 0 {
+		limit = -1
+	}
+	query += " LIMIT ? OFFSET ?"
+	args = append(args, limit, page.offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+	messages := []syslogMsg{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	if order.field == "" {
+		slices.Reverse(messages)
+	}
+	return messages, nil
+}
+
+// Write queues a parsed message for storing; malformed messages are not
+// stored.
+func (s *sqliteStore) Write(stored storedMessage) error {
+	if stored.Malformed {
+		return nil
+	}
+	select {
+	case s.queue <- sqliteRow{stored: stored}:
+	default:
+		if s.dropped.Add(1) == 1 {
+			slog.Warn("Database queue is full, dropping further messages", "queued", cap(s.queue))
+		}
+	}
+	return nil
+}
+
+// Close stores the queued messages and closes the database.
+func (s *sqliteStore) Close() error {
+	close(s.queue)
+	s.wg.Wait()
+	if n := s.dropped.Load(); n > 0 {
+		slog.Warn("Database output dropped messages due to a full queue", "count", n)
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,154 @@
+package syslog_server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syslog.db")
+	store, err := openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.store = store
+	handler.addOutput(store)
+	handler.config.MaxMessages = 3
+	inputs := []string{
+		"<11>Jan 1 00:00:00 web-01 nginx: upstream timed out",
+		"<14>Jan 1 00:00:01 web-02 nginx: GET /index.html 200",
+		"<10>Jan 1 00:00:02 db-01 postgres: could not write block",
+		"not a syslog message",
+		"<14>Jan 1 00:00:03 web-01 sshd: Accepted publickey for alice",
+		"<12>Jan 1 00:00:04 db-01 postgres: checkpoint took 12s",
+	}
+	for _, input := range inputs {
+		handler.logMessage(input, "10.0.0.1:514")
+	}
+	// Messages are stored in the background.
+	store.flush()
+
+	query := func(config Config, order messageOrder, page messagePage) string {
+		t.Helper()
+		messages, err := store.query(&config, order, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, msg := range messages {
+			got = append(got, fmt.Sprintf("%d %s %s", msg.ID, msg.Hostname, msg.Appname))
+		}
+		return fmt.Sprint(got)
+	}
+	tests := []struct {
+		name   string
+		config Config
+		order  messageOrder
+		page   messagePage
+		want   string
+	}{
+		{"all", Config{}, messageOrder{}, messagePage{limit: 10}, "[1 web-01 nginx 2 web-02 nginx 3 db-01 postgres 5 web-01 sshd 6 db-01 postgres]"},
+		{"most recent page", Config{}, messageOrder{}, messagePage{limit: 2}, "[5 web-01 sshd 6 db-01 postgres]"},
+		{"offset", Config{}, messageOrder{}, messagePage{limit: 2, offset: 2}, "[2 web-02 nginx 3 db-01 postgres]"},
+		{"app", Config{AppName: "post"}, messageOrder{}, messagePage{limit: 10}, "[3 db-01 postgres 6 db-01 postgres]"},
+		{"host", Config{HostName: "web"}, messageOrder{}, messagePage{limit: 10}, "[1 web-01 nginx 2 web-02 nginx 5 web-01 sshd]"},
+		{"app list", Config{AppName: " sshd, post ,"}, messageOrder{}, messagePage{limit: 10}, "[3 db-01 postgres 5 web-01 sshd 6 db-01 postgres]"},
+		{"app and host lists", Config{AppName: "nginx,sshd", HostName: "web-02,db-01"}, messageOrder{}, messagePage{limit: 10}, "[2 web-02 nginx]"},
+		{"substring", Config{MessagePattern: "GET /index.html ("}, messageOrder{}, messagePage{limit: 10}, "[]"},
+		{"regexp", Config{MessagePattern: "^(upstream|checkpoint) "}, messageOrder{}, messagePage{limit: 10}, "[1 web-01 nginx 6 db-01 postgres]"},
+		{"combined", Config{HostName: "web-01", MessagePattern: "Accepted"}, messageOrder{}, messagePage{limit: 10}, "[5 web-01 sshd]"},
+		{"severity", Config{}, messageOrder{field: "severity", desc: true}, messagePage{limit: 2}, "[3 db-01 postgres 1 web-01 nginx]"},
+		{"host order", Config{}, messageOrder{field: "host"}, messagePage{limit: 10}, "[3 db-01 postgres 6 db-01 postgres 1 web-01 nginx 5 web-01 sshd 2 web-02 nginx]"},
+	}
+	for _, tt := range tests {
+		if got := query(tt.config, tt.order, tt.page); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// /messages queries the database, limited to MaxMessages by default.
+	get := func(path string) []syslogMsg {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		messagesHandler(handler, testTemplates(t))(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, rec.Code, rec.Body)
+		}
+		var messages []syslogMsg
+		if err := json.NewDecoder(rec.Body).Decode(&messages); err != nil {
+			t.Fatal(err)
+		}
+		return messages
+	}
+	if messages := get("/messages?format=json"); len(messages) != 3 || messages[0].ID != 3 {
+		t.Errorf("expected the 3 most recent messages, got %+v", messages)
+	}
+	if messages := get("/messages?format=json&limit=1&offset=4"); len(messages) != 1 || messages[0].Message != "upstream timed out" {
+		t.Errorf("expected the oldest message, got %+v", messages)
+	}
+	// A MaxMessages of 0 does not limit the messages listed.
+	handler.config.MaxMessages = 0
+	if messages := get("/messages?format=json"); len(messages) != 5 {
+		t.Errorf("expected all 5 stored messages without a limit, got %d", len(messages))
+	}
+	handler.config.MaxMessages = 3
+	rec := httptest.NewRecorder()
+	messagesHandler(handler, testTemplates(t))(rec, httptest.NewRequest(http.MethodGet, "/messages?format=json&limit=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative limit, got %d", rec.Code)
+	}
+
+	// Messages pushed out of the buffer are still found by ID.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/messages/{id}", messageByIDHandler(handler))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages/1", nil))
+	var msg syslogMsg
+	if err := json.NewDecoder(rec.Body).Decode(&msg); err != nil || msg.ID != 1 || msg.Appname != "nginx" {
+		t.Errorf("expected message 1 from the database, got %d %+v %v", rec.Code, msg, err)
+	}
+
+	// IDs continue after a restart.
+	store.Close()
+	store, err = openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if id, err := store.lastID(); err != nil || id != 6 {
+		t.Errorf("expected last ID 6 after reopening, got %d, %v", id, err)
+	}
+	if got := query(Config{AppName: "sshd"}, messageOrder{}, messagePage{limit: 10}); got != "[5 web-01 sshd]" {
+		t.Errorf("expected messages to persist, got %s", got)
+	}
+}
+
+func TestMessagePage(t *testing.T) {
+	messages := []syslogMsg{{ID: 1}, {ID: 2}, {ID: 3}}
+	for _, tt := range []struct {
+		page messagePage
+		want int
+	}{
+		{messagePage{}, 3},
+		{messagePage{limit: 2}, 2},
+		{messagePage{offset: 2}, 1},
+		{messagePage{limit: 2, offset: 5}, 0},
+	} {
+		if got := tt.page.apply(messages); len(got) != tt.want {
+			t.Errorf("%+v: got %d messages, want %d", tt.page, len(got), tt.want)
+		}
+	}
+	for _, args := range [][2]string{{"x", ""}, {"", "-1"}, {"-2", ""}} {
+		if _, err := parseMessagePage(args[0], args[1]); err == nil {
+			t.Errorf("expected an error for limit %q offset %q", args[0], args[1])
+		}
+	}
+}
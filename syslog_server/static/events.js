@@ -0,0 +1,34 @@
+// Flashes the page, and beeps if enabled, when /events pushes a severe
+// message, so a wall display does not have to be watched constantly.
+var severityNames = ["emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"];
+
+function beep() {
+    var AudioContext = window.AudioContext || window.webkitAudioContext;
+    if (!AudioContext) return;
+    var ctx = new AudioContext();
+    var osc = ctx.createOscillator();
+    osc.frequency.value = 880;
+    osc.connect(ctx.destination);
+    osc.start();
+    osc.stop(ctx.currentTime + 0.2);
+    osc.onended = function() { ctx.close(); };
+}
+
+document.addEventListener('DOMContentLoaded', function() {
+    var banner = document.getElementById("event-alert");
+    if (!banner || !window.EventSource) return;
+    var sound = document.getElementById("event-sound");
+    var hideTimer;
+    var source = new EventSource("/events");
+    source.addEventListener("message", function(event) {
+        var msg = JSON.parse(event.data);
+        banner.textContent = "[" + severityNames[msg.severity] + "] " + msg.hostname + " " + msg.appname + ": " + msg.message;
+        banner.hidden = false;
+        document.body.classList.remove("event-flash");
+        void document.body.offsetWidth; // restart the animation
+        document.body.classList.add("event-flash");
+        if (sound && sound.checked) beep();
+        clearTimeout(hideTimer);
+        hideTimer = setTimeout(function() { banner.hidden = true; }, 10000);
+    });
+});
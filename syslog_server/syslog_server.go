@@ -1,21 +1,38 @@
-package main
+// Package syslog_server implements the syslog server, web UI and REST API.
+package syslog_server
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
-	"log"
+	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	texttemplate "text/template"
+	"time"
+
+	"syslog/syslog_anomaly"
+	"syslog/syslog_client"
 
 	"github.com/natefinch/lumberjack"
 )
@@ -30,61 +47,231 @@ type logFileHandler struct {
 	filename          string
 	forwardAddr       string
 	forwardProto      string
-	forwardConn       net.Conn
+	forwarder         *forwarder
 	forwardLevel      int
+	forwardRoutes     []forwardRoute
 	mu                sync.Mutex
 	disableLogging    bool
 	disableForwarding bool
-	messages          []string
-	anomalies         []string
+	messages          []storedMessage
+	anomalies         []syslog_anomaly.Anomaly
+	anomalyContexts   map[string]*anomalyContext
+	analyzeMu         sync.Mutex // serializes on-demand anomaly analysis
 	config            *Config
 	muConfig          sync.Mutex
+	outputs           []Output
+	sampler           *sampler
+	routes            []logRoute
+	facilityLogs      map[int]*lumberjack.Logger
+	geoIP             *geoIP
+	logFormat         *texttemplate.Template
+	jsonLog           bool
+	maxMsgLen         int
+	maxBodySize       int64
+	dropLong          bool
+	evictBySeverity   bool
+	strictParse       bool
+	dropMalformed     bool
+	showMalformed     bool
+	workerPool        *workerPool
+	multiline         *multilineBuffer
+	sources           *sourceFilter
+	notifier          *anomalyNotifier
+	alerter           *alertNotifier
+	events            eventHub
+	scanner           *anomalyScanner
+	retention         *retentionSweeper
+	capture           *packetCapture
+	store             *sqliteStore
+	now               func() time.Time
+	lastID            uint64
+	evictedID         uint64
+	bufferedByRank    [8]int // buffered messages by evictionRank
+	received          atomic.Uint64
+	receivedRate      rateCounter
+	tcpConnections    atomic.Int64
+	peerNames         sync.Map // remote address of a TLS connection to its client certificate name
+	tcpRefused        atomic.Uint64
+	severityCounts    [8]atomic.Uint64
+	priorityCounts    [24][8]atomic.Uint64
 }
 
 type Config struct {
-	MaxMessages    int    `json:"maxMessages"`
-	DisableLog     bool   `json:"disableLog"`
-	AnomaliesOnly  bool   `json:"anomaliesOnly"`
+	MaxMessages    int      `json:"maxMessages"`
+	DisableLog     bool     `json:"disableLog"`
+	AnomaliesOnly  bool     `json:"anomaliesOnly"`
+	MessagePattern string   `json:"messagepattern"`
+	Severity       int      `json:"severity"`
+	AppName        string   `json:"appname"`
+	HostName       string   `json:"hostname"`
+	ApiKey         string   `json:"apiKey,omitempty"`
+	Provider       string   `json:"provider,omitempty"`
+	Url            string   `json:"url"`
+	Model          string   `json:"model"`
+	LogFile        string   `json:"logfile"`
+	Prompt         string   `json:"prompt"`
+	Temperature    *float64 `json:"temperature,omitempty"`
+	MaxTokens      int      `json:"maxTokens"`
+	MaxRetries     int      `json:"maxRetries"`
+	// AnomalyRecent limits anomaly analysis to the most recent messages and
+	// AnomalyWindow to those received within the window. Zero means all.
+	AnomalyRecent int           `json:"anomalyRecent"`
+	AnomalyWindow time.Duration `json:"anomalyWindow"`
+	// MinConfidence hides anomalies the LLM is less confident about, from
+	// 0 to 1. Zero shows all.
+	MinConfidence float64 `json:"minConfidence"`
+	// AnomalyContext is how many messages received before and after the
+	// message an anomaly was found in are shown with it.
+	AnomalyContext int `json:"anomalyContext"`
+	// MaxAge drops buffered messages received longer ago than this,
+	// whatever MaxMessages allows. Zero keeps messages until they are
+	// pushed out by newer ones.
+	MaxAge time.Duration `json:"maxAge"`
+	// HostFromSource shows the source IP as the host name of messages that
+	// have none or "-".
+	HostFromSource bool `json:"hostFromSource"`
+	// DisplayTimezone converts the timestamps shown in the UI to this zone,
+	// e.g. "UTC", "Local" or "Europe/Paris". Empty shows them as received.
+	// SourceTimezone is assumed for RFC 3164 timestamps, which have no zone;
+	// empty means Local.
+	DisplayTimezone string `json:"displayTimezone,omitempty"`
+	SourceTimezone  string `json:"sourceTimezone,omitempty"`
+	// ShowMalformedOnly shows only the buffered messages that could not be
+	// parsed, with the parse error, to debug misconfigured senders.
+	ShowMalformedOnly bool `json:"showMalformedOnly"`
+	// WebhookURL receives newly detected anomalies. Like ApiKey it is
+	// left out of GET /config as it may embed a token.
+	WebhookURL string `json:"webhookUrl,omitempty"`
+	// AlertURL is a Slack or Teams incoming webhook that receives messages
+	// of AlertSeverity or worse. It is left out of GET /config too.
+	AlertURL      string `json:"alertUrl,omitempty"`
+	AlertSeverity int    `json:"alertSeverity"`
+	// EventSeverity is the default threshold of messages pushed to /events.
+	EventSeverity int `json:"eventSeverity"`
+	// Presets are named filter combinations saved from the settings page.
+	Presets map[string]FilterPreset `json:"presets,omitempty"`
+	// SeverityRules override the severity of matching messages in order;
+	// the first match wins.
+	SeverityRules []SeverityRule `json:"severityRules,omitempty"`
+	// RedactRules rewrite the text of incoming messages before they are
+	// stored or forwarded, in order.
+	RedactRules []RedactRule `json:"redactRules,omitempty"`
+	// IncludeRaw keeps the received text of each message in the JSON API
+	// and JSON log lines alongside the parsed fields.
+	IncludeRaw bool `json:"includeRaw"`
+}
+
+// FilterPreset is a saved combination of the message filters in Config.
+type FilterPreset struct {
 	MessagePattern string `json:"messagepattern"`
 	Severity       int    `json:"severity"`
 	AppName        string `json:"appname"`
 	HostName       string `json:"hostname"`
-	ApiKey         string `json:"apiKey"`
-	Url            string `json:"url"`
-	Model          string `json:"model"`
-	LogFile        string `json:"logfile"`
 }
 
 type syslogMsg struct {
-	Timestamp string `json:"timestamp"`
-	Hostname  string `json:"hostname"`
-	Appname   string `json:"appname"`
-	Message   string `json:"message"`
+	// ID identifies a buffered message for /messages/{id}. Anomalies
+	// reported by the LLM have none.
+	ID              uint64 `json:"id,omitempty"`
+	Timestamp       string `json:"timestamp"`
+	Hostname        string `json:"hostname"`
+	Appname         string `json:"appname"`
+	Pid             string `json:"pid,omitempty"`
+	Message         string `json:"message"`
+	Facility        int    `json:"facility"`
+	Severity        int    `json:"severity"`
+	AnomalyReason   string `json:"anomalyReason,omitempty"`
+	AnomalySeverity string `json:"anomalySeverity,omitempty"`
+	// ParseError is why a malformed message could not be parsed; its
+	// Message is then the raw text.
+	ParseError string `json:"parseError,omitempty"`
+	// Source is the IP address the message was received from, which may
+	// differ from a missing or spoofed Hostname.
+	Source    string `json:"source,omitempty"`
+	Country   string `json:"country,omitempty"`
+	City      string `json:"city,omitempty"`
+	Forwarded bool   `json:"forwarded"`
+	// ClientCert is the common name of the TLS client certificate of the
+	// connection the message was received on, a trusted source identifier.
+	ClientCert string `json:"clientCert,omitempty"`
+	// Context is the buffered messages around an anomaly.
+	Context *anomalyContext `json:"context,omitempty"`
+	// Raw is the message as received, with -includeraw, for auditing and
+	// reprocessing.
+	Raw string `json:"raw,omitempty"`
 }
 
-type CompletionRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+// storedMessage is a message kept in memory for the web UI and API. It is
+// parsed once at ingest so rendering only has to filter the parsed fields.
+// Malformed marks messages that are not in syslog format; Msg then holds
+// only the ingest metadata and ParseError the reason.
+type storedMessage struct {
+	Raw        string
+	RemoteAddr string
+	Received   time.Time
+	Msg        syslogMsg
+	Malformed  bool
+	ParseError string
 }
 
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+// newStoredMessage parses raw for storage. Unless strict is set, messages
+// with a priority that are too short for syslog format, such as
+// "<13>link down" from embedded devices, are kept as minimal messages.
+func newStoredMessage(raw, remoteAddr string, received time.Time, strict bool) storedMessage {
+	stored := storedMessage{Raw: raw, RemoteAddr: remoteAddr, Received: received}
+	msg, err := parseSyslogMessage(raw)
+	if err != nil && !strict {
+		msg, err = parseMinimalMessage(raw)
+	}
+	if err != nil {
+		slog.Warn("Error parsing message", "remote_addr", remoteAddr, "error", err)
+		stored.Malformed = true
+		stored.ParseError = err.Error()
+	} else {
+		stored.Msg = *msg
+	}
+	stored.Msg.Source = sourceIP(remoteAddr)
+	return stored
 }
 
-type CompletionResponse struct {
-	ID      string   `json:"id"`
-	Choices []Choice `json:"choices"`
+// malformedMessage describes a message that could not be parsed for
+// display, with its raw text as the body. Like countMessage it is shown as
+// notice unless it has a valid priority.
+func (stored *storedMessage) malformedMessage() syslogMsg {
+	msg := stored.Msg
+	msg.Message = cleanString(stored.Raw)
+	msg.ParseError = stored.ParseError
+	facility, severity, err := parsePriority(stored.Raw)
+	if err != nil || severity < 0 {
+		facility, severity = 1, 5
+	}
+	msg.Facility, msg.Severity = facility, severity
+	return msg
 }
 
-type Choice struct {
-	Message Message `json:"message"`
+// recentMessages returns the last n messages received within window of
+// now. A zero n or window does not limit the result.
+func recentMessages(messages []storedMessage, n int, window time.Duration, now time.Time) []storedMessage {
+	if n > 0 && len(messages) > n {
+		messages = messages[len(messages)-n:]
+	}
+	if window > 0 {
+		cutoff := now.Add(-window)
+		i := sort.Search(len(messages), func(i int) bool {
+			return !messages[i].Received.Before(cutoff)
+		})
+		messages = messages[i:]
+	}
+	return messages
 }
 
-type LLMConfig struct {
-	apiKey string
-	model  string
-	url    string
+// rawMessages returns the raw text of the stored messages.
+func rawMessages(messages []storedMessage) []string {
+	raw := make([]string, len(messages))
+	for i, sm := range messages {
+		raw[i] = sm.Raw
+	}
+	return raw
 }
 
 func createLogFileHandler(filename string, maxSize int, forwardAddr,
@@ -97,12 +284,17 @@ func createLogFileHandler(filename string, maxSize int, forwardAddr,
 		forwardLevel:      forwardLevel,
 		disableLogging:    false,
 		disableForwarding: false,
-		messages:          []string{},
-		config:            &Config{MaxMessages: 1000, DisableLog: false, AnomaliesOnly: false, Severity: 7, AppName: "", MessagePattern: ""},
+		messages:          []storedMessage{},
+		now:               time.Now,
+		maxBodySize:       defaultMaxBodySize,
+		config:            &Config{MaxMessages: 1000, DisableLog: false, AnomaliesOnly: false, Severity: 7, AppName: "", MessagePattern: "", MaxRetries: 3},
 	}
 	if filename == "" {
 		handler.disableLogging = true
 	} else {
+		if err := checkWritable(filename); err != nil {
+			return nil, err
+		}
 		handler.logger = &lumberjack.Logger{
 			Filename:   filename,
 			MaxSize:    maxSize,
@@ -112,9 +304,11 @@ func createLogFileHandler(filename string, maxSize int, forwardAddr,
 		}
 	}
 
+	handler.addOutput(fileOutput{handler})
+
 	if forwardAddr != "" {
-		if err := handler.setupForwardConnection(); err != nil {
-			return nil, fmt.Errorf("failed to connect to upstream syslog server: %w", err)
+		if err := handler.connectForwarder(forwardProto, forwardAddr, forwardBatch{}); err != nil {
+			return nil, err
 		}
 	} else {
 		handler.disableForwarding = true
@@ -123,15 +317,30 @@ func createLogFileHandler(filename string, maxSize int, forwardAddr,
 	return handler, nil
 }
 
-func (lh *logFileHandler) setupForwardConnection() error {
-	conn, err := net.Dial(lh.forwardProto, lh.forwardAddr)
-	if err != nil {
-		return err
+// loadTemplates parses the HTML templates from dir, or from the copies
+// embedded in the binary when dir is empty.
+func loadTemplates(dir string) (*template.Template, error) {
+	if dir != "" {
+		return template.ParseGlob(filepath.Join(dir, "*.html"))
 	}
+	return template.ParseFS(embeddedFiles, "templates/*.html")
+}
 
-	lh.forwardConn = conn
-	log.Printf("Connected to upstream syslog server at %s via %s", lh.forwardAddr, lh.forwardProto)
-	return nil
+// errLogFileNotWritable is returned by createLogFileHandler when the log file
+// cannot be created or appended to.
+var errLogFileNotWritable = errors.New("log file is not writable")
+
+// checkWritable verifies that filename, and the directory lumberjack would
+// create for it, can be written to.
+func checkWritable(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("%w: %v", errLogFileNotWritable, err)
+	}
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errLogFileNotWritable, err)
+	}
+	return f.Close()
 }
 
 func parsePriority(buf string) (int, int, error) {
@@ -146,6 +355,10 @@ func parsePriority(buf string) (int, int, error) {
 	if err != nil {
 		return 0, 0, err
 	}
+	// 24 facilities of 8 severities each.
+	if priority < 0 || priority > 191 {
+		return 0, 0, fmt.Errorf("syslog priority %d out of range 0-191", priority)
+	}
 	facility := priority / 8
 	severity := priority % 8
 	return facility, severity, nil
@@ -156,72 +369,153 @@ func skipNumericPrefix(line string) string {
 	return re.ReplaceAllString(line, "")
 }
 
-func (lh *logFileHandler) logMessage(message string) {
+// logMessage handles a received message, dropping it if the source is not
+// allowed and reassembling multiline messages first when enabled.
+func (lh *logFileHandler) logMessage(message, remoteAddr string) {
+	if lh.sources != nil && !lh.sources.permits(remoteAddr) {
+		return
+	}
+	if lh.multiline != nil {
+		lh.multiline.add(message, remoteAddr)
+		return
+	}
+	lh.processMessage(message, remoteAddr)
+}
+
+// processMessage logs, stores and forwards a complete message.
+func (lh *logFileHandler) processMessage(message, remoteAddr string) {
+	message = redact(lh.getConfig().RedactRules, message)
+	raw := message
+	message = remapSeverity(lh.getConfig().SeverityRules, message)
+	lh.countMessage(message)
+	if lh.sampler != nil && !lh.sampler.keep(sourceIP(remoteAddr), time.Now()) {
+		return
+	}
+	stored := newStoredMessage(message, remoteAddr, lh.now(), lh.strictParse)
+	if stored.Malformed && lh.dropMalformed {
+		return
+	}
+	if lh.getConfig().HostFromSource && !stored.Malformed && (stored.Msg.Hostname == "" || stored.Msg.Hostname == "-") {
+		if ip := sourceIP(remoteAddr); ip != "" {
+			stored.Msg.Hostname = ip
+		}
+	}
+	if lh.geoIP != nil {
+		stored.Msg.Country, stored.Msg.City = lh.geoIP.lookup(remoteAddr)
+	}
+	if name, ok := lh.peerNames.Load(remoteAddr); ok {
+		stored.Msg.ClientCert = name.(string)
+	}
+	if lh.getConfig().IncludeRaw {
+		stored.Msg.Raw = raw
+	}
+	if lh.alerter != nil && !stored.Malformed && stored.Msg.Severity <= lh.getConfig().AlertSeverity {
+		lh.alerter.notify(stored.Msg, remoteAddr)
+	}
 	lh.mu.Lock()
 	defer lh.mu.Unlock()
-	_, severity, err := parsePriority(message)
+	lh.lastID++
+	stored.Msg.ID = lh.lastID
+	facility, severity, err := parsePriority(message)
+	// When logging to a file, messages of the configured severity or less
+	// severe are dropped altogether.
+	if lh.logFileFor(facility, err) != nil && severity >= lh.config.Severity {
+		return
+	}
 
-	if !lh.disableLogging {
-		if severity >= lh.config.Severity {
-			return
-		}
-		logEntry := skipNumericPrefix(message) + "\n"
-		if _, err := lh.logger.Write([]byte(logEntry)); err != nil {
-			log.Printf("Error writing to log file: %v", err)
-			return
+	if lh.forwardAddr != "" && !lh.disableForwarding || len(lh.forwardRoutes) > 0 {
+		if err != nil {
+			slog.Warn("Error parsing syslog message, not forwarding", "remote_addr", remoteAddr, "error", err)
+		} else if severity <= lh.forwardLevel {
+			stored.Msg.Forwarded = lh.forwardMessage(message)
 		}
 	}
 
-	// Store message for web interface
-	lh.messages = append(lh.messages, message)
-	if len(lh.messages) >= lh.config.MaxMessages && lh.config.MaxMessages > 0 {
-		lh.messages = lh.messages[len(lh.messages)-lh.config.MaxMessages:]
+	lh.writeOutputs(stored)
+	if !stored.Malformed {
+		lh.events.publish(stored.Msg)
 	}
 
-	if lh.forwardAddr != "" && !lh.disableForwarding {
-		if err != nil {
-			log.Printf("Error parsing syslog message: %v", err)
-			return
-		}
-		if lh.forwardLevel > severity {
-			return
+	// Store message for web interface
+	if keep, ok := lh.limitLength(stored); ok {
+		lh.messages = append(lh.messages, keep)
+		lh.bufferedByRank[evictionRank(keep)]++
+		if len(lh.messages) >= lh.config.MaxMessages && lh.config.MaxMessages > 0 {
+			if lh.evictBySeverity {
+				lh.dropLeastSevere(len(lh.messages) - lh.config.MaxMessages)
+			} else {
+				lh.dropOldest(len(lh.messages) - lh.config.MaxMessages)
+			}
 		}
-		lh.forwardMessage(message)
 	}
 }
 
-func (lh *logFileHandler) forwardMessage(message string) {
-	if lh.disableForwarding {
-		return
+// truncationMarker is appended to messages shortened by -maxmsglen.
+const truncationMarker = "..."
+
+// limitLength applies the -maxmsglen policy to a message about to be
+// buffered in memory. It returns false if the message should not be kept.
+// The log files and outputs always get the full message.
+func (lh *logFileHandler) limitLength(stored storedMessage) (storedMessage, bool) {
+	if lh.maxMsgLen <= 0 || len(stored.Raw) <= lh.maxMsgLen {
+		return stored, true
 	}
-	if lh.forwardConn == nil {
-		log.Printf("Forward connection is not available, reconnecting...")
-		if err := lh.setupForwardConnection(); err != nil {
-			log.Printf("Failed to reconnect to upstream syslog server: %v", err)
-			return
-		}
+	if lh.dropLong {
+		return stored, false
+	}
+	stored.Raw = strings.ToValidUTF8(stored.Raw[:lh.maxMsgLen], "") + truncationMarker
+	if len(stored.Msg.Message) > lh.maxMsgLen {
+		stored.Msg.Message = strings.ToValidUTF8(stored.Msg.Message[:lh.maxMsgLen], "") + truncationMarker
 	}
-	_, err := lh.forwardConn.Write([]byte(message + "\n"))
+	return stored, true
+}
+
+// countMessage updates the received counters. Messages without a valid
+// priority are counted as notice, matching parseSyslogMessage.
+func (lh *logFileHandler) countMessage(message string) {
+	lh.received.Add(1)
+	lh.receivedRate.add(lh.now())
+	facility, severity, err := parsePriority(message)
 	if err != nil {
-		log.Printf("Error forwarding message, reconnecting: %v", err)
-		lh.forwardConn.Close()
-		if err := lh.setupForwardConnection(); err != nil {
-			log.Printf("Failed to reconnect: %v", err)
-			return
-		}
-		if _, err := lh.forwardConn.Write([]byte(message + "\n")); err != nil {
-			log.Printf("Failed to forward message after reconnecting: %v", err)
-		}
+		facility, severity = 1, 5
+	}
+	lh.severityCounts[severity].Add(1)
+	lh.priorityCounts[facility][severity].Add(1)
+}
+
+// forwardMessage enqueues message on the forwarder of the first matching
+// app name route, falling back to the default upstream server. It reports
+// whether the message was queued for forwarding.
+func (lh *logFileHandler) forwardMessage(message string) bool {
+	if fw := lh.routeForwarder(message); fw != nil {
+		return fw.enqueue(message)
 	}
+	if lh.disableForwarding || lh.forwarder == nil {
+		return false
+	}
+	return lh.forwarder.enqueue(message)
 }
 
 func (lh *logFileHandler) updateConfig(config *Config) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
 	lh.muConfig.Lock()
 	defer lh.muConfig.Unlock()
 	lh.config = config
 	if len(lh.messages) >= lh.config.MaxMessages && lh.config.MaxMessages > 0 {
-		lh.messages = lh.messages[len(lh.messages)-lh.config.MaxMessages:]
+		lh.dropOldest(len(lh.messages) - lh.config.MaxMessages)
+	}
+}
+
+// dropOldest removes the n oldest buffered messages, remembering the last
+// removed ID for /messages/tail. The caller must hold lh.mu.
+func (lh *logFileHandler) dropOldest(n int) {
+	if n <= 0 {
+		return
 	}
+	lh.evictedID = lh.messages[n-1].Msg.ID
+	lh.unbuffer(lh.messages[:n])
+	lh.messages = lh.messages[n:]
 }
 
 func (lh *logFileHandler) getConfig() *Config {
@@ -235,85 +529,43 @@ func isRegexp(str string) bool {
 	return err == nil
 }
 
-func renderMessageRows(handler *logFileHandler) (template.HTML, error) {
-	handler.mu.Lock()
-	defer handler.mu.Unlock()
-
-	config := handler.getConfig()
-	var messages []syslogMsg
-
-	if config.AnomaliesOnly && len(handler.messages) > 0 {
-		if config.ApiKey == "" {
-			return template.HTML("<tr><td colspan='5'>OpenAI API key not found. Please set the OPENAI_API_KEY environment variable and rerun the server.</td></tr>"), nil
-		}
-		apiKey := config.ApiKey
-		url := config.Url
-		model := config.Model
-		if url == "" {
-			url = "https://api.openai.com/v1/chat/completions"
-		}
-		if model == "" {
-			model = "gpt-3.5-turbo"
-		}
-		anomalies, err := findAnomalies(LLMConfig{apiKey: apiKey, url: url, model: model}, handler.messages)
-		if err != nil {
-			return template.HTML("<tr><td colspan='5'>Error analyzing syslog messages: " + err.Error() + "</td></tr>"), nil
-		}
-		handler.anomalies = append(handler.anomalies, anomalies...)
-		handler.messages = []string{}
-	}
-
-	var messagesToRender []string
-	if config.AnomaliesOnly {
-		messagesToRender = handler.anomalies
-	} else {
-		messagesToRender = handler.messages
-	}
-	if len(messagesToRender) == 0 {
-		return template.HTML("<tr><td colspan='5'>No messages yet.</td></tr>"), nil
+// renderMessageRows renders the table rows of the buffered messages with the
+// message_rows.html template from tmpl, which is parsed once at startup.
+func renderMessageRows(ctx context.Context, handler *logFileHandler, tmpl *template.Template, order messageOrder) (template.HTML, error) {
+	messages, err := filteredMessages(ctx, handler, order, messagePage{})
+	if err != nil {
+		return template.HTML("<tr><td colspan='7'>" + template.HTMLEscapeString(err.Error()) + "</td></tr>"), nil
 	}
-	for _, msg := range messagesToRender {
-		syslogMsg, err := parseSyslogMessage(msg)
-		if err != nil {
-			log.Printf("Error parsing message: %v", err)
-			continue
-		}
-
-		// Apply filtering based on configuration
-		if config.AppName != "" && !strings.Contains(syslogMsg.Appname, config.AppName) {
-			continue
-		}
-		if config.HostName != "" && !strings.Contains(syslogMsg.Hostname, config.HostName) {
-			continue
+	if config := handler.getConfig(); config.DisplayTimezone != "" {
+		// The zones were validated when they were set.
+		display, _ := loadTimezone(config.DisplayTimezone)
+		source, _ := loadTimezone(config.SourceTimezone)
+		now := time.Now()
+		convert := func(msg *syslogMsg) {
+			msg.Timestamp = displayTimestamp(msg.Timestamp, source, display, now)
 		}
-		if config.MessagePattern != "" {
-			if isRegexp(config.MessagePattern) {
-				if config.MessagePattern != "" {
-					matched, err := regexp.MatchString(config.MessagePattern, syslogMsg.Message)
-					if err != nil {
-						log.Printf("Error matching regex: %v", err)
-						continue
-					}
-					if !matched {
-						continue
-					}
+		for i := range messages {
+			convert(&messages[i])
+			if ctx := messages[i].Context; ctx != nil {
+				// The context is shared with the anomaly cache.
+				converted := anomalyContext{
+					Before: slices.Clone(ctx.Before),
+					Match:  ctx.Match,
+					After:  slices.Clone(ctx.After),
 				}
-			} else {
-				if !strings.Contains(syslogMsg.Message, config.MessagePattern) {
-					continue
+				for j := range converted.Before {
+					convert(&converted.Before[j])
 				}
+				convert(&converted.Match)
+				for j := range converted.After {
+					convert(&converted.After[j])
+				}
+				messages[i].Context = &converted
 			}
 		}
-		
-		messages = append(messages, *syslogMsg)
-	}
-	tmpl, err := template.ParseFiles("templates/message_rows.html")
-	if err != nil {
-		return "", err
 	}
-
 	var tpl bytes.Buffer
-	err = tmpl.Execute(&tpl, struct {
+	err = tmpl.ExecuteTemplate(&tpl, "message_rows.html", struct {
 		Messages []syslogMsg
 	}{Messages: messages})
 	if err != nil {
@@ -322,104 +574,279 @@ func renderMessageRows(handler *logFileHandler) (template.HTML, error) {
 	return template.HTML(tpl.String()), nil
 }
 
-func findAnomalies(config LLMConfig, messages []string) ([]string, error) {
-	cleanedMessages := []string{}
-	for _, msg := range messages {
-		cleanedMessages = append(cleanedMessages, skipNumericPrefix(msg))
+// filteredMessages returns the buffered messages, or the anomalies found in
+// them when the config asks for anomalies only, that match the config
+// filters, sorted by order. With a database the messages are queried from
+// it instead, at most MaxMessages unless page sets a limit.
+func filteredMessages(ctx context.Context, handler *logFileHandler, order messageOrder, page messagePage) ([]syslogMsg, error) {
+	config := handler.getConfig()
+	if handler.store != nil && !config.AnomaliesOnly && !config.ShowMalformedOnly {
+		if page.limit == 0 {
+			page.limit = config.MaxMessages
+		}
+		return handler.store.query(config, order, page)
 	}
-	requestBody := CompletionRequest{
-		Model: config.model,
-		Messages: []Message{
-			{
-				Role: "user",
-				Content: `Given a list of syslog messages, respond only with lines of text 
-					that start with ANOMALIES: and followed by lines of anomalous syslog messages. 
-					Syslog messages:\n` + strings.Join(cleanedMessages, "\n "),
-			},
-		},
-	}
-
-	apiKey := config.apiKey
-	url := config.url
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+
+	// With background scanning the cached anomalies are shown as they are.
+	if config.AnomaliesOnly && handler.scanner == nil {
+		if err := handler.analyzeBuffered(ctx, config); err != nil {
+			return nil, err
+		}
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+
+	messages := []syslogMsg{}
+
+	if config.AnomaliesOnly {
+		for _, anomaly := range syslog_anomaly.FilterByConfidence(handler.anomalies, config.MinConfidence) {
+			msg, err := parseSyslogMessage(anomaly.Message)
+			if err != nil {
+				// The model may not echo the message in syslog format.
+				msg = &syslogMsg{Message: cleanString(anomaly.Message)}
+			}
+			msg.AnomalyReason = cleanString(anomaly.Reason)
+			msg.AnomalySeverity = strings.ToLower(anomaly.Severity)
+			msg.Context = handler.anomalyContexts[anomaly.Message]
+			if config.matches(msg) {
+				messages = append(messages, *msg)
+			}
+		}
+	} else if config.ShowMalformedOnly {
+		for i := range handler.messages {
+			stored := &handler.messages[i]
+			if !stored.Malformed {
+				continue
+			}
+			if msg := stored.malformedMessage(); config.matches(&msg) {
+				messages = append(messages, msg)
+			}
+		}
+	} else {
+		for i := range handler.messages {
+			if msg, ok := handler.listedMessage(&handler.messages[i]); ok && config.matches(&msg) {
+				messages = append(messages, msg)
+			}
+		}
+	}
+	order.sort(messages)
+	return page.apply(messages), nil
+}
+
+// analyzeBuffered asks the LLM for anomalies in the recent buffered
+// messages and then drops the ones it analyzed. The handler lock is not held
+// during the LLM call, so ingest and the other handlers are not blocked while
+// it waits or retries; older messages and those received meanwhile are kept.
+func (lh *logFileHandler) analyzeBuffered(ctx context.Context, config *Config) error {
+	lh.analyzeMu.Lock()
+	defer lh.analyzeMu.Unlock()
+
+	lh.mu.Lock()
+	analyzed := recentMessages(lh.messages, config.AnomalyRecent, config.AnomalyWindow, time.Now())
+	if len(analyzed) == 0 {
+		lh.mu.Unlock()
+		return nil
 	}
-	if apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+apiKey)
+	firstID, lastID := analyzed[0].Msg.ID, analyzed[len(analyzed)-1].Msg.ID
+	recent := rawMessages(analyzed)
+	lh.mu.Unlock()
+
+	if config.ApiKey == "" {
+		return errors.New("OpenAI API key not found. Please set the OPENAI_API_KEY environment variable and rerun the server.")
 	}
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	anomalies, err := findAnomalies(ctx, config.llmConfig(), recent)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return fmt.Errorf("Error analyzing syslog messages: %w", err)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	lh.recordAnomalies(anomalies)
+	lh.dropRange(firstID, lastID)
+	return nil
+}
+
+// dropRange drops the buffered messages with IDs from first to last; some
+// may already have been evicted.
+func (lh *logFileHandler) dropRange(first, last uint64) {
+	start := sort.Search(len(lh.messages), func(i int) bool {
+		return lh.messages[i].Msg.ID >= first
+	})
+	end := sort.Search(len(lh.messages), func(i int) bool {
+		return lh.messages[i].Msg.ID > last
+	})
+	if start < end {
+		lh.evictedID = max(lh.evictedID, lh.messages[end-1].Msg.ID)
+		lh.unbuffer(lh.messages[start:end])
+		lh.messages = slices.Delete(lh.messages, start, end)
+	}
+}
+
+// filterValues splits a comma separated app name or host name filter into
+// its trimmed, non-empty values.
+func filterValues(filter string) []string {
+	var values []string
+	for _, value := range strings.Split(filter, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// containsAny reports whether s contains any of values. No values match
+// everything.
+func containsAny(s string, values []string) bool {
+	for _, value := range values {
+		if strings.Contains(s, value) {
+			return true
+		}
+	}
+	return len(values) == 0
+}
+
+// listedMessage returns how a buffered message is listed with the other
+// messages. Malformed messages are only listed, raw, with -malformedpolicy
+// show; otherwise they are only shown by the unparsed messages filter.
+func (lh *logFileHandler) listedMessage(stored *storedMessage) (syslogMsg, bool) {
+	if !stored.Malformed {
+		return stored.Msg, true
 	}
-	var completionResponse CompletionResponse
-	if err := json.Unmarshal(body, &completionResponse); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	return stored.malformedMessage(), lh.showMalformed
+}
+
+// matches applies the app name, host name and message pattern filters of
+// the config to msg.
+func (config *Config) matches(msg *syslogMsg) bool {
+	if !containsAny(msg.Appname, filterValues(config.AppName)) {
+		return false
 	}
-	anomalyReport := "ANOMALIES:"
-	anomalies := []string{}
-	for _, choice := range completionResponse.Choices {
-		idx := strings.Index(choice.Message.Content, anomalyReport)
-		if idx == 0 {
-			anomalies = strings.Split(choice.Message.Content[len(anomalyReport):], "\n")
-			anomalies = removeEmptyStrings(anomalies)
-			break
+	if !containsAny(msg.Hostname, filterValues(config.HostName)) {
+		return false
+	}
+	if config.MessagePattern != "" {
+		if isRegexp(config.MessagePattern) {
+			matched, err := regexp.MatchString(config.MessagePattern, msg.Message)
+			if err != nil {
+				slog.Error("Error matching regex", "pattern", config.MessagePattern, "error", err)
+				return false
+			}
+			return matched
 		}
+		return strings.Contains(msg.Message, config.MessagePattern)
 	}
+	return true
+}
+
+// llmConfig builds the LLM settings from the config, applying the defaults
+// of its provider. An unknown provider is reported by the LLM request.
+func (config *Config) llmConfig() syslog_anomaly.LLMConfig {
+	llmConfig, _ := syslog_anomaly.LLMConfig{
+		Provider:       config.Provider,
+		APIKey:         config.ApiKey,
+		URL:            config.Url,
+		Model:          config.Model,
+		PromptTemplate: config.Prompt,
+		Temperature:    config.Temperature,
+		MaxTokens:      config.MaxTokens,
+		MaxRetries:     config.MaxRetries,
+	}.WithDefaults()
+	return llmConfig
+}
 
-	return anomalies, nil
+// findAnomalies strips the syslog priority from the messages before asking
+// the LLM to analyze them.
+func findAnomalies(ctx context.Context, config syslog_anomaly.LLMConfig, messages []string) ([]syslog_anomaly.Anomaly, error) {
+	cleanedMessages := []string{}
+	for _, msg := range messages {
+		cleanedMessages = append(cleanedMessages, skipNumericPrefix(msg))
+	}
+	return syslog_anomaly.FindAnomaliesContext(ctx, config, cleanedMessages)
 }
+
 func cleanString(s string) string {
 	s = strings.ReplaceAll(s, "<script>", "<XXX>")
 	s = strings.ReplaceAll(s, "</script>", "</XXX>")
 	return strings.TrimSpace(s)
 }
 
-func removeEmptyStrings(s []string) []string {
-	var result []string
-	for _, str := range s {
-		if str != "" {
-			result = append(result, str)
-		}
+// severityNames are the RFC 5424 keywords for severities 0 through 7.
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// SeverityClass returns the CSS class used to color a message row.
+func (m syslogMsg) SeverityClass() string {
+	if m.Severity < 0 || m.Severity >= len(severityNames) {
+		return ""
 	}
-	return result
+	return "sev-" + severityNames[m.Severity]
 }
+
 func parseSyslogMessage(msg string) (*syslogMsg, error) {
+	// Messages without a priority are treated as user.notice (RFC 3164 4.3.3).
+	facility, severity, err := parsePriority(msg)
+	if err != nil {
+		facility, severity = 1, 5
+	}
 	msg = skipNumericPrefix(msg)
+	if strings.HasPrefix(msg, "1 ") {
+		if parsed, err := parseRFC5424(msg, facility, severity); err == nil {
+			return parsed, nil
+		}
+	}
 	parts := strings.SplitN(msg, " ", 6)
 	if len(parts) < 6 {
 		return nil, fmt.Errorf("not enough parts in syslog message")
 	}
 	date := parts[0] + " " + parts[1] + " " + parts[2]
 	host := parts[3]
-	app := parts[4]
-	app = strings.TrimSuffix(app, ":")
+	app, pid := splitTag(parts[4])
 	message := parts[5]
 
 	date = cleanString(date)
 	host = cleanString(host)
 	app = cleanString(app)
-	message = cleanString(message)
+	pid = cleanString(pid)
+	message = messageBody(message)
 
-	log.Printf("Parsed syslog message: date %s host %s app %s message %s", date, host, app, message)
+	slog.Debug("Parsed syslog message", "date", date, "host", host, "app", app, "message", message)
 	return &syslogMsg{
 		Timestamp: date,
 		Hostname:  host,
 		Appname:   app,
+		Pid:       pid,
 		Message:   message,
+		Facility:  facility,
+		Severity:  severity,
+	}, nil
+}
+
+// splitTag splits an RFC 3164 tag such as "sshd[1234]:" into the app name
+// and the process ID, which is empty for tags like "sshd:" or "sshd".
+func splitTag(tag string) (app, pid string) {
+	tag = strings.TrimSuffix(tag, ":")
+	if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+		return tag[:open], tag[open+1 : len(tag)-1]
+	}
+	return tag, ""
+}
+
+// parseMinimalMessage parses a message that has a priority but not the
+// date, host and app fields of syslog format. The rest of the message is
+// the body and the other fields are left empty.
+func parseMinimalMessage(msg string) (*syslogMsg, error) {
+	facility, severity, err := parsePriority(msg)
+	if err != nil {
+		return nil, err
+	}
+	message := messageBody(skipNumericPrefix(msg))
+	if message == "" {
+		return nil, fmt.Errorf("empty syslog message")
+	}
+	return &syslogMsg{
+		Message:  message,
+		Facility: facility,
+		Severity: severity,
 	}, nil
 }
 
@@ -427,11 +854,34 @@ type MessageRequest struct {
 	Messages []string `json:"messages"`
 }
 
-func messagesHandler(handler *logFileHandler) http.HandlerFunc {
+// defaultMaxBodySize limits the JSON body of a POST to /messages unless
+// -maxbody is set.
+const defaultMaxBodySize = 10 << 20
+
+func messagesHandler(handler *logFileHandler, tmpl *template.Template) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
+			order, err := parseMessageOrder(r.URL.Query().Get("sort"), r.URL.Query().Get("order"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if wantsJSON(r) {
+				page, err := parseMessagePage(r.URL.Query().Get("limit"), r.URL.Query().Get("offset"))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				messages, err := filteredMessages(r.Context(), handler, order, page)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				writeJSON(w, r, messages)
+				return
+			}
 			w.Header().Set("Content-Type", "text/html")
-			rows, err := renderMessageRows(handler)
+			rows, err := renderMessageRows(r.Context(), handler, tmpl, order)
 			if err != nil {
 				http.Error(w, "Error rendering message rows", http.StatusInternalServerError)
 				return
@@ -439,7 +889,12 @@ func messagesHandler(handler *logFileHandler) http.HandlerFunc {
 			fmt.Fprint(w, rows)
 		} else if r.Method == http.MethodPost {
 			var reqBody MessageRequest
-			err := json.NewDecoder(r.Body).Decode(&reqBody)
+			err := json.NewDecoder(http.MaxBytesReader(w, r.Body, handler.maxBodySize)).Decode(&reqBody)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("Request body larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
 			if err != nil {
 				http.Error(w, "Invalid request body", http.StatusBadRequest)
 				return
@@ -447,7 +902,7 @@ func messagesHandler(handler *logFileHandler) http.HandlerFunc {
 			defer r.Body.Close()
 
 			for _, msg := range reqBody.Messages {
-				handler.logMessage(msg)
+				handler.logMessage(msg, r.RemoteAddr)
 			}
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Syslog messages received"})
@@ -458,131 +913,1163 @@ func messagesHandler(handler *logFileHandler) http.HandlerFunc {
 	}
 }
 
-func configHandler(handler *logFileHandler) http.HandlerFunc {
+// messageByIDHandler returns the buffered message with the ID in the path
+// as JSON.
+func messageByIDHandler(handler *logFileHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			w.WriteHeader(http.StatusOK)
-
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		if r.Method != http.MethodPost {
-			http.Error(w, "Only GET or POST method is allowed", http.StatusMethodNotAllowed)
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid message id %q", r.PathValue("id")), http.StatusBadRequest)
 			return
 		}
-		err := r.ParseForm()
-		if err != nil {
-			http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		handler.mu.Lock()
+		stored, ok := handler.messageByID(id)
+		handler.mu.Unlock()
+		if !ok && handler.store != nil {
+			// Messages no longer buffered may still be in the database.
+			msg, found, err := handler.store.get(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if found {
+				writeJSON(w, r, msg)
+				return
+			}
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("Message %d not found", id), http.StatusNotFound)
 			return
 		}
-		severity, _ := strconv.Atoi(r.FormValue("severity"))
-		anomaliesOnly := r.FormValue("anomaliesOnly") == "on" // Parse anomaliesOnly checkbox
-		maxMessages, _ := strconv.Atoi(r.FormValue("maxMessages"))
-		defer r.Body.Close()
-		config := handler.getConfig()
-		config.AnomaliesOnly = anomaliesOnly
-		config.MaxMessages = maxMessages
-		config.AppName = r.FormValue("appname")
-		config.HostName = r.FormValue("hostname")
-		config.MessagePattern = r.FormValue("messagepattern")
-		config.Severity = severity
-		handler.updateConfig(config)
-		w.WriteHeader(http.StatusOK)
+		msg := stored.Msg
+		if stored.Malformed {
+			// Keep the raw text of messages that are not in syslog format.
+			msg.Message = cleanString(stored.Raw)
+		}
+		writeJSON(w, r, msg)
 	}
 }
 
-func renderPage(w http.ResponseWriter, page string, tmpl *template.Template,
-	handler *logFileHandler) {
-	w.Header().Set("Content-Type", "text/html")
-	config := handler.getConfig()
-
-	err := tmpl.ExecuteTemplate(w, page+".html", config)
-	if err != nil {
-		fmt.Printf("render template error %s %v\n", page, err)
-		http.Error(w, "render template error", http.StatusInternalServerError)
-	}
+// tailResponse is returned by /messages/tail. Cursor is passed as since on
+// the next poll. Reset is set when messages after the cursor were already
+// dropped from the buffer, or when the cursor is ahead of the buffer, for
+// example after a restart, in which case the whole buffer is returned.
+type tailResponse struct {
+	Messages []syslogMsg `json:"messages"`
+	Cursor   uint64      `json:"cursor"`
+	Reset    bool        `json:"reset,omitempty"`
 }
 
-func main() {
-	address := flag.String("a", ":514", "Syslog server address")
-	logFile := flag.String("f", "", "Log file path")
-	maxSize := flag.Int("m", 10, "Max log file size in MB")
-	forwardAddr := flag.String("r", "", "Upstream syslog server address")
-	forwardProto := flag.String("p", "udp", "Forwarding protocol: 'tcp' or 'udp'")
-	forwardLevel := flag.Int("l", 6, "Forwarding priority level")
-	apiAddr := flag.String("w", ":3001", "REST API and Web UI address")
-	debuglog := flag.String("d", "/dev/null", "debug log file")
-	flag.Parse()
+// tailHandler returns the buffered messages with an ID greater than the
+// since query parameter that match the config filters, so clients can poll
+// for new messages without fetching the whole buffer.
+func tailHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var since uint64
+		if s := r.URL.Query().Get("since"); s != "" {
+			var err error
+			if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid cursor %q", s), http.StatusBadRequest)
+				return
+			}
+		}
+		config := handler.getConfig()
+		resp := tailResponse{Messages: []syslogMsg{}}
 
-	if *debuglog != "" {
-		f, err := os.OpenFile(*debuglog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Fatalf("Error opening debug log file: %v", err)
+		handler.mu.Lock()
+		resp.Cursor = handler.lastID
+		if since > handler.lastID {
+			since, resp.Reset = 0, true
 		}
-		log.SetOutput(f)
-		log.SetFlags(log.LstdFlags | log.Lshortfile)
-	} else if *debuglog == "/dev/null" {
-		log.SetOutput(io.Discard)
-		log.SetFlags(0)
-	}
+		if r.URL.Query().Has("since") && since < handler.evictedID {
+			resp.Reset = true
+		}
+		buffered := handler.messages
+		i := sort.Search(len(buffered), func(i int) bool {
+			return buffered[i].Msg.ID > since
+		})
+		for _, stored := range buffered[i:] {
+			if msg, ok := handler.listedMessage(&stored); ok && config.matches(&msg) {
+				resp.Messages = append(resp.Messages, msg)
+			}
+		}
+		handler.mu.Unlock()
 
-	logHandler, err := createLogFileHandler(*logFile, *maxSize, *forwardAddr, *forwardProto,
-		*forwardLevel)
-	if err != nil {
-		log.Fatalf("Failed to create log handler: %v", err)
+		writeJSON(w, r, resp)
 	}
-	logHandler.config.ApiKey = os.Getenv("OPENAI_API_KEY")
-	logHandler.config.Url = os.Getenv("OPENAI_API_URL")
-	logHandler.config.Model = os.Getenv("OPENAI_MODEL")
-	logHandler.config.LogFile = *logFile
-	http.HandleFunc("/static/search.js", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/javascript")
-		http.ServeFile(w, r, "static/search.js")
+}
+
+// messageByID finds a buffered message by ID. IDs increase in buffer order.
+// The caller must hold lh.mu.
+func (lh *logFileHandler) messageByID(id uint64) (storedMessage, bool) {
+	i := sort.Search(len(lh.messages), func(i int) bool {
+		return lh.messages[i].Msg.ID >= id
 	})
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(embeddedFiles))))
-	tmpl, err := template.ParseFS(embeddedFiles, "templates/*.html")
-	if err != nil {
-		log.Fatalf("Failed to parse template: %v", err)
+	if i == len(lh.messages) || lh.messages[i].Msg.ID != id {
+		return storedMessage{}, false
 	}
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		renderPage(w, "logs", tmpl, logHandler)
-	})
-	http.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
-		renderPage(w, "logs", tmpl, logHandler)
-	})
-	http.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
-		renderPage(w, "settings", tmpl, logHandler)
-	})
-	http.HandleFunc("/messages", messagesHandler(logHandler))
-	http.HandleFunc("/config", configHandler(logHandler))
+	return lh.messages[i], true
+}
 
-	go func() {
-		fmt.Printf("Web UI and REST API listening on %s\n", *apiAddr)
-		if err := http.ListenAndServe(*apiAddr, nil); err != nil {
-			log.Fatalf("Failed to start Web UI and REST API: %v", err)
+// messagePage selects part of the /messages result with the limit and
+// offset query parameters. A zero limit does not limit the result.
+type messagePage struct {
+	limit  int
+	offset int
+}
+
+func parseMessagePage(limit, offset string) (messagePage, error) {
+	var page messagePage
+	var err error
+	if limit != "" {
+		if page.limit, err = strconv.Atoi(limit); err != nil || page.limit < 0 {
+			return page, fmt.Errorf("invalid limit %q: must be a number >= 0", limit)
+		}
+	}
+	if offset != "" {
+		if page.offset, err = strconv.Atoi(offset); err != nil || page.offset < 0 {
+			return page, fmt.Errorf("invalid offset %q: must be a number >= 0", offset)
+		}
+	}
+	return page, nil
+}
+
+// apply returns the page of messages.
+func (p messagePage) apply(messages []syslogMsg) []syslogMsg {
+	messages = messages[min(p.offset, len(messages)):]
+	if p.limit > 0 && len(messages) > p.limit {
+		messages = messages[:p.limit]
+	}
+	return messages
+}
+
+// wantsJSON reports whether a GET /messages request asks for JSON, with
+// format=json or an Accept header, instead of the HTML table rows.
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// gzipMinSize is the smallest JSON response that is worth compressing.
+const gzipMinSize = 1024
+
+// writeJSON writes v as JSON, gzipped if the client accepts it and the body
+// is large enough to benefit.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(body) < gzipMinSize || !acceptsGzip(r) {
+		w.Write(body)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	gz.Write(body)
+	if err := gz.Close(); err != nil {
+		slog.Error("Error writing gzipped response", "error", err)
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+func configHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			// Copy the config so the API key can be left out.
+			config := *handler.getConfig()
+			config.ApiKey = ""
+			config.WebhookURL = ""
+			config.AlertURL = ""
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(config)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only GET or POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if name := r.FormValue("applyPreset"); name != "" {
+			config := *handler.getConfig()
+			preset, ok := config.Presets[name]
+			if !ok {
+				http.Error(w, fmt.Sprintf("Unknown preset %q", name), http.StatusNotFound)
+				return
+			}
+			config.MessagePattern = preset.MessagePattern
+			config.Severity = preset.Severity
+			config.AppName = preset.AppName
+			config.HostName = preset.HostName
+			handler.updateConfig(&config)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		severity, err := strconv.Atoi(r.FormValue("severity"))
+		if err != nil || severity < 0 || severity > 7 {
+			http.Error(w, fmt.Sprintf("Invalid severity %q: must be between 0 and 7", r.FormValue("severity")), http.StatusBadRequest)
+			return
+		}
+		maxMessages, err := strconv.Atoi(r.FormValue("maxMessages"))
+		if err != nil || maxMessages < 0 {
+			http.Error(w, fmt.Sprintf("Invalid maxMessages %q: must be a number >= 0", r.FormValue("maxMessages")), http.StatusBadRequest)
+			return
+		}
+		messagePattern := r.FormValue("messagepattern")
+		if looksLikeRegexp(messagePattern) {
+			if _, err := regexp.Compile(messagePattern); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid messagepattern: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		anomaliesOnly := r.FormValue("anomaliesOnly") == "on" // Parse anomaliesOnly checkbox
+		hostFromSource := r.FormValue("hostFromSource") == "on"
+		showMalformedOnly := r.FormValue("showMalformedOnly") == "on"
+		for _, name := range []string{"displayTimezone", "sourceTimezone"} {
+			if _, err := loadTimezone(r.FormValue(name)); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s: %v", name, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		config := *handler.getConfig()
+		config.AnomaliesOnly = anomaliesOnly
+		config.HostFromSource = hostFromSource
+		config.ShowMalformedOnly = showMalformedOnly
+		config.DisplayTimezone = r.FormValue("displayTimezone")
+		config.SourceTimezone = r.FormValue("sourceTimezone")
+		config.MaxMessages = maxMessages
+		config.AppName = r.FormValue("appname")
+		config.HostName = r.FormValue("hostname")
+		config.MessagePattern = messagePattern
+		config.Severity = severity
+		if name := strings.TrimSpace(r.FormValue("savePreset")); name != "" {
+			// Clone the presets so readers of the old config are unaffected.
+			config.Presets = maps.Clone(config.Presets)
+			if config.Presets == nil {
+				config.Presets = map[string]FilterPreset{}
+			}
+			config.Presets[name] = FilterPreset{
+				MessagePattern: messagePattern,
+				Severity:       severity,
+				AppName:        config.AppName,
+				HostName:       config.HostName,
+			}
+			// Reload the settings page so the preset list includes it.
+			w.Header().Set("HX-Refresh", "true")
+		}
+		handler.updateConfig(&config)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// llmTestMessage is the message sent by /config/test-llm.
+const llmTestMessage = "<14>Jan 1 00:00:00 localhost syslog_server: LLM connection test"
+
+// llmTestResult is the response of /config/test-llm.
+type llmTestResult struct {
+	OK       bool   `json:"ok"`
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+	Model    string `json:"model"`
+	// Status is the HTTP status the LLM API answered a failed request with.
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// testLLMHandler sends a trivial anomaly request with the configured LLM
+// settings, so they can be checked before turning on AnomaliesOnly. It
+// responds with 502 and the provider's error message if the request fails.
+// The API key is masked wherever the provider echoes it.
+func testLLMHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		llmConfig := handler.getConfig().llmConfig()
+		// Report a failure now rather than after the backoff of retries.
+		llmConfig.MaxRetries = 0
+		result := llmTestResult{
+			OK:       true,
+			Provider: llmConfig.Provider,
+			URL:      maskAPIKey(llmConfig.URL, llmConfig.APIKey),
+			Model:    llmConfig.Model,
+		}
+		if _, err := findAnomalies(r.Context(), llmConfig, []string{llmTestMessage}); err != nil {
+			result.OK = false
+			result.Error = maskAPIKey(err.Error(), llmConfig.APIKey)
+			var statusErr *syslog_anomaly.StatusError
+			if errors.As(err, &statusErr) {
+				result.Status = statusErr.StatusCode
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !result.OK {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// maskAPIKey replaces key in s with its last 4 characters, for error
+// messages that may quote it.
+func maskAPIKey(s, key string) string {
+	if key == "" {
+		return s
+	}
+	masked := "***"
+	if len(key) > 8 {
+		masked += key[len(key)-4:]
+	}
+	return strings.ReplaceAll(s, key, masked)
+}
+
+// looksLikeRegexp reports whether the pattern uses regexp metacharacters
+// and is meant as a regular expression rather than a plain substring.
+func looksLikeRegexp(pattern string) bool {
+	return regexp.QuoteMeta(pattern) != pattern
+}
+
+// BuildInfo identifies the running binary. The main package sets Build from
+// values injected with -ldflags.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Build is reported by /version.
+var Build = BuildInfo{Version: "dev"}
+
+func versionHandler(info BuildInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
+
+// maxEchoSize limits the body /echo returns.
+const maxEchoSize = 1 << 20
+
+// echoHandler returns the body of a POST request, so clients and tests can
+// check that the API is up.
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEchoSize))
+	if err != nil {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Write(body)
+}
+
+func statsHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		stats := map[string]any{}
+		if handler.sampler != nil {
+			stats["sampledOut"] = handler.sampler.sampledOut.Load()
+		}
+		if handler.sources != nil {
+			stats["denied"] = handler.sources.denied.Load()
+		}
+		if handler.workerPool != nil {
+			stats["workerDropped"] = handler.workerPool.dropped.Load()
+			stats["queueDepth"] = handler.workerPool.depth()
+			stats["queueHighWater"] = handler.workerPool.highWater.Load()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// ingestHandler accepts newline-delimited syslog messages as sent by log
+// agents such as Vector or Fluent Bit. A text/plain body holds one raw
+// message per line; with application/x-ndjson each line is a JSON object
+// whose "message" (or "log") field is the raw message. Bodies may be gzip
+// encoded.
+func ingestHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		defer r.Body.Close()
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+		ndjson := strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-ndjson")
+
+		received := 0
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), defaultMaxMessageSize)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if ndjson {
+				var record struct {
+					Message string `json:"message"`
+					Log     string `json:"log"`
+				}
+				if err := json.Unmarshal([]byte(line), &record); err != nil {
+					http.Error(w, fmt.Sprintf("Invalid JSON on line %d: %v", received+1, err), http.StatusBadRequest)
+					return
+				}
+				line = record.Message
+				if line == "" {
+					line = record.Log
+				}
+				if line = strings.TrimSpace(line); line == "" {
+					continue
+				}
+			}
+			handler.logMessage(line, r.RemoteAddr)
+			received++
+		}
+		if err := scanner.Err(); err != nil {
+			http.Error(w, fmt.Sprintf("Error reading body: %v", err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"received": received})
+	}
+}
+
+// searchHandler returns the buffered messages whose raw text contains q,
+// ignoring case, as JSON. With regex=true, q is a regular expression.
+func searchHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query().Get("q")
+		match := func(raw string) bool {
+			return strings.Contains(strings.ToLower(raw), strings.ToLower(q))
+		}
+		if r.URL.Query().Get("regex") == "true" {
+			re, err := regexp.Compile("(?i)" + q)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid regular expression: %v", err), http.StatusBadRequest)
+				return
+			}
+			match = re.MatchString
+		}
+
+		handler.mu.Lock()
+		buffered := append([]storedMessage(nil), handler.messages...)
+		handler.mu.Unlock()
+
+		results := []syslogMsg{}
+		for _, stored := range buffered {
+			if !match(stored.Raw) {
+				continue
+			}
+			if stored.Malformed {
+				continue
+			}
+			results = append(results, stored.Msg)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// drainHandler waits until the forward queues are empty, for example before
+// shutting down for maintenance, and returns how many messages were drained.
+// If the timeout (default 30s) passes first, it responds with 504 and how
+// many messages remain.
+func drainHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if (handler.disableForwarding || handler.forwarder == nil) && len(handler.forwardRoutes) == 0 {
+			http.Error(w, "Forwarding is disabled", http.StatusConflict)
+			return
+		}
+		timeout := 30 * time.Second
+		if value := r.URL.Query().Get("timeout"); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				http.Error(w, "Invalid timeout", http.StatusBadRequest)
+				return
+			}
+			timeout = d
+		}
+		drained, remaining := handler.drainForwarders(timeout)
+		w.Header().Set("Content-Type", "application/json")
+		if remaining > 0 {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+		json.NewEncoder(w).Encode(map[string]int{"drained": drained, "remaining": remaining})
+	}
+}
+
+// replayHandler re-forwards the buffered messages, for example after an
+// upstream outage. With filter=true only messages matching the config
+// filters are replayed.
+func replayHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if (handler.disableForwarding || handler.forwarder == nil) && len(handler.forwardRoutes) == 0 {
+			http.Error(w, "Forwarding is disabled", http.StatusConflict)
+			return
+		}
+		filter := r.URL.Query().Get("filter") == "true"
+		config := handler.getConfig()
+
+		handler.mu.Lock()
+		buffered := append([]storedMessage(nil), handler.messages...)
+		handler.mu.Unlock()
+
+		replayed := 0
+		for _, stored := range buffered {
+			if filter {
+				if stored.Malformed || !config.matches(&stored.Msg) {
+					continue
+				}
+			}
+			if handler.forwardMessage(stored.Raw) {
+				replayed++
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"replayed": replayed})
+	}
+}
+
+// countersHandler reports the number of messages received since startup,
+// independent of the capped in-memory message list.
+func countersHandler(handler *logFileHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		bySeverity := map[string]uint64{}
+		for i, name := range severityNames {
+			bySeverity[name] = handler.severityCounts[i].Load()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"total":    handler.received.Load(),
+			"severity": bySeverity,
+		})
+	}
+}
+
+// levelCount is a row of the severity and facility legends on the settings
+// page.
+type levelCount struct {
+	Level int
+	Name  string
+	Count int
+}
+
+// pageData is rendered by the page templates: the config and the number of
+// buffered messages at each severity and facility.
+type pageData struct {
+	*Config
+	SeverityCounts []levelCount
+	FacilityCounts []levelCount
+	// Buffered is the number of messages in memory and MessageRate the
+	// messages received per second over the last ThroughputWindow.
+	Buffered         int
+	MessageRate      float64
+	ThroughputWindow time.Duration
+	Filters          []string
+}
+
+// filterSummary describes the active message filters of config.
+func filterSummary(config *Config) []string {
+	var filters []string
+	if config.AnomaliesOnly {
+		filters = append(filters, "anomalies only")
+	}
+	if config.ShowMalformedOnly {
+		filters = append(filters, "unparsed only")
+	}
+	if config.HostName != "" {
+		filters = append(filters, fmt.Sprintf("host %q", config.HostName))
+	}
+	if config.AppName != "" {
+		filters = append(filters, fmt.Sprintf("app %q", config.AppName))
+	}
+	if config.MessagePattern != "" {
+		filters = append(filters, fmt.Sprintf("message %q", config.MessagePattern))
+	}
+	return filters
+}
+
+// levelCounts counts the buffered messages by severity, listing all
+// severities, and by facility, listing the facilities that have messages.
+func (lh *logFileHandler) levelCounts() (severities, facilities []levelCount) {
+	var bySeverity [8]int
+	byFacility := map[int]int{}
+	lh.mu.Lock()
+	for i := range lh.messages {
+		msg := &lh.messages[i].Msg
+		if lh.messages[i].Malformed || msg.Severity < 0 || msg.Severity >= len(bySeverity) {
+			continue
+		}
+		bySeverity[msg.Severity]++
+		byFacility[msg.Facility]++
+	}
+	lh.mu.Unlock()
+	for severity, name := range severityNames {
+		severities = append(severities, levelCount{Level: severity, Name: name, Count: bySeverity[severity]})
+	}
+	for _, facility := range slices.Sorted(maps.Keys(byFacility)) {
+		facilities = append(facilities, levelCount{Level: facility, Name: syslog_client.FacilityName(facility), Count: byFacility[facility]})
+	}
+	return severities, facilities
+}
+
+func renderPage(w http.ResponseWriter, page string, tmpl *template.Template,
+	handler *logFileHandler) {
+	w.Header().Set("Content-Type", "text/html")
+	config := handler.getConfig()
+	data := pageData{
+		Config:           config,
+		MessageRate:      handler.receivedRate.rate(handler.now(), throughputWindow),
+		ThroughputWindow: throughputWindow,
+		Filters:          filterSummary(config),
+	}
+	data.SeverityCounts, data.FacilityCounts = handler.levelCounts()
+	handler.mu.Lock()
+	data.Buffered = len(handler.messages)
+	handler.mu.Unlock()
+
+	err := tmpl.ExecuteTemplate(w, page+".html", data)
+	if err != nil {
+		slog.Error("Error rendering template", "page", page, "error", err)
+		http.Error(w, "render template error", http.StatusInternalServerError)
+	}
+}
+
+// apiHandlers returns the REST API handlers by route pattern. Each route is
+// described in the OpenAPI document served at /openapi.json.
+func apiHandlers(logHandler *logFileHandler, tmpl *template.Template) map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/messages":        messagesHandler(logHandler, tmpl),
+		"/messages/{id}":   messageByIDHandler(logHandler),
+		"/messages/tail":   tailHandler(logHandler),
+		"/events":          eventsHandler(logHandler),
+		"/config":          configHandler(logHandler),
+		"/config/test-llm": testLLMHandler(logHandler),
+		"/stats":           statsHandler(logHandler),
+		"/counters":        countersHandler(logHandler),
+		"/metrics":         metricsHandler(logHandler),
+		"/search":          searchHandler(logHandler),
+		"/ingest":          ingestHandler(logHandler),
+		"/replay":          replayHandler(logHandler),
+		"/drain":           drainHandler(logHandler),
+		"/version":         versionHandler(Build),
+		"/echo":            echoHandler,
+		"/openapi.json":    openAPIHandler(Build),
+	}
+}
+
+// newMux routes the web UI pages, their static files and the REST API.
+func newMux(logHandler *logFileHandler, tmpl *template.Template) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.FileServer(http.FS(embeddedFiles)))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		renderPage(w, "logs", tmpl, logHandler)
+	})
+	mux.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
+		renderPage(w, "logs", tmpl, logHandler)
+	})
+	mux.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
+		renderPage(w, "settings", tmpl, logHandler)
+	})
+	for pattern, handler := range apiHandlers(logHandler, tmpl) {
+		mux.HandleFunc(pattern, handler)
+	}
+	return mux
+}
+
+// Run implements the server subcommand. It blocks serving syslog messages.
+func Run(args []string) error {
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	address := flags.String("a", ":514", "Syslog server address")
+	tcpAddress := flags.String("t", "", "Syslog server TCP address (disabled if empty)")
+	tcpAck := flags.Bool("tcpack", false, "Acknowledge each TCP message once logged, for clients using -ack")
+	tcpMaxSize := flags.Int("tcpmax", defaultMaxMessageSize, "Maximum size in bytes of a single TCP message")
+	tlsAddress := flags.String("tls", "", "Syslog over TLS (RFC 5425) address, e.g. :6514 (disabled if empty, needs -tlscert and -tlskey)")
+	tlsCert := flags.String("tlscert", "", "PEM certificate of the TLS listener")
+	tlsKey := flags.String("tlskey", "", "PEM private key of the TLS listener")
+	tlsClientCA := flags.String("tlsclientca", "", "PEM CA bundle to verify TLS client certificates against; clients must then present one, and its common name is recorded with their messages")
+	tcpMaxConns := flags.Int("tcpmaxconns", 1000, "Maximum number of concurrent TCP connections; further ones are closed when accepted (0 for no limit)")
+	tcpBacklog := flags.Int("tcpbacklog", 0, "Length of the queue of TCP connections not yet accepted; Linux caps it at net.core.somaxconn (0 for the system default)")
+	tcpIdle := flags.Duration("tcpidle", 0, "Close TCP connections that send nothing for this long, e.g. 5m (0 keeps them open)")
+	logFile := flags.String("f", "", "Log file path")
+	truncate := flags.Bool("truncate", false, "Empty the log file at startup instead of appending to it")
+	archiveDir := flags.String("archivedir", "", "Move compressed rotated log files to this directory, e.g. on cheaper storage")
+	memoryFallback := flags.Bool("memfallback", false, "Keep running memory-only with a warning if the log file is not writable")
+	maxSize := flags.Int("m", 10, "Max log file size in MB")
+	forwardAddr := flags.String("r", "", "Upstream syslog server address")
+	forwardProto := flags.String("p", "udp", "Forwarding protocol: 'tcp' or 'udp'")
+	forwardBatchSize := flags.Int("fwdbatch", 0, "Coalesce TCP forwarded messages into writes of up to this many bytes (0 writes each message on its own)")
+	forwardBatchWait := flags.Duration("fwdbatchdelay", 100*time.Millisecond, "Longest a message waits in a -fwdbatch batch before it is written")
+	forwardLevelName := flags.String("l", "info", "Forward messages of this severity or more severe, by name (e.g. warning) or number (0-7)")
+	apiAddr := flags.String("w", ":3001", "REST API and Web UI address")
+	debuglog := flags.String("d", "/dev/null", "debug log file")
+	diagFormat := flags.String("diagformat", "text", "Format of the server's own diagnostics in the debug log: 'text' (key=value) or 'json'")
+	diagLevel := flags.String("diaglevel", "debug", "Least severe diagnostics written to the debug log: debug, info, warn or error")
+	esURL := flags.String("es", "", "Elasticsearch URL for bulk indexing")
+	esIndex := flags.String("esindex", "syslog-{date}", "Elasticsearch index name, {date} expands to YYYY.MM.DD")
+	kafkaBrokers := flags.String("kafka", "", "Comma separated Kafka broker addresses")
+	kafkaTopic := flags.String("topic", "syslog", "Kafka topic to publish messages to")
+	unixPath := flags.String("unix", "", "Unix domain socket path to listen on, e.g. /dev/log")
+	unixProto := flags.String("unixtype", "unixgram", "Unix socket type: 'unixgram' or 'unix' (stream)")
+	sampleRate := flags.Int("sample", 0, "Keep 1 in N messages per source while it exceeds the sample threshold (0 disables)")
+	sampleThreshold := flags.Int("samplethreshold", 1000, "Messages per second per source before sampling starts")
+	var routes routeFlag
+	flags.Var(&routes, "route", "Also write messages of a severity or worse to a file, as severity=file, e.g. err=errors.log (repeatable)")
+	var facilityRoutes facilityRouteFlag
+	flags.Var(&facilityRoutes, "facilitylog", "Write messages of a facility to a file instead of the main log file, as facility=file, e.g. auth=auth.log (repeatable)")
+	forwardSequence := flags.String("fwdsequence", "", "Number forwarded messages per destination with structured data [sequence@32473 origin=\"ID\" seq=\"N\"], so receivers can detect reordering or loss; ID identifies this server")
+	var forwardRoutes forwardRouteFlag
+	flags.Var(&forwardRoutes, "fwdroute", "Forward messages whose app name matches a regexp to another server, as pattern=[proto://]addr (repeatable)")
+	var severityRules severityRuleFlag
+	flags.Var(&severityRules, "remap", "Override the severity of messages whose body matches a regexp, as pattern=severity, e.g. 'panic=crit' (repeatable, first match wins)")
+	var redactRules redactRuleFlag
+	flags.Var(&redactRules, "redact", "Replace text matching a regexp in incoming messages before they are stored or forwarded, as pattern=replacement, e.g. 'sk_live_\\w+=[KEY]' (repeatable)")
+	redactPreset := flags.String("redactpreset", "", "Comma separated built-in redaction rules: creditcard, email, password")
+	logFormat := flags.String("logformat", "", "Go template for log file lines, e.g. '{{.Timestamp}} {{.Host}} {{.App}}[{{.Severity}}]: {{.Message}}'. Fields: RemoteAddr, Timestamp, Host, App, Severity, Message. 'json' writes each message as a JSON object per line")
+	includeRaw := flags.Bool("includeraw", false, "Include the message as received in a 'raw' field of the JSON API and JSON log lines")
+	templateDir := flags.String("templatedir", "", "Load HTML templates from this directory instead of the embedded copies (for development)")
+	minConfidence := flags.Float64("minconfidence", 0, "Hide anomalies the LLM is less confident about than this, from 0 to 1 (0 shows all)")
+	anomalyContext := flags.Int("anomalycontext", 2, "Show this many messages received before and after each anomaly with it")
+	anomalyRecent := flags.Int("anomalyrecent", 0, "Only analyze the most recent N messages for anomalies (0 for all)")
+	anomalyWindow := flags.Duration("anomalywindow", 0, "Only analyze messages received within this window for anomalies, e.g. 10m (0 for all)")
+	displayTimezone := flags.String("tz", "", "Show timestamps in the web UI in this timezone, e.g. UTC, Local or Europe/Paris (empty shows them as received; can be changed on the settings page)")
+	sourceTimezone := flags.String("sourcetz", "", "Timezone assumed for RFC 3164 timestamps, which have none, when converting them for display (empty for the server's local zone)")
+	hostFromSource := flags.Bool("hostfromsource", false, "Show the source IP as the host name of messages without one or with '-' (can be changed on the settings page)")
+	strictParse := flags.Bool("strict", false, "Only keep messages in syslog format in memory; by default short messages such as '<13>link down' are kept with an empty host and app")
+	maxAge := flags.Duration("maxage", 0, "Drop messages received longer ago than this from memory, e.g. 1h, in addition to the maxMessages limit (0 keeps them)")
+	maxBody := flags.Int64("maxbody", defaultMaxBodySize, "Maximum size in bytes of the JSON body of a POST to /messages; larger requests get 413")
+	maxMsgLen := flags.Int("maxmsglen", 0, "Maximum length of messages kept in memory for the web UI and API (0 for no limit)")
+	evictPolicy := flags.String("evict", "oldest", "Which message to drop from memory when maxMessages is reached: 'oldest' or 'severity' (the oldest of the least severe, so errors survive floods of debug messages)")
+	malformedPolicy := flags.String("malformedpolicy", "keep", "What to do with messages that cannot be parsed: 'keep' them raw in memory and the log files but list them only with the unparsed messages filter, 'show' them raw among the other messages, or 'drop' them (not stored, logged or forwarded)")
+	maxMsgPolicy := flags.String("maxmsgpolicy", "truncate", "What to do with longer messages: 'truncate' or 'drop' from memory; log files always get the full message")
+	geoIPDB := flags.String("geoip", "", "MaxMind GeoIP2/GeoLite2 City database used to locate message sources")
+	workers := flags.Int("workers", 4, "Number of goroutines processing UDP messages; a source's messages always go to the same worker (0 processes them on the read loop)")
+	readBuffer := flags.Int("rcvbuf", 4<<20, "UDP socket receive buffer (SO_RCVBUF) in bytes, so bursts are not dropped by the kernel; Linux caps it at net.core.rmem_max (0 keeps the system default)")
+	workerQueue := flags.Int("workerqueue", 10000, "Messages queued per worker before new ones are dropped")
+	anomalyInterval := flags.Duration("anomalyinterval", 0, "Scan new messages for anomalies in the background at this interval, e.g. 5m, instead of when the web UI loads (0 disables, needs an API key)")
+	webhookURL := flags.String("webhook", "", "URL to POST newly detected anomalies to as JSON")
+	webhookDebounce := flags.Duration("webhookdebounce", time.Hour, "Do not send the same anomaly to the webhook again within this window")
+	alertURL := flags.String("alert", "", "Slack or Microsoft Teams incoming webhook URL to post severe messages to")
+	alertLevelName := flags.String("alertlevel", "crit", "Post messages of this severity or worse to the -alert webhook")
+	alertFormat := flags.String("alertformat", "slack", "Payload format of the -alert webhook: 'slack' or 'teams'")
+	alertLimit := flags.Int("alertlimit", 10, "Maximum alerts posted per minute; further ones are summarized when the minute ends")
+	eventLevelName := flags.String("eventlevel", "err", "Push messages of this severity or worse to /events, where the logs page flashes and beeps for them")
+	allow := flags.String("allow", "", "Comma separated CIDRs or IPs to accept messages from (empty allows all)")
+	deny := flags.String("deny", "", "Comma separated CIDRs or IPs to drop messages from, even if allowed")
+	multilineWindow := flags.Duration("multiline", 0, "Append lines without a <pri> prefix arriving within this window to the previous message from the same source, e.g. 200ms for stack traces (0 disables)")
+	queueWarn := flags.Int("queuewarn", 0, "Log a warning when a worker queue holds this many messages (0 for 80% of -workerqueue)")
+	dbPath := flags.String("db", "", "SQLite database to store all parsed messages in; /messages then queries it instead of the in-memory buffer")
+	captureFile := flags.String("capture", "", "Debug: write every received UDP datagram with its source, length and a hex dump to this file")
+	unixMode := flags.Uint("unixmode", 0666, "Permissions of the unix socket file")
+	selfLog := flags.String("selflog", "", "Also send the server's own diagnostics as syslog messages to [proto://]addr, or 'loopback' for this server's UDP address")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var diagOut io.Writer = os.Stderr
+	if *debuglog != "" {
+		f, err := os.OpenFile(*debuglog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening debug log file: %w", err)
+		}
+		diagOut = f
+	}
+	diag, err := newDiagHandler(diagOut, *diagFormat, *diagLevel)
+	if err != nil {
+		return err
+	}
+	setDiagHandler(diag)
+	if *selfLog != "" {
+		proto, addr, err := parseSelfLogTarget(*selfLog, *address)
+		if err != nil {
+			return err
+		}
+		sl, err := newSelfLogger(proto, addr)
+		if err != nil {
+			return fmt.Errorf("error connecting to the -selflog server: %w", err)
+		}
+		setDiagHandler(sl.handler(diag))
+		defer func() {
+			// No more lines may be queued once the logger is closed.
+			setDiagHandler(diag)
+			sl.close()
+		}()
+	}
+
+	*forwardProto = strings.ToLower(strings.TrimSpace(*forwardProto))
+	if err := checkForwardProto(*forwardProto); err != nil {
+		return fmt.Errorf("invalid -p: %w", err)
+	}
+	forwardLevel, err := syslog_client.ParseSeverity(*forwardLevelName)
+	if err != nil {
+		return fmt.Errorf("invalid forwarding level: %w", err)
+	}
+
+	if *forwardBatchWait <= 0 {
+		return fmt.Errorf("-fwdbatchdelay must be positive, got %s", *forwardBatchWait)
+	}
+	batch := forwardBatch{size: *forwardBatchSize, delay: *forwardBatchWait}
+
+	// The default forwarder is connected below, with batching.
+	logHandler, err := createLogFileHandler(*logFile, *maxSize, "", *forwardProto, forwardLevel)
+	if errors.Is(err, errLogFileNotWritable) && *memoryFallback {
+		fmt.Fprintf(os.Stderr, "Warning: %v, keeping messages in memory only\n", err)
+		slog.Warn("Falling back to memory-only mode", "error", err)
+		*logFile = ""
+		logHandler, err = createLogFileHandler("", *maxSize, "", *forwardProto, forwardLevel)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create log handler: %w", err)
+	}
+	defer logHandler.closeOutputs()
+	// Deferred before the listeners and the worker pool, so this runs after
+	// they stop and the forwarders get the messages they were processing.
+	defer logHandler.closeForwarders()
+	if *forwardAddr != "" {
+		if err := logHandler.connectForwarder(*forwardProto, *forwardAddr, batch); err != nil {
+			return fmt.Errorf("failed to create log handler: %w", err)
+		}
+	}
+	if *truncate {
+		if err := logHandler.truncateLogFile(); err != nil {
+			return err
+		}
+	}
+	llmConfig, err := syslog_anomaly.LLMConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	logHandler.config.Provider = llmConfig.Provider
+	logHandler.config.ApiKey = llmConfig.APIKey
+	logHandler.config.Url = llmConfig.URL
+	logHandler.config.Model = llmConfig.Model
+	logHandler.config.Prompt = llmConfig.PromptTemplate
+	logHandler.config.Temperature = llmConfig.Temperature
+	logHandler.config.MaxTokens = llmConfig.MaxTokens
+	logHandler.config.MaxRetries = llmConfig.MaxRetries
+	logHandler.config.LogFile = *logFile
+	logHandler.config.AnomalyRecent = *anomalyRecent
+	logHandler.config.AnomalyWindow = *anomalyWindow
+	logHandler.config.AnomalyContext = *anomalyContext
+	if *minConfidence < 0 || *minConfidence > 1 {
+		return fmt.Errorf("-minconfidence must be between 0 and 1, got %v", *minConfidence)
+	}
+	logHandler.config.MinConfidence = *minConfidence
+	logHandler.config.MaxAge = *maxAge
+	logHandler.config.HostFromSource = *hostFromSource
+	for _, name := range []string{*displayTimezone, *sourceTimezone} {
+		if _, err := loadTimezone(name); err != nil {
+			return err
+		}
+	}
+	logHandler.config.DisplayTimezone = *displayTimezone
+	logHandler.config.SourceTimezone = *sourceTimezone
+	logHandler.config.SeverityRules = severityRules
+	presetRules, err := parseRedactPresets(*redactPreset)
+	if err != nil {
+		return err
+	}
+	logHandler.config.RedactRules = append(presetRules, redactRules...)
+	if *maxAge > 0 {
+		logHandler.retention = startRetentionSweeper(logHandler, retentionInterval(*maxAge))
+		defer logHandler.retention.stop()
+	}
+	logHandler.config.WebhookURL = *webhookURL
+	if *webhookURL != "" {
+		logHandler.notifier = newAnomalyNotifier(*webhookURL, *webhookDebounce)
+		defer logHandler.notifier.close()
+	}
+	logHandler.config.AlertURL = *alertURL
+	if logHandler.config.AlertSeverity, err = syslog_client.ParseSeverity(*alertLevelName); err != nil {
+		return fmt.Errorf("invalid alert level: %w", err)
+	}
+	if logHandler.config.EventSeverity, err = syslog_client.ParseSeverity(*eventLevelName); err != nil {
+		return fmt.Errorf("invalid event level: %w", err)
+	}
+	if *alertURL != "" {
+		logHandler.alerter, err = newAlertNotifier(*alertURL, *alertFormat, *alertLimit, time.Minute)
+		if err != nil {
+			return err
+		}
+		defer logHandler.alerter.close()
+	}
+	if *anomalyInterval > 0 {
+		if logHandler.config.ApiKey == "" {
+			slog.Warn("Background anomaly scanning disabled: no API key set")
+		} else {
+			logHandler.scanner = startAnomalyScanner(logHandler, *anomalyInterval)
+			defer logHandler.scanner.stop()
+		}
+	}
+	for _, route := range routes {
+		severity, filename, _ := parseRoute(route)
+		logHandler.addRoute(severity, filename)
+	}
+	for _, route := range facilityRoutes {
+		facility, filename, _ := parseFacilityRoute(route)
+		logHandler.addFacilityRoute(facility, filename)
+	}
+	if *archiveDir != "" {
+		archive, err := startArchiver(*archiveDir, logHandler.logFileNames(), archiveInterval)
+		if err != nil {
+			return err
+		}
+		defer archive.stop()
+	}
+	if *maxMsgPolicy != "truncate" && *maxMsgPolicy != "drop" {
+		return fmt.Errorf("unsupported -maxmsgpolicy %q, use 'truncate' or 'drop'", *maxMsgPolicy)
+	}
+	if *evictPolicy != "oldest" && *evictPolicy != "severity" {
+		return fmt.Errorf("unsupported -evict %q, use 'oldest' or 'severity'", *evictPolicy)
+	}
+	logHandler.evictBySeverity = *evictPolicy == "severity"
+	logHandler.maxMsgLen = *maxMsgLen
+	if *maxBody <= 0 {
+		return fmt.Errorf("-maxbody must be positive, got %d", *maxBody)
+	}
+	logHandler.maxBodySize = *maxBody
+	logHandler.dropLong = *maxMsgPolicy == "drop"
+	logHandler.strictParse = *strictParse
+	switch *malformedPolicy {
+	case "keep":
+	case "show":
+		logHandler.showMalformed = true
+	case "drop":
+		logHandler.dropMalformed = true
+	default:
+		return fmt.Errorf("unsupported -malformedpolicy %q, use 'keep', 'show' or 'drop'", *malformedPolicy)
+	}
+	logHandler.config.IncludeRaw = *includeRaw
+	if *logFormat == "json" {
+		logHandler.jsonLog = true
+	} else if *logFormat != "" {
+		logHandler.logFormat, err = parseLogFormat(*logFormat)
+		if err != nil {
+			return err
+		}
+	}
+	for _, route := range forwardRoutes {
+		pattern, proto, addr, _ := parseForwardRoute(route, *forwardProto)
+		if err := logHandler.addForwardRoute(pattern, proto, addr, batch); err != nil {
+			return fmt.Errorf("error adding forward route: %w", err)
+		}
+	}
+	if *forwardSequence != "" {
+		logHandler.sequenceMessages(*forwardSequence)
+	}
+	if *geoIPDB != "" {
+		geo, err := openGeoIP(*geoIPDB)
+		if err != nil {
+			return fmt.Errorf("error opening GeoIP database: %w", err)
+		}
+		defer geo.close()
+		logHandler.geoIP = geo
+	}
+	if *dbPath != "" {
+		store, err := openSQLiteStore(*dbPath)
+		if err != nil {
+			return err
+		}
+		if logHandler.lastID, err = store.lastID(); err != nil {
+			store.Close()
+			return fmt.Errorf("failed to read the last message ID from the database: %w", err)
+		}
+		logHandler.store = store
+		logHandler.addOutput(store)
+	}
+	if *captureFile != "" {
+		capture, err := newPacketCapture(*captureFile)
+		if err != nil {
+			return err
+		}
+		defer capture.close()
+		logHandler.capture = capture
+		slog.Info("Capturing received datagrams", "file", *captureFile)
+	}
+	if *sampleRate > 1 {
+		logHandler.sampler = newSampler(*sampleRate, *sampleThreshold)
+	}
+	if *esURL != "" {
+		es := newESIndexer(*esURL, *esIndex)
+		es.sourceZone = logHandler.sourceLocation
+		es.start()
+		logHandler.addOutput(es)
+	}
+	if *kafkaBrokers != "" {
+		logHandler.addOutput(newKafkaOutput(newKafkaWriter(*kafkaBrokers, *kafkaTopic), 10000))
+	}
+	if *allow != "" || *deny != "" {
+		logHandler.sources, err = newSourceFilter(*allow, *deny)
+		if err != nil {
+			return err
+		}
+	}
+	if *multilineWindow > 0 {
+		logHandler.multiline = newMultilineBuffer(*multilineWindow, logHandler.processMessage)
+		// Deferred before the worker pool, so this runs after it drains.
+		defer logHandler.multiline.flush()
+	}
+	if *workers > 0 {
+		if *queueWarn <= 0 {
+			*queueWarn = *workerQueue * 8 / 10
+		}
+		logHandler.workerPool = newWorkerPool(logHandler, *workers, *workerQueue, *queueWarn)
+		// Deferred before closing the UDP listener, so this runs after the read
+		// loop stops and drains the queued messages.
+		defer logHandler.workerPool.close()
+	}
+	tmpl, err := loadTemplates(*templateDir)
+	if err != nil {
+		return fmt.Errorf("failed to parse templates: %w", err)
+	}
+	mux := newMux(logHandler, tmpl)
+
+	// The server is closed when Run returns, also when it fails to start the
+	// syslog listeners, so it does not outlive the rest of the server.
+	httpServer := &http.Server{Addr: *apiAddr, Handler: mux}
+	defer httpServer.Close()
+	go func() {
+		fmt.Printf("Web UI and REST API listening on %s\n", *apiAddr)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Failed to start Web UI and REST API", "addr", *apiAddr, "error", err)
+			os.Exit(1)
 		}
 	}()
 
 	udpAddr, err := net.ResolveUDPAddr("udp", *address)
 	if err != nil {
-		log.Fatalf("Error resolving UDP address: %v", err)
+		return fmt.Errorf("error resolving UDP address: %w", err)
 	}
 
 	udpConn, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
-		log.Fatalf("Error starting UDP listener: %v", err)
+		return fmt.Errorf("error starting UDP listener: %w", err)
 	}
 	defer udpConn.Close()
+	if *readBuffer > 0 {
+		effective, err := setReadBuffer(udpConn, *readBuffer)
+		if err != nil {
+			slog.Error("Error setting UDP receive buffer", "bytes", *readBuffer, "error", err)
+		} else {
+			slog.Info("UDP receive buffer set", "bytes", effective, "requested", *readBuffer)
+			if effective < *readBuffer {
+				slog.Warn("UDP receive buffer is smaller than requested, raise net.core.rmem_max to avoid drops under bursts", "bytes", effective, "requested", *readBuffer)
+			}
+		}
+	}
 
 	fmt.Printf("Syslog server listening on UDP %s\n", *address)
 
-	buffer := make([]byte, 1024)
-	for {
-		n, _, err := udpConn.ReadFromUDP(buffer)
+	if *tcpAddress != "" {
+		tl, err := listenTCP(*tcpAddress, *tcpMaxSize, *tcpMaxConns, *tcpBacklog, *tcpIdle, *tcpAck, nil, logHandler)
 		if err != nil {
-			log.Printf("Error reading UDP message: %v", err)
-			continue
+			return fmt.Errorf("error starting TCP listener: %w", err)
+		}
+		defer tl.close()
+		fmt.Printf("Syslog server listening on TCP %s\n", *tcpAddress)
+	}
+
+	if *tlsAddress != "" {
+		tlsConfig, err := loadTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			return err
+		}
+		tl, err := listenTCP(*tlsAddress, *tcpMaxSize, *tcpMaxConns, *tcpBacklog, *tcpIdle, *tcpAck, tlsConfig, logHandler)
+		if err != nil {
+			return fmt.Errorf("error starting TLS listener: %w", err)
 		}
-		message := strings.TrimSpace(string(buffer[:n]))
-		logHandler.logMessage(message)
+		defer tl.close()
+		fmt.Printf("Syslog server listening on TLS %s\n", *tlsAddress)
 	}
+
+	if *unixPath != "" {
+		ul, err := listenUnix(*unixPath, *unixProto, os.FileMode(*unixMode), logHandler)
+		if err != nil {
+			return fmt.Errorf("error starting unix socket listener: %w", err)
+		}
+		defer ul.close()
+		fmt.Printf("Syslog server listening on unix socket %s\n", *unixPath)
+	}
+
+	defer reopenOnSIGHUP(logHandler)()
+
+	// Stop the read loop on SIGINT/SIGTERM so deferred cleanup runs.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		if _, ok := <-signals; ok {
+			udpConn.Close()
+		}
+	}()
+
+	return serveUDP(udpConn, logHandler, logHandler.workerPool)
 }
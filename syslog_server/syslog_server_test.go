@@ -1,46 +1,65 @@
-package main
+package syslog_server
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"syslog/syslog_anomaly"
 )
 
 func TestSyslogServer(t *testing.T) {
-	// Start the syslog server with a specific number of lines per file
-	cmd := exec.Command("go", "run", "syslog_server.go", "-file", "syslog.log",
-		"-addr", ":514", "-buf", "1024", "-maxsize", "1", "-debug", "debug.log")
+	dir := t.TempDir()
+	// Build the binary instead of using go run, so killing the process
+	// stops the server.
+	binary := filepath.Join(dir, "syslog")
+	if out, err := exec.Command("go", "build", "-o", binary, "..").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	// Start the syslog server with 1 MB log files
+	logFile := filepath.Join(dir, "syslog.log")
+	cmd := exec.Command(binary, "server", "-f", logFile, "-a", "127.0.0.1:5514",
+		"-w", ":3001", "-m", "1", "-workers", "0", "-d", filepath.Join(dir, "debug.log"))
 	err := cmd.Start()
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer cmd.Wait()
 	defer cmd.Process.Kill()
 
-	ssURL := "http://localhost:8080"
-
-	// Wait for the server to start
-	//time.Sleep(1 * time.Second)
+	ssURL := "http://localhost:3001"
 
 	log.Printf("waiting for syslog service to start")
-	for i := 0; i < 10; i++ {
-		isUp, _ := isServiceUp(ssURL)
-		if isUp {
-			break
+	isUp := false
+	for i := 0; i < 50 && !isUp; i++ {
+		if isUp, _ = isServiceUp(ssURL); !isUp {
+			time.Sleep(100 * time.Millisecond)
 		}
-		time.Sleep(1 * time.Second)
+	}
+	if !isUp {
+		t.Fatal("syslog service did not start")
 	}
 	log.Printf("syslog service started, pid %d", cmd.Process.Pid)
 
-	removeExistingSyslogFiles()
-
-	// Send a test message to the server
-	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:514")
+	// Send test messages to the server
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:5514")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -50,52 +69,34 @@ func TestSyslogServer(t *testing.T) {
 	}
 	defer conn.Close()
 
+	// Send batches of messages until the 1 MB log file is rotated and
+	// compressed. Datagrams may be dropped when the server falls behind, so
+	// the number of messages needed varies.
 	log.Printf("sending syslog messages")
-	for i := 0; i < 30000; i++ {
-		msg := []byte(fmt.Sprintf("<16>Jan  1 00:00:00 localhost Test message %d", i))
-		_, err = conn.Write(msg)
+	deadline := time.Now().Add(20 * time.Second)
+	for i := 0; ; {
+		for end := i + 1000; i < end; i++ {
+			msg := []byte(fmt.Sprintf("<16>Jan  1 00:00:00 localhost Test message %d", i))
+			if _, err := conn.Write(msg); err != nil {
+				t.Fatal(err)
+			}
+			if i%100 == 99 {
+				// Do not overflow the socket buffer.
+				time.Sleep(time.Millisecond)
+			}
+		}
+		rotated, err := filepath.Glob(filepath.Join(dir, "syslog-*.log.gz"))
 		if err != nil {
 			t.Fatal(err)
 		}
-	}
-
-	// Verify that the log file was rotated correctly
-	time.Sleep(1 * time.Second)
-	files, err := os.ReadDir(".")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	rotatedFile := ""
-	for _, file := range files {
-		fn := file.Name()
-		log.Printf("checking file %s", fn)
-		if strings.HasPrefix(fn, "syslog-") && strings.HasSuffix(fn, ".log.gz") {
-			log.Printf("found rotated file %s", fn)
-			rotatedFile = fn
+		if len(rotated) > 0 {
+			log.Printf("found rotated file %s after %d messages", rotated[0], i)
 			break
 		}
-	}
-	if rotatedFile == "" {
-		t.Errorf("Expected rotated log file not found")
-	}
-}
-func removeExistingSyslogFiles() error {
-	files, err := os.ReadDir(".")
-	if err != nil {
-		return err
-	}
-	for _, file := range files {
-		if strings.HasPrefix(file.Name(), "syslog.log") ||
-			(strings.HasPrefix(file.Name(), "syslog-") &&
-				strings.HasSuffix(file.Name(), "log.gz")) {
-			err := os.Remove(file.Name())
-			if err != nil {
-				return err
-			}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected rotated log file not found after %d messages", i)
 		}
 	}
-	return nil
 }
 
 func isServiceUp(url string) (bool, error) {
@@ -118,3 +119,1273 @@ func isServiceUp(url string) (bool, error) {
 
 	return true, nil
 }
+
+func TestEchoHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(`{"key": "value"}`))
+	req.Header.Set("Content-Type", "application/json")
+	echoHandler(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"key": "value"}` || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected the body echoed back, got %d %q %q", rec.Code, rec.Body, rec.Header().Get("Content-Type"))
+	}
+
+	rec = httptest.NewRecorder()
+	echoHandler(rec, httptest.NewRequest(http.MethodGet, "/echo", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	echoHandler(rec, httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(strings.Repeat("x", maxEchoSize+1))))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a large body, got %d", rec.Code)
+	}
+}
+
+func TestPostMessagesBodyLimit(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.maxBodySize = 200
+	post := func(messages ...string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(MessageRequest{Messages: messages})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		messagesHandler(handler, testTemplates(t))(rec, httptest.NewRequest(http.MethodPost, "/messages", bytes.NewReader(body)))
+		return rec
+	}
+
+	if rec := post("<14>Jan 1 00:00:00 host app: small"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a small body, got %d: %s", rec.Code, rec.Body)
+	}
+	rec := post("<14>Jan 1 00:00:00 host app: " + strings.Repeat("x", 200))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a body over the limit, got %d: %s", rec.Code, rec.Body)
+	}
+	if len(handler.messages) != 1 {
+		t.Errorf("expected only the small message to be logged, got %d", len(handler.messages))
+	}
+}
+
+func TestFindAnomaliesConfiguredRequest(t *testing.T) {
+	var got syslog_anomaly.CompletionRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(syslog_anomaly.CompletionResponse{})
+	}))
+	defer srv.Close()
+
+	temperature := 0.2
+	config := &Config{Url: srv.URL, Prompt: "Find odd lines:\n{messages}", Temperature: &temperature, MaxTokens: 256}
+	if _, err := findAnomalies(context.Background(), config.llmConfig(), []string{"<13>Jan 1 00:00:00 host app: hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Temperature == nil || *got.Temperature != 0.2 || got.MaxTokens != 256 {
+		t.Errorf("expected temperature 0.2 and max_tokens 256, got %v and %d", got.Temperature, got.MaxTokens)
+	}
+	if got.Model != "gpt-3.5-turbo" {
+		t.Errorf("expected default model, got %s", got.Model)
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Content != "Find odd lines:\nJan 1 00:00:00 host app: hello" {
+		t.Errorf("unexpected prompt %+v", got.Messages)
+	}
+}
+
+func TestTestLLMHandler(t *testing.T) {
+	const apiKey = "sk-test-0123456789abcdef"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+apiKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{
+				"message": "Incorrect API key provided: " + strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+			}})
+			return
+		}
+		json.NewEncoder(w).Encode(syslog_anomaly.CompletionResponse{Choices: []syslog_anomaly.Choice{
+			{Message: syslog_anomaly.Message{Content: "[]"}},
+		}})
+	}))
+	defer srv.Close()
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := func(key string) (int, llmTestResult, string) {
+		config := *handler.getConfig()
+		config.Url, config.Model, config.ApiKey = srv.URL, "gpt-test", key
+		handler.updateConfig(&config)
+		rec := httptest.NewRecorder()
+		testLLMHandler(handler)(rec, httptest.NewRequest(http.MethodPost, "/config/test-llm", nil))
+		var result llmTestResult
+		body := rec.Body.String()
+		if err := json.Unmarshal([]byte(body), &result); err != nil {
+			t.Fatalf("invalid response %q: %v", body, err)
+		}
+		return rec.Code, result, body
+	}
+
+	code, result, _ := test(apiKey)
+	if code != http.StatusOK || !result.OK || result.Model != "gpt-test" || result.URL != srv.URL || result.Error != "" {
+		t.Errorf("expected a successful test, got %d %+v", code, result)
+	}
+
+	const wrongKey = "sk-wrong-0123456789abcdef"
+	code, result, body := test(wrongKey)
+	if code != http.StatusBadGateway || result.OK || result.Status != http.StatusUnauthorized {
+		t.Errorf("expected a failed test with status 401, got %d %+v", code, result)
+	}
+	if !strings.Contains(result.Error, "Incorrect API key provided: ***cdef") {
+		t.Errorf("expected the provider's error message, got %q", result.Error)
+	}
+	if strings.Contains(body, wrongKey) {
+		t.Errorf("expected the API key to be masked, got %s", body)
+	}
+
+	rec := httptest.NewRecorder()
+	testLLMHandler(handler)(rec, httptest.NewRequest(http.MethodGet, "/config/test-llm", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET, got %d", rec.Code)
+	}
+}
+
+func TestRenderMessageRowsSeverityClass(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<8>Jan 1 00:00:00 host kernel: panic", "127.0.0.1:514")
+	handler.logMessage("<14>Jan 1 00:00:01 host app: all good", "127.0.0.1:514")
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rows), `class="sev-emerg"`) {
+		t.Errorf("expected emergency row class in %s", rows)
+	}
+	if !strings.Contains(string(rows), `class="sev-info"`) {
+		t.Errorf("expected info row class in %s", rows)
+	}
+}
+
+func TestRenderMessageRowsAppNameList(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<38>Jan 1 00:00:00 web-01 sshd: Accepted publickey for alice", "127.0.0.1:514")
+	handler.logMessage("<85>Jan 1 00:00:01 web-01 sudo: alice : COMMAND=/bin/ls", "127.0.0.1:514")
+	handler.logMessage("<86>Jan 1 00:00:02 web-01 su: session opened for root", "127.0.0.1:514")
+	handler.logMessage("<14>Jan 1 00:00:03 web-01 nginx: GET /index.html", "127.0.0.1:514")
+	handler.logMessage("<14>Jan 1 00:00:04 db-01 sshd: Accepted password for bob", "127.0.0.1:514")
+	config := handler.getConfig()
+	config.AppName = " sshd,sudo , su,"
+
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"alice", "COMMAND=/bin/ls", "session opened", "bob"} {
+		if !strings.Contains(string(rows), want) {
+			t.Errorf("expected %q in %s", want, rows)
+		}
+	}
+	if strings.Contains(string(rows), "/index.html") {
+		t.Errorf("expected nginx to be filtered out, got %s", rows)
+	}
+
+	// Each list is matched separately.
+	config.HostName = "web-02, db-01"
+	if rows, _ = renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{}); !strings.Contains(string(rows), "bob") || strings.Contains(string(rows), "alice") {
+		t.Errorf("expected only the sshd message from db-01, got %s", rows)
+	}
+}
+
+func TestAnomalyAnalysisDoesNotBlockIngest(t *testing.T) {
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		json.NewEncoder(w).Encode(syslog_anomaly.CompletionResponse{})
+	}))
+	defer llm.Close()
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := handler.getConfig()
+	config.ApiKey = "test"
+	config.Url = llm.URL
+	config.AnomaliesOnly = true
+	handler.logMessage("<11>Jan 1 00:00:00 db-01 kernel: disk failure", "127.0.0.1:514")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := filteredMessages(context.Background(), handler, messageOrder{}, messagePage{})
+		done <- err
+	}()
+	<-requested
+	logged := make(chan struct{})
+	go func() {
+		handler.logMessage("<14>Jan 1 00:00:01 web-01 app: during analysis", "127.0.0.1:514")
+		close(logged)
+	}()
+	select {
+	case <-logged:
+	case <-time.After(2 * time.Second):
+		t.Error("expected ingest to proceed while the LLM is analyzing")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// The message received during the analysis is kept for the next one.
+	if got := rawMessages(handler.messages); len(got) != 1 || !strings.Contains(got[0], "during analysis") {
+		t.Errorf("expected only the new message to remain, got %q", got)
+	}
+}
+
+func TestRenderMessageRowsLLMAuthFailure(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error": {"message": "Incorrect API key provided"}}`)
+	}))
+	defer llm.Close()
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := handler.getConfig()
+	config.ApiKey = "wrong"
+	config.Url = llm.URL
+	config.AnomaliesOnly = true
+	handler.logMessage("<11>Jan 1 00:00:00 db-01 kernel: disk failure", "127.0.0.1:514")
+
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rows), "LLM authentication failed (401)") {
+		t.Errorf("expected the authentication failure to be shown, got %s", rows)
+	}
+}
+
+func TestRenderMessageRowsMinConfidence(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := `[{"message": "db-01 kernel: disk failure", "reason": "hardware", "severity": "critical", "confidence": 0.9},
+			{"message": "web-01 cron: job took 2s", "reason": "borderline", "severity": "low", "confidence": 0.2}]`
+		json.NewEncoder(w).Encode(syslog_anomaly.CompletionResponse{
+			Choices: []syslog_anomaly.Choice{{Message: syslog_anomaly.Message{Content: syslog_anomaly.MessageContent(content)}}},
+		})
+	}))
+	defer llm.Close()
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := handler.getConfig()
+	config.ApiKey = "test"
+	config.Url = llm.URL
+	config.AnomaliesOnly = true
+	config.MinConfidence = 0.5
+	handler.logMessage("<11>Jan 1 00:00:00 db-01 kernel: disk failure", "127.0.0.1:514")
+	handler.logMessage("<14>Jan 1 00:00:01 web-01 cron: job took 2s", "127.0.0.1:514")
+
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rows), "hardware") || strings.Contains(string(rows), "borderline") {
+		t.Errorf("expected only the confident anomaly, got %s", rows)
+	}
+	// The threshold applies when displaying, so lowering it shows the rest.
+	config.MinConfidence = 0
+	if rows, _ = renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{}); !strings.Contains(string(rows), "borderline") {
+		t.Errorf("expected all anomalies without a threshold, got %s", rows)
+	}
+}
+
+func TestCountersHandler(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.config.MaxMessages = 2
+	for i := 0; i < 5; i++ {
+		handler.logMessage(fmt.Sprintf("<11>Jan 1 00:00:0%d host app: error %d", i, i), "127.0.0.1:514")
+	}
+	handler.logMessage("<14>Jan 1 00:00:05 host app: info", "127.0.0.1:514")
+	handler.logMessage("no priority", "127.0.0.1:514")
+
+	rec := httptest.NewRecorder()
+	countersHandler(handler)(rec, httptest.NewRequest(http.MethodGet, "/counters", nil))
+	var counters struct {
+		Total    uint64            `json:"total"`
+		Severity map[string]uint64 `json:"severity"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&counters); err != nil {
+		t.Fatal(err)
+	}
+	if counters.Total != 7 {
+		t.Errorf("expected 7 messages received, got %d", counters.Total)
+	}
+	if counters.Severity["err"] != 5 || counters.Severity["info"] != 1 || counters.Severity["notice"] != 1 {
+		t.Errorf("unexpected severity breakdown %v", counters.Severity)
+	}
+}
+
+func TestConfigHandlerGet(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.config.ApiKey = "sk-secret"
+
+	form := "severity=4&maxMessages=50&appname=sshd&hostname=web-01&messagepattern=fail&anomaliesOnly=on"
+	req := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	configHandler(handler)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /config returned %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	configHandler(handler)(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if strings.Contains(rec.Body.String(), "sk-secret") || strings.Contains(rec.Body.String(), "apiKey") {
+		t.Errorf("expected the API key to be redacted, got %s", rec.Body.String())
+	}
+	var got Config
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Severity != 4 || got.MaxMessages != 50 || got.AppName != "sshd" || got.HostName != "web-01" ||
+		got.MessagePattern != "fail" || !got.AnomaliesOnly {
+		t.Errorf("unexpected config %+v", got)
+	}
+	if handler.getConfig().ApiKey != "sk-secret" {
+		t.Error("GET /config must not clear the stored API key")
+	}
+}
+
+func TestConfigHandlerValidation(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := map[string]string{"severity": "4", "maxMessages": "100", "messagepattern": "fail(ed|ure)"}
+	tests := []struct {
+		field, value, want string
+	}{
+		{"severity", "abc", "severity"},
+		{"severity", "8", "severity"},
+		{"severity", "-1", "severity"},
+		{"maxMessages", "-5", "maxMessages"},
+		{"maxMessages", "lots", "maxMessages"},
+		{"messagepattern", "fail(ed", "messagepattern"},
+	}
+	for _, tt := range tests {
+		form := url.Values{}
+		for k, v := range valid {
+			form.Set(k, v)
+		}
+		form.Set(tt.field, tt.value)
+		req := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		configHandler(handler)(rec, req)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s=%q: expected 400 mentioning %s, got %d %q", tt.field, tt.value, tt.want, rec.Code, rec.Body.String())
+		}
+	}
+	if config := handler.getConfig(); config.Severity != 7 || config.MaxMessages != 1000 {
+		t.Errorf("invalid updates must not change the config, got %+v", config)
+	}
+
+	form := url.Values{"severity": {"4"}, "maxMessages": {"0"}, "messagepattern": {"disk full"}}
+	req := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	configHandler(handler)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected valid config to be accepted, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<11>Jan 1 00:00:00 db-01 kernel: Disk failure on sda", "127.0.0.1:514")
+	handler.logMessage("<14>Jan 1 00:00:01 web-01 nginx: GET /index.html", "127.0.0.1:514")
+	handler.logMessage("<11>Jan 1 00:00:02 db-02 kernel: disk FAILURE on sdb", "127.0.0.1:514")
+
+	search := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		searchHandler(handler)(rec, httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+		return rec
+	}
+
+	var results []syslogMsg
+	if err := json.NewDecoder(search("q=disk+failure").Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Hostname != "db-01" || results[1].Hostname != "db-02" {
+		t.Errorf("expected both disk failures, got %+v", results)
+	}
+
+	results = nil
+	if err := json.NewDecoder(search("q=sd[ab]%24&regex=true").Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("expected 2 regex matches, got %+v", results)
+	}
+
+	if rec := search("q=%28unclosed&regex=true"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid regex, got %d", rec.Code)
+	}
+}
+
+func TestUnwritableLogFile(t *testing.T) {
+	// A regular file in place of the directory makes the path unwritable,
+	// even for root.
+	notADir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notADir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	logFile := filepath.Join(notADir, "syslog.log")
+	if _, err := createLogFileHandler(logFile, 10, "", "udp", 6); !errors.Is(err, errLogFileNotWritable) {
+		t.Fatalf("expected errLogFileNotWritable, got %v", err)
+	}
+
+	err := Run([]string{"-f", logFile, "-a", "127.0.0.1:0", "-w", "127.0.0.1:0"})
+	if err == nil || !strings.Contains(err.Error(), "not writable") {
+		t.Errorf("expected the server to fail fast, got %v", err)
+	}
+}
+
+// freeAddr returns a local address that is currently free for proto.
+func freeAddr(t *testing.T, proto string) string {
+	t.Helper()
+	if proto == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestBinaryOutsideSourceTree(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the binary")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "syslog")
+	if out, err := exec.Command("go", "build", "-o", binary, "..").CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, out)
+	}
+
+	webAddr := freeAddr(t, "tcp")
+	cmd := exec.Command(binary, "server", "-a", freeAddr(t, "udp"), "-w", webAddr)
+	cmd.Dir = dir
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	baseURL := "http://" + webAddr
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		resp, err = http.Post(baseURL+"/messages", "application/json",
+			strings.NewReader(`{"messages": ["<11>Jan 1 00:00:00 web-01 nginx: embedded templates"]}`))
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for _, path := range []string{"/messages", "/static/search.js"} {
+		resp, err := http.Get(baseURL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s returned %d: %s", path, resp.StatusCode, body)
+		}
+		if path == "/messages" && !strings.Contains(string(body), "embedded templates") {
+			t.Errorf("expected the message row to be rendered, got %s", body)
+		}
+	}
+}
+
+func testTemplates(tb testing.TB) *template.Template {
+	tb.Helper()
+	tmpl, err := loadTemplates("")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return tmpl
+}
+
+func BenchmarkMessagesHandler(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		handler.logMessage(fmt.Sprintf("<11>Jan 1 00:00:00 web-01 nginx: upstream timed out %d", i), "127.0.0.1:514")
+	}
+	serve := messagesHandler(handler, testTemplates(b))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/messages", nil))
+	}
+}
+
+func TestAnomalyAnalysisRecentSlice(t *testing.T) {
+	var prompts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req syslog_anomaly.CompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		prompts = append(prompts, string(req.Messages[0].Content))
+		json.NewEncoder(w).Encode(syslog_anomaly.CompletionResponse{})
+	}))
+	defer srv.Close()
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := handler.getConfig()
+	config.ApiKey = "test"
+	config.Url = srv.URL
+	config.AnomaliesOnly = true
+	config.AnomalyRecent = 2
+	for i := 0; i < 5; i++ {
+		handler.logMessage(fmt.Sprintf("<13>Jan 1 00:00:0%d host app: message %d", i, i), "127.0.0.1:514")
+	}
+	if _, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts) != 1 {
+		t.Fatalf("expected 1 LLM request, got %d", len(prompts))
+	}
+	for i := 0; i < 5; i++ {
+		sent := strings.Contains(prompts[0], fmt.Sprintf("message %d", i))
+		if sent != (i >= 3) {
+			t.Errorf("message %d sent = %v, want %v", i, sent, i >= 3)
+		}
+	}
+	// The messages that were not analyzed are kept for the next analysis.
+	if got := rawMessages(handler.messages); len(got) != 3 || !strings.HasSuffix(got[2], "message 2") {
+		t.Fatalf("expected messages 0 to 2 to remain, got %q", got)
+	}
+	if _, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts) != 2 || !strings.Contains(prompts[1], "message 1") || !strings.Contains(prompts[1], "message 2") {
+		t.Errorf("expected messages 1 and 2 to be analyzed next, got %q", prompts[1:])
+	}
+	if got := rawMessages(handler.messages); len(got) != 1 || !strings.HasSuffix(got[0], "message 0") {
+		t.Errorf("expected only message 0 to remain, got %q", got)
+	}
+
+	now := time.Now()
+	messages := []storedMessage{
+		{Raw: "old", Received: now.Add(-time.Hour)},
+		{Raw: "recent", Received: now.Add(-time.Minute)},
+		{Raw: "new", Received: now},
+	}
+	if got := rawMessages(recentMessages(messages, 0, 10*time.Minute, now)); strings.Join(got, ",") != "recent,new" {
+		t.Errorf("expected messages within the window, got %q", got)
+	}
+	if got := recentMessages(messages, 0, 0, now); len(got) != 3 {
+		t.Errorf("expected all messages by default, got %d", len(got))
+	}
+}
+
+func TestIngestHandler(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(body []byte, contentType, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		rec := httptest.NewRecorder()
+		ingestHandler(handler)(rec, req)
+		return rec
+	}
+
+	plain := "<13>Jan 1 00:00:00 host app: plain one\n\n<13>Jan 1 00:00:01 host app: plain two"
+	if rec := post([]byte(plain), "text/plain", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"received":2`) {
+		t.Errorf("plain body: %d %s", rec.Code, rec.Body.String())
+	}
+
+	ndjson := `{"message":"<13>Jan 1 00:00:02 host app: ndjson one","host":"agent"}` + "\n" +
+		`{"log":"<13>Jan 1 00:00:03 host app: ndjson two"}` + "\n"
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(ndjson))
+	gz.Close()
+	if rec := post(gzipped.Bytes(), "application/x-ndjson", "gzip"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"received":2`) {
+		t.Errorf("gzipped NDJSON body: %d %s", rec.Code, rec.Body.String())
+	}
+
+	want := []string{
+		"<13>Jan 1 00:00:00 host app: plain one",
+		"<13>Jan 1 00:00:01 host app: plain two",
+		"<13>Jan 1 00:00:02 host app: ndjson one",
+		"<13>Jan 1 00:00:03 host app: ndjson two",
+	}
+	if got := rawMessages(handler.messages); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if rec := post([]byte("not gzip"), "text/plain", "gzip"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid gzip body, got %d", rec.Code)
+	}
+	if rec := post([]byte("{broken"), "application/x-ndjson", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid NDJSON, got %d", rec.Code)
+	}
+}
+
+func TestMaxMessageLength(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "syslog.log")
+	handler, err := createLogFileHandler(logFile, 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.maxMsgLen = 40
+	long := "<13>Jan 1 00:00:00 host app: " + strings.Repeat("x", 100)
+	handler.logMessage(long, "127.0.0.1:514")
+	handler.logMessage("<13>Jan 1 00:00:01 host app: short", "127.0.0.1:514")
+	handler.logger.Close()
+
+	stored := handler.messages[0].Raw
+	if stored != long[:40]+truncationMarker {
+		t.Errorf("expected the stored message to be truncated, got %q", stored)
+	}
+	onDisk, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstLine := strings.SplitN(string(onDisk), "\n", 2)[0]
+	if firstLine != skipNumericPrefix(long) {
+		t.Errorf("expected the full message on disk, got %d bytes", len(firstLine))
+	}
+	if len(firstLine) <= len(stored) {
+		t.Errorf("stored length %d should be shorter than on-disk length %d", len(stored), len(firstLine))
+	}
+
+	handler.dropLong = true
+	handler.logMessage(long, "127.0.0.1:514")
+	if got := len(handler.messages); got != 2 {
+		t.Errorf("expected the long message to be dropped from memory, got %d messages", got)
+	}
+}
+
+func TestConfigHandlerPresets(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		configHandler(handler)(rec, req)
+		return rec
+	}
+
+	rec := post(url.Values{"severity": {"3"}, "maxMessages": {"100"}, "appname": {"sshd"},
+		"messagepattern": {"Failed password"}, "savePreset": {"ssh failures"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("saving a preset returned %d: %s", rec.Code, rec.Body)
+	}
+	before := handler.getConfig()
+	if rec := post(url.Values{"severity": {"7"}, "maxMessages": {"100"}, "hostname": {"web-01"}}); rec.Code != http.StatusOK {
+		t.Fatalf("POST /config returned %d", rec.Code)
+	}
+	if before.AppName != "sshd" {
+		t.Error("updating the config must not modify the previous copy")
+	}
+
+	if rec := post(url.Values{"applyPreset": {"ssh failures"}}); rec.Code != http.StatusOK {
+		t.Fatalf("applying a preset returned %d: %s", rec.Code, rec.Body)
+	}
+	config := handler.getConfig()
+	if config.Severity != 3 || config.AppName != "sshd" || config.HostName != "" || config.MessagePattern != "Failed password" {
+		t.Errorf("preset not applied, got %+v", config)
+	}
+	if config.MaxMessages != 100 {
+		t.Errorf("applying a preset must keep maxMessages, got %d", config.MaxMessages)
+	}
+	if rec := post(url.Values{"applyPreset": {"missing"}}); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown preset, got %d", rec.Code)
+	}
+
+	var page strings.Builder
+	if err := testTemplates(t).ExecuteTemplate(&page, "settings.html", pageData{Config: config}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page.String(), `<option value="ssh failures" data-messagepattern="Failed password" data-severity="3" data-appname="sshd"`) {
+		t.Errorf("expected the preset in the settings dropdown:\n%s", page.String())
+	}
+}
+
+func TestMessagesHandlerJSONGzip(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/messages?format=json", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		messagesHandler(handler, testTemplates(t))(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		return rec
+	}
+
+	handler.logMessage("<14>Jan 1 00:00:00 host app: small", "127.0.0.1:514")
+	if rec := get("gzip"); rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected a small response to be sent uncompressed")
+	}
+
+	for i := 0; i < 100; i++ {
+		handler.logMessage(fmt.Sprintf("<14>Jan 1 00:00:00 host app: message %d", i), "127.0.0.1:514")
+	}
+	plain := get("")
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Fatal("expected no compression without Accept-Encoding")
+	}
+	rec := get("deflate, gzip;q=0.8")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected headers %v", rec.Header())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, plain.Body.Bytes()) {
+		t.Errorf("decompressed body differs from the uncompressed response")
+	}
+	var messages []syslogMsg
+	if err := json.Unmarshal(body, &messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 101 || messages[100].Message != "message 99" || messages[100].Hostname != "host" {
+		t.Errorf("unexpected messages %+v", messages)
+	}
+	if rec := get("gzip;q=0"); rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected gzip;q=0 to disable compression")
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	info := BuildInfo{Version: "v1.2.3", Commit: "abc123", BuildTime: "2026-01-02T03:04:05Z", GoVersion: "go1.23.2"}
+	rec := httptest.NewRecorder()
+	versionHandler(info)(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d, headers %v", rec.Code, rec.Header())
+	}
+	var got map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"version": "v1.2.3", "commit": "abc123", "buildTime": "2026-01-02T03:04:05Z", "goVersion": "go1.23.2"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMessageByID(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.config.MaxMessages = 3
+	for i := 0; i < 5; i++ {
+		handler.logMessage(fmt.Sprintf("<14>Jan 1 00:00:0%d host app: message %d", i, i), "127.0.0.1:514")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/messages/{id}", messageByIDHandler(handler))
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// The JSON API reports the IDs assigned at ingest.
+	rec := httptest.NewRecorder()
+	messagesHandler(handler, testTemplates(t))(rec, httptest.NewRequest(http.MethodGet, "/messages?format=json", nil))
+	var listed []syslogMsg
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 3 || listed[0].ID != 3 || listed[2].ID != 5 {
+		t.Fatalf("expected IDs 3 to 5 in the buffer, got %+v", listed)
+	}
+
+	rec = get("/messages/4")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var got syslogMsg
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 4 || got.Message != "message 3" || got.Hostname != "host" {
+		t.Errorf("unexpected message %+v", got)
+	}
+	// Evicted and unknown IDs are not found.
+	for _, path := range []string{"/messages/1", "/messages/99"} {
+		if rec := get(path); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, rec.Code)
+		}
+	}
+	if rec := get("/messages/abc"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad id, got %d", rec.Code)
+	}
+
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rows), `id="msg-4"`) || !strings.Contains(string(rows), `<a href="/messages/4">4</a>`) {
+		t.Errorf("expected a row anchor and link for message 4 in %s", rows)
+	}
+}
+
+func TestStoredMessageParsedAtIngest(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<11>Jan 1 00:00:00 web-01 api: request failed", "10.0.0.1:514")
+	handler.logMessage("garbage", "10.0.0.2:514")
+
+	stored := handler.messages[0]
+	if stored.Malformed || stored.RemoteAddr != "10.0.0.1:514" {
+		t.Errorf("expected a parsed message from 10.0.0.1:514, got %+v", stored)
+	}
+	if stored.Msg.Hostname != "web-01" || stored.Msg.Appname != "api" || stored.Msg.Severity != 3 || stored.Msg.ID != 1 {
+		t.Errorf("unexpected parsed fields %+v", stored.Msg)
+	}
+	if malformed := handler.messages[1]; !malformed.Malformed || malformed.Raw != "garbage" || malformed.Msg.ID != 2 {
+		t.Errorf("expected the unparseable message to be flagged and keep its raw text, got %+v", malformed)
+	}
+
+	messages, err := filteredMessages(context.Background(), handler, messageOrder{}, messagePage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Message != "request failed" {
+		t.Errorf("expected only the parsed message to be listed, got %+v", messages)
+	}
+
+	handler.maxMsgLen = 40
+	handler.logMessage("<13>Jan 1 00:00:00 host app: "+strings.Repeat("x", 100), "10.0.0.1:514")
+	if body := handler.messages[2].Msg.Message; body != strings.Repeat("x", 40)+truncationMarker {
+		t.Errorf("expected the parsed body to be truncated too, got %q", body)
+	}
+}
+
+func TestParseTagPid(t *testing.T) {
+	tests := []struct {
+		raw, app, pid string
+	}{
+		{"<38>Jan 1 00:00:00 host sshd[1234]: Accepted publickey", "sshd", "1234"},
+		{"<38>Jan 1 00:00:00 host sshd: Accepted publickey", "sshd", ""},
+		{"<38>Jan 1 00:00:00 host sshd Accepted publickey", "sshd", ""},
+		{"<38>Jan 1 00:00:00 host [kernel]: oops", "[kernel]", ""},
+		{"<38>1 2024-01-01T00:00:00Z host sshd 1234 - - Accepted publickey", "sshd", "1234"},
+	}
+	for _, tt := range tests {
+		msg, err := parseSyslogMessage(tt.raw)
+		if err != nil {
+			t.Errorf("%q: %v", tt.raw, err)
+			continue
+		}
+		if msg.Appname != tt.app || msg.Pid != tt.pid || msg.Message != "Accepted publickey" && msg.Message != "oops" {
+			t.Errorf("%q: got app %q pid %q message %q, want %q %q", tt.raw, msg.Appname, msg.Pid, msg.Message, tt.app, tt.pid)
+		}
+	}
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage(tests[0].raw, "127.0.0.1:514")
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rows), "<td>sshd[1234]</td>") {
+		t.Errorf("expected the PID next to the app name:\n%s", rows)
+	}
+	rec := httptest.NewRecorder()
+	messagesHandler(handler, testTemplates(t))(rec, httptest.NewRequest(http.MethodGet, "/messages?format=json", nil))
+	if !strings.Contains(rec.Body.String(), `"appname":"sshd","pid":"1234"`) {
+		t.Errorf("expected the PID in the JSON, got %s", rec.Body.String())
+	}
+}
+
+func TestMinimalMessages(t *testing.T) {
+	tests := []struct {
+		raw      string
+		message  string
+		severity int
+	}{
+		{"<13>simple message", "simple message", 5},
+		{"<11>link down", "link down", 3},
+		{"<30>reboot", "reboot", 6},
+		{"<165> 2 words", "2 words", 5},
+		{"<13>Jan 1 00:00:00 short", "Jan 1 00:00:00 short", 5},
+	}
+	for _, tt := range tests {
+		stored := newStoredMessage(tt.raw, "10.0.0.1:514", time.Now(), false)
+		if stored.Malformed {
+			t.Errorf("%q: expected a minimal message to be kept", tt.raw)
+			continue
+		}
+		msg := stored.Msg
+		if msg.Message != tt.message || msg.Severity != tt.severity || msg.Hostname != "" || msg.Appname != "" {
+			t.Errorf("%q: got %+v, want message %q severity %d", tt.raw, msg, tt.message, tt.severity)
+		}
+		if strict := newStoredMessage(tt.raw, "10.0.0.1:514", time.Now(), true); !strict.Malformed {
+			t.Errorf("%q: expected strict parsing to reject it, got %+v", tt.raw, strict.Msg)
+		}
+	}
+
+	// Messages without a priority, or with nothing after it, are still malformed.
+	for _, raw := range []string{"no priority", "<13>", "<x>text"} {
+		if stored := newStoredMessage(raw, "10.0.0.1:514", time.Now(), false); !stored.Malformed {
+			t.Errorf("%q: expected a malformed message, got %+v", raw, stored.Msg)
+		}
+	}
+	// Full syslog messages parse the same either way.
+	full := "<13>Jan 1 00:00:00 host app: text"
+	if lenient, strict := newStoredMessage(full, "", time.Time{}, false), newStoredMessage(full, "", time.Time{}, true); lenient != strict || lenient.Msg.Hostname != "host" {
+		t.Errorf("expected full messages to parse the same, got %+v and %+v", lenient, strict)
+	}
+}
+
+func TestHostFromSource(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<13>link down", "192.0.2.7:514")
+	handler.getConfig().HostFromSource = true
+	handler.logMessage("<13>link down", "192.0.2.7:514")
+	handler.logMessage("<13>Jan 1 00:00:00 - app: no host", "192.0.2.8:514")
+	handler.logMessage("<13>Jan 1 00:00:00 router app: named", "192.0.2.9:514")
+
+	want := []string{"", "192.0.2.7", "192.0.2.8", "router"}
+	for i, w := range want {
+		if got := handler.messages[i].Msg.Hostname; got != w {
+			t.Errorf("message %d: hostname %q, want %q", i, got, w)
+		}
+	}
+
+	// The settings form turns the option off when the checkbox is cleared.
+	form := url.Values{"severity": {"7"}, "maxMessages": {"10"}}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	configHandler(handler)(rec, req)
+	if rec.Code != http.StatusOK || handler.getConfig().HostFromSource {
+		t.Errorf("expected the form to clear HostFromSource, got %d %v", rec.Code, handler.getConfig().HostFromSource)
+	}
+}
+
+func TestMessageSourceColumn(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<13>Jan 1 00:00:00 spoofed app: hello", "198.51.100.23:40514")
+	handler.logMessage("<13>Jan 1 00:00:00 router app: over ipv6", "[2001:db8::1]:514")
+
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<td>198.51.100.23</td>", "<td>2001:db8::1</td>"} {
+		if !strings.Contains(string(rows), want) {
+			t.Errorf("expected %s in the rendered rows:\n%s", want, rows)
+		}
+	}
+	if strings.Contains(string(rows), "40514") {
+		t.Errorf("expected the source port to be left out:\n%s", rows)
+	}
+}
+
+func TestShowMalformedOnly(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.strictParse = true
+	for _, input := range []string{
+		"<13>Jan 1 00:00:00 host app: valid message",
+		"no priority at all",
+		"<13>Jan 1 00:00:01 host app: another valid message",
+		"<11>link down",
+	} {
+		handler.logMessage(input, "192.0.2.1:514")
+	}
+
+	form := url.Values{"severity": {"7"}, "maxMessages": {"10"}, "showMalformedOnly": {"on"}}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	configHandler(handler)(rec, req)
+	if rec.Code != http.StatusOK || !handler.getConfig().ShowMalformedOnly {
+		t.Fatalf("expected the form to set ShowMalformedOnly, got %d", rec.Code)
+	}
+
+	messages, err := filteredMessages(context.Background(), handler, messageOrder{}, messagePage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected only the 2 malformed messages, got %+v", messages)
+	}
+	for i, want := range []struct {
+		message  string
+		severity int
+	}{{"no priority at all", 5}, {"<11>link down", 3}} {
+		got := messages[i]
+		if got.Message != want.message || got.Severity != want.severity || got.ParseError == "" || got.Source != "192.0.2.1" {
+			t.Errorf("message %d: got %+v, want %q at severity %d with a parse error", i, got, want.message, want.severity)
+		}
+	}
+
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(rows), "valid message") || !strings.Contains(string(rows), "Parse error: ") {
+		t.Errorf("expected only malformed rows with their parse errors:\n%s", rows)
+	}
+}
+
+func TestMalformedPolicyShow(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.strictParse = true
+	handler.showMalformed = true
+	handler.logMessage("<13>Jan 1 00:00:00 host app: valid message", "192.0.2.1:514")
+	handler.logMessage("<11>link down", "192.0.2.1:514")
+
+	messages, err := filteredMessages(context.Background(), handler, messageOrder{}, messagePage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[1].Message != "<11>link down" || messages[1].Severity != 3 || messages[1].ParseError == "" {
+		t.Fatalf("expected both messages, the malformed one raw with its parse error, got %+v", messages)
+	}
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rows), "valid message") || !strings.Contains(string(rows), "Parse error: ") {
+		t.Errorf("expected the valid and the malformed rows:\n%s", rows)
+	}
+}
+
+func TestMalformedPolicyDrop(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	logFile := filepath.Join(t.TempDir(), "syslog.log")
+	handler, err := createLogFileHandler(logFile, 10, upstream.LocalAddr().String(), "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.strictParse = true
+	handler.dropMalformed = true
+	handler.logMessage("<11>link down", "192.0.2.1:514")
+	handler.logMessage("<11>Jan 1 00:00:00 host app: valid message", "192.0.2.1:514")
+	handler.forwarder.close()
+	handler.logger.Close()
+
+	if len(handler.messages) != 1 || handler.messages[0].Malformed {
+		t.Errorf("expected only the valid message in memory, got %+v", handler.messages)
+	}
+	if got := readDatagrams(upstream); len(got) != 1 || strings.Contains(got[0], "link down") {
+		t.Errorf("expected only the valid message to be forwarded, got %q", got)
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "link down") || !strings.Contains(string(data), "valid message") {
+		t.Errorf("expected only the valid message in the log file, got %q", data)
+	}
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(rows), "link down") {
+		t.Errorf("expected no row for the dropped message:\n%s", rows)
+	}
+}
+
+func TestSettingsPageCounts(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{
+		"<34>Jan 1 00:00:00 host sshd: Failed password for root", // auth.crit
+		"<34>Jan 1 00:00:01 host sshd: Failed password for admin",
+		"<30>Jan 1 00:00:02 host systemd: Started session", // daemon.info
+		"<86>Jan 1 00:00:03 host sudo: session opened",     // authpriv.info
+		"not a syslog message",
+		"<-9>Jan 1 00:00:04 host app: negative priority",
+		"<192>Jan 1 00:00:05 host app: priority too large",
+	} {
+		handler.logMessage(input, "127.0.0.1:514")
+	}
+	for _, raw := range []string{"<-9>Jan 1 00:00:04 host app: x", "<192>Jan 1 00:00:05 host app: x"} {
+		if _, _, err := parsePriority(raw); err == nil {
+			t.Errorf("expected %q to be rejected", raw)
+		}
+	}
+	// Severities out of range are not counted, however they were stored.
+	handler.messages = append(handler.messages, storedMessage{Msg: syslogMsg{Facility: -2, Severity: -1}})
+
+	rec := httptest.NewRecorder()
+	renderPage(rec, "settings", testTemplates(t), handler)
+	page := rec.Body.String()
+	for _, row := range []string{
+		`<tr class="sev-emerg"><td>0</td><td>emerg</td><td>0</td></tr>`,
+		`<tr class="sev-crit"><td>2</td><td>crit</td><td>2</td></tr>`,
+		`<tr class="sev-info"><td>6</td><td>info</td><td>2</td></tr>`,
+		`<tr class="sev-debug"><td>7</td><td>debug</td><td>0</td></tr>`,
+		`<tr><td>3</td><td>daemon</td><td>1</td></tr>`,
+		`<tr><td>4</td><td>auth</td><td>2</td></tr>`,
+		`<tr><td>10</td><td>authpriv</td><td>1</td></tr>`,
+	} {
+		if !strings.Contains(page, row) {
+			t.Errorf("expected %s in the settings page", row)
+		}
+	}
+	if strings.Contains(page, "<td>kern</td>") {
+		t.Error("expected facilities without messages to be left out")
+	}
+}
+
+func TestTailHandler(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.config.MaxMessages = 5
+	mux := http.NewServeMux()
+	mux.HandleFunc("/messages/{id}", messageByIDHandler(handler))
+	mux.HandleFunc("/messages/tail", tailHandler(handler))
+	poll := func(path string) tailResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, rec.Code, rec.Body)
+		}
+		var resp tailResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	send := func(from, to int) {
+		for i := from; i < to; i++ {
+			handler.logMessage(fmt.Sprintf("<14>Jan 1 00:00:00 host app: message %d", i), "127.0.0.1:514")
+		}
+	}
+	bodies := func(resp tailResponse) string {
+		var got []string
+		for _, msg := range resp.Messages {
+			got = append(got, msg.Message)
+		}
+		return strings.Join(got, ",")
+	}
+
+	if resp := poll("/messages/tail"); len(resp.Messages) != 0 || resp.Cursor != 0 || resp.Reset {
+		t.Errorf("expected an empty tail, got %+v", resp)
+	}
+	send(0, 2)
+	resp := poll("/messages/tail?since=0")
+	if bodies(resp) != "message 0,message 1" || resp.Cursor != 2 || resp.Reset {
+		t.Errorf("first poll: got %q cursor %d reset %v", bodies(resp), resp.Cursor, resp.Reset)
+	}
+	send(2, 4)
+	resp = poll(fmt.Sprintf("/messages/tail?since=%d", resp.Cursor))
+	if bodies(resp) != "message 2,message 3" || resp.Cursor != 4 || resp.Reset {
+		t.Errorf("second poll: got %q cursor %d reset %v", bodies(resp), resp.Cursor, resp.Reset)
+	}
+	resp = poll(fmt.Sprintf("/messages/tail?since=%d", resp.Cursor))
+	if len(resp.Messages) != 0 || resp.Cursor != 4 {
+		t.Errorf("expected nothing new, got %q cursor %d", bodies(resp), resp.Cursor)
+	}
+
+	// Messages 5 and 6 are evicted before the client polls again.
+	send(4, 11)
+	resp = poll("/messages/tail?since=4")
+	if !resp.Reset || bodies(resp) != "message 6,message 7,message 8,message 9,message 10" || resp.Cursor != 11 {
+		t.Errorf("expected a reset after eviction, got %q cursor %d reset %v", bodies(resp), resp.Cursor, resp.Reset)
+	}
+	// A cursor from before a restart is ahead of the buffer.
+	if resp := poll("/messages/tail?since=100"); !resp.Reset || len(resp.Messages) != 5 {
+		t.Errorf("expected the whole buffer for a cursor ahead of it, got %d messages reset %v", len(resp.Messages), resp.Reset)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages/tail?since=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad cursor, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,311 @@
+package syslog_server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"syslog/syslog_client"
+)
+
+// defaultMaxMessageSize is the default limit on the size of a single TCP
+// message.
+const defaultMaxMessageSize = 1 << 20
+
+// errFrameTooLong is returned by readFrame for a message larger than the
+// limit. The message is skipped and the connection stays usable.
+var errFrameTooLong = errors.New("message exceeds the maximum size")
+
+// tcpListener receives syslog messages over TCP. Each message is framed
+// either with octet counting or with a trailing LF (RFC 6587), or binary
+// encoded by the client's -framing binary; the framing is detected per
+// message. When conns is set, at most cap(conns) clients
+// are served at once and further connections are closed as soon as they
+// are accepted, so clients cannot exhaust the file descriptors. When
+// idleTimeout is set, connections that send nothing for that long are
+// closed too.
+type tcpListener struct {
+	ln             net.Listener
+	handler        *logFileHandler
+	maxMessageSize int
+	idleTimeout    time.Duration
+	ack            bool
+	conns          chan struct{}
+	active         connSet
+	wg             sync.WaitGroup
+}
+
+// connSet tracks the connections accepted by a listener, so that closing
+// the listener also closes them and waits for their goroutines, which may
+// be logging a message.
+type connSet struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// serve runs serve for conn in a new goroutine. It must not be called once
+// close was.
+func (s *connSet) serve(conn net.Conn, serve func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			s.wg.Done()
+		}()
+		serve()
+	}()
+}
+
+// close closes the connections and waits for their goroutines to return.
+func (s *connSet) close() {
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// listenTCP accepts connections on addr, serving at most maxConns at once
+// (0 for no limit). backlog sets the queue of connections not yet accepted
+// (0 for the system default), and connections idle for idleTimeout are
+// closed (0 to keep them open). Messages larger than maxMessageSize bytes
+// are dropped. With ack set, "ack\n" is written back once each message has
+// been logged, for clients that want at-least-once delivery. With tlsConfig
+// set the connections use TLS, as described in RFC 5425.
+func listenTCP(addr string, maxMessageSize, maxConns, backlog int, idleTimeout time.Duration, ack bool, tlsConfig *tls.Config, handler *logFileHandler) (*tcpListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if backlog > 0 {
+		if err := setBacklog(ln.(*net.TCPListener), backlog); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("error setting the listen backlog: %w", err)
+		}
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	tl := &tcpListener{ln: ln, handler: handler, maxMessageSize: maxMessageSize, idleTimeout: idleTimeout, ack: ack}
+	if maxConns > 0 {
+		tl.conns = make(chan struct{}, maxConns)
+	}
+	tl.wg.Add(1)
+	go tl.acceptLoop()
+	return tl, nil
+}
+
+// setBacklog sets the length of the queue of connections the kernel has
+// completed but the server has not accepted yet, by calling listen again on
+// the socket, which Linux and the BSDs allow. Go listens with the system
+// maximum; Linux caps the backlog at net.core.somaxconn.
+func setBacklog(ln *net.TCPListener, backlog int) error {
+	raw, err := ln.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	err = raw.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
+
+// idleReader reads from conn, failing with a timeout error once no data has
+// arrived for timeout.
+type idleReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+	return r.conn.Read(p)
+}
+
+func (tl *tcpListener) acceptLoop() {
+	defer tl.wg.Done()
+	for {
+		conn, err := tl.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Error("Error accepting TCP connection", "error", err)
+			continue
+		}
+		if tl.conns != nil {
+			select {
+			case tl.conns <- struct{}{}:
+			default:
+				if tl.handler.tcpRefused.Add(1) == 1 {
+					slog.Warn("Refusing TCP connection, counting further refusals in /metrics", "remote_addr", conn.RemoteAddr(), "connections", cap(tl.conns))
+				}
+				conn.Close()
+				continue
+			}
+		}
+		tl.handler.tcpConnections.Add(1)
+		tl.active.serve(conn, func() { tl.handleConn(conn) })
+	}
+}
+
+func (tl *tcpListener) handleConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		tl.handler.tcpConnections.Add(-1)
+		if tl.conns != nil {
+			<-tl.conns
+		}
+	}()
+	remoteAddr := conn.RemoteAddr().String()
+	var input io.Reader = conn
+	if tl.idleTimeout > 0 {
+		input = idleReader{conn: conn, timeout: tl.idleTimeout}
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		name, err := handshake(tlsConn)
+		if err != nil {
+			slog.Warn("TLS handshake failed", "remote_addr", remoteAddr, "error", err)
+			return
+		}
+		// The certificate identifies the sender more reliably than the
+		// host name in its messages, which it can set to anything.
+		if name != "" {
+			tl.handler.peerNames.Store(remoteAddr, name)
+			defer tl.handler.peerNames.Delete(remoteAddr)
+		}
+	}
+	reader := bufio.NewReader(input)
+	for {
+		message, err := readFrame(reader, tl.maxMessageSize)
+		if message = strings.TrimSpace(message); message != "" {
+			tl.handler.logMessage(message, remoteAddr)
+			// Empty frames are not logged, so they are not acked either.
+			if tl.ack {
+				if _, err := conn.Write([]byte("ack\n")); err != nil {
+					slog.Error("Error sending ack", "remote_addr", remoteAddr, "error", err)
+					return
+				}
+			}
+		}
+		if errors.Is(err, errFrameTooLong) {
+			slog.Warn("Dropped TCP message", "remote_addr", remoteAddr, "error", err)
+			continue
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			slog.Info("Closing idle TCP connection", "remote_addr", remoteAddr, "idle", tl.idleTimeout)
+			return
+		}
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				slog.Error("Error reading TCP message", "remote_addr", remoteAddr, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// readFrame reads one message of at most maxSize bytes. A frame starting
+// with a digit is octet counted ("<length> <message>"), one starting with
+// syslog_client.BinaryMagic is binary encoded and converted to text, and
+// anything else is terminated by LF.
+func readFrame(r *bufio.Reader, maxSize int) (string, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] == syslog_client.BinaryMagic {
+		m, err := syslog_client.ReadBinary(r, maxSize)
+		if errors.Is(err, syslog_client.ErrBinaryTooLarge) {
+			return "", errFrameTooLong
+		}
+		if err != nil {
+			return "", err
+		}
+		return m.Text(), nil
+	}
+	if first[0] < '0' || first[0] > '9' {
+		return readLine(r, maxSize)
+	}
+
+	lengthStr, err := r.ReadString(' ')
+	if err != nil {
+		return "", fmt.Errorf("incomplete octet count: %w", err)
+	}
+	length, err := strconv.Atoi(strings.TrimSuffix(lengthStr, " "))
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("invalid octet count %q", lengthStr)
+	}
+	if length > maxSize {
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return "", err
+		}
+		return "", errFrameTooLong
+	}
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return "", fmt.Errorf("truncated frame: %w", err)
+	}
+	return string(frame), nil
+}
+
+// readLine reads up to and including the next LF. Unlike bufio.Scanner it
+// is not limited by the reader's buffer size; lines longer than maxSize are
+// consumed and reported as errFrameTooLong.
+func readLine(r *bufio.Reader, maxSize int) (string, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			// The limit applies to the message without its LF.
+			tooLong = len(bytes.TrimSuffix(line, []byte("\n"))) > maxSize
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if tooLong {
+			if err != nil {
+				return "", err
+			}
+			return "", errFrameTooLong
+		}
+		return string(line), err
+	}
+}
+
+// close stops accepting connections, then closes the open ones and waits
+// until their messages have been logged.
+func (tl *tcpListener) close() {
+	tl.ln.Close()
+	tl.wg.Wait()
+	tl.active.close()
+}
@@ -0,0 +1,331 @@
+package syslog_server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"syslog/syslog_client"
+)
+
+func TestReadFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("11 hello\nworld<13>plain line\n"))
+	frame, err := readFrame(r, 1024)
+	if err != nil || frame != "hello\nworld" {
+		t.Errorf("expected octet-counted frame, got %q (%v)", frame, err)
+	}
+	frame, err = readFrame(r, 1024)
+	if err != nil || frame != "<13>plain line\n" {
+		t.Errorf("expected LF-terminated frame, got %q (%v)", frame, err)
+	}
+
+	r = bufio.NewReader(strings.NewReader("too long line\n12 octet frame!short\n"))
+	if _, err := readFrame(r, 5); !errors.Is(err, errFrameTooLong) {
+		t.Errorf("expected errFrameTooLong for a long line, got %v", err)
+	}
+	if _, err := readFrame(r, 5); !errors.Is(err, errFrameTooLong) {
+		t.Errorf("expected errFrameTooLong for a long octet-counted frame, got %v", err)
+	}
+	if frame, err := readFrame(r, 5); err != nil || frame != "short\n" {
+		t.Errorf("expected the reader to resume after oversized frames, got %q (%v)", frame, err)
+	}
+}
+
+func TestTCPOctetCountingRoundTrip(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := listenTCP("127.0.0.1:0", defaultMaxMessageSize, 0, 0, 0, false, nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.close()
+
+	client, err := syslog_client.Dial("tcp", tl.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Framing = "octet"
+	want := "<13>Jan 1 00:00:00 host app: first line\nsecond line"
+	if err := client.SendRaw(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendRaw("<13>Jan 1 00:00:01 host app: next"); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	messages := waitForMessages(t, handler, 2)
+	if messages[0] != want {
+		t.Errorf("expected embedded newline to survive, got %q", messages[0])
+	}
+	if messages[1] != "<13>Jan 1 00:00:01 host app: next" {
+		t.Errorf("unexpected second message %q", messages[1])
+	}
+}
+
+func TestTCPBinaryRoundTrip(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := listenTCP("127.0.0.1:0", defaultMaxMessageSize, 0, 0, 0, false, nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.close()
+
+	client, err := syslog_client.Dial("tcp", tl.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Framing = "binary"
+	client.Timestamp = time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	if err := client.Send(3, 3, "web-01", "nginx", "upstream timed out\nretrying"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendRaw("<13>Jan 1 00:00:01 host app: raw line"); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	messages := waitForMessages(t, handler, 2)
+	if want := "<27>1 2024-01-02T03:04:05.123456789Z web-01 nginx - - - upstream timed out\nretrying"; messages[0] != want {
+		t.Errorf("got %q, want %q", messages[0], want)
+	}
+	if messages[1] != "<13>Jan 1 00:00:01 host app: raw line" {
+		t.Errorf("expected the raw message unchanged, got %q", messages[1])
+	}
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if msg := handler.messages[0].Msg; msg.Hostname != "web-01" || msg.Appname != "nginx" || msg.Severity != 3 || msg.Timestamp != "2024-01-02T03:04:05.123456789Z" {
+		t.Errorf("unexpected parsed message %+v", msg)
+	}
+}
+
+func TestTCPLongMessage(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := listenTCP("127.0.0.1:0", 200*1024, 0, 0, 0, false, nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.close()
+
+	conn, err := net.Dial("tcp", tl.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<13>Jan 1 00:00:00 host app: " + strings.Repeat("x", 100*1024)
+	if _, err := conn.Write([]byte(want + "\n<13>Jan 1 00:00:01 host app: next\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	messages := waitForMessages(t, handler, 2)
+	if messages[0] != want {
+		t.Errorf("expected the 100KB message whole, got %d bytes", len(messages[0]))
+	}
+}
+
+func TestTCPAckRoundTrip(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := listenTCP("127.0.0.1:0", defaultMaxMessageSize, 0, 0, 0, true, nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.close()
+
+	client, err := syslog_client.Dial("tcp", tl.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Ack = true
+	for _, framing := range []string{"lf", "octet"} {
+		client.Framing = framing
+		if err := client.SendRaw("<13>Jan 1 00:00:00 host app: acked " + framing); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Acks are only sent after logging, so no waiting is needed.
+	messages := rawMessages(handler.messages)
+	if len(messages) != 2 || messages[1] != "<13>Jan 1 00:00:00 host app: acked octet" {
+		t.Errorf("expected both messages to be logged before the ack, got %q", messages)
+	}
+
+	// Blank frames are not logged, so they are not acked either and the acks
+	// stay paired with the messages.
+	conn, err := net.Dial("tcp", tl.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("\n   \n<13>Jan 1 00:00:00 host app: after blanks\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	acks, _ := io.ReadAll(conn)
+	if string(acks) != "ack\n" {
+		t.Errorf("expected one ack for one logged message, got %q", acks)
+	}
+}
+
+func TestTCPMaxConnections(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := listenTCP("127.0.0.1:0", defaultMaxMessageSize, 2, 0, 0, false, nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.close()
+	addr := tl.ln.Addr().String()
+
+	var open []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "<13>Jan 1 00:00:00 host app: connection %d\n", i)
+		open = append(open, conn)
+	}
+	// Both connections are being served once their messages are logged.
+	waitForMessages(t, handler, 2)
+
+	refused, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refused.Close()
+	refused.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := refused.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the connection over the limit to be closed, got %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	metricsHandler(handler)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{"syslog_tcp_connections 2\n", "syslog_tcp_connections_refused_total 1\n"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected %q in the metrics:\n%s", want, rec.Body.String())
+		}
+	}
+
+	// Closing a connection makes room for a new one.
+	open[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for handler.tcpConnections.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "<13>Jan 1 00:00:00 host app: after close\n")
+	if messages := waitForMessages(t, handler, 3); messages[2] != "<13>Jan 1 00:00:00 host app: after close" {
+		t.Errorf("expected the new connection to be served, got %q", messages)
+	}
+}
+
+func TestTCPIdleTimeout(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const idle = 200 * time.Millisecond
+	tl, err := listenTCP("127.0.0.1:0", defaultMaxMessageSize, 0, 16, idle, false, nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.close()
+	addr := tl.ln.Addr().String()
+
+	idleConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idleConn.Close()
+	active, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+
+	// A connection that keeps sending stays open past the timeout.
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(active, "<13>Jan 1 00:00:00 host app: message %d\n", i)
+		time.Sleep(idle / 2)
+	}
+	waitForMessages(t, handler, 5)
+
+	idleConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := idleConn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the idle connection to be closed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < idle {
+		t.Errorf("expected the idle connection to be closed after %v, got %v", idle, elapsed)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for handler.tcpConnections.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := handler.tcpConnections.Load(); n != 1 {
+		t.Errorf("expected only the active connection to remain, got %d", n)
+	}
+
+	// The active connection is closed too once it stops sending.
+	active.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := active.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the connection to be closed once idle, got %v", err)
+	}
+}
+
+func TestTCPCloseWaitsForConnections(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := listenTCP("127.0.0.1:0", defaultMaxMessageSize, 0, 0, 0, false, nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", tl.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("<13>Jan 1 00:00:00 host app: still connected\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitForMessages(t, handler, 1)
+
+	// The open connection is closed, and its goroutine has returned, so it
+	// cannot log a message once the forwarders are closed.
+	tl.close()
+	if n := handler.tcpConnections.Load(); n != 0 {
+		t.Errorf("expected no open connections after close, got %d", n)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the server to close the connection, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package syslog_server
+
+import (
+	"fmt"
+	"time"
+)
+
+// displayTimeLayout is how timestamps are shown when a display timezone is
+// set. Unlike the RFC 3164 format it includes the year and the zone.
+const displayTimeLayout = "2006-01-02 15:04:05 MST"
+
+// loadTimezone loads a timezone for the -tz and -sourcetz options: an IANA
+// name such as "Europe/Paris", "UTC" or "Local". An empty name is Local.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// displayTimestamp converts an RFC 3164 or RFC 5424 timestamp to the display
+// timezone. Timestamps that do not parse are returned unchanged.
+func displayTimestamp(timestamp string, source, display *time.Location, now time.Time) string {
+	t, ok := resolveTimestamp(timestamp, source, now)
+	if !ok {
+		return timestamp
+	}
+	return t.In(display).Format(displayTimeLayout)
+}
+
+// resolveTimestamp parses an RFC 3164 or RFC 5424 timestamp. RFC 3164
+// timestamps have no zone or year, so they are taken to be in source and in
+// the year that puts them no more than a day after now.
+func resolveTimestamp(timestamp string, source *time.Location, now time.Time) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err == nil {
+		return t, true
+	}
+	if t, err = time.ParseInLocation("Jan 2 15:04:05", timestamp, source); err != nil {
+		return time.Time{}, false
+	}
+	t = t.AddDate(now.In(source).Year()-t.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, true
+}
+
+// sourceLocation returns the zone of the -sourcetz option, which can be
+// changed on the settings page.
+func (lh *logFileHandler) sourceLocation() *time.Location {
+	source, err := loadTimezone(lh.getConfig().SourceTimezone)
+	if err != nil {
+		return time.Local
+	}
+	return source
+}
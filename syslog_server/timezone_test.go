@@ -0,0 +1,76 @@
+package syslog_server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDisplayTimestamp(t *testing.T) {
+	newYork, err := loadTimezone("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		timestamp, want string
+	}{
+		// RFC 3164 timestamps are in the source zone, EDT in June.
+		{"Jun 15 08:00:00", "2024-06-15 12:00:00 UTC"},
+		// A December timestamp in June is from last year.
+		{"Dec 31 19:30:00", "2024-01-01 00:30:00 UTC"},
+		// RFC 5424 timestamps carry their own offset.
+		{"2024-06-15T14:00:00.123+02:00", "2024-06-15 12:00:00 UTC"},
+		{"not a time", "not a time"},
+	}
+	for _, tt := range tests {
+		if got := displayTimestamp(tt.timestamp, newYork, time.UTC, now); got != tt.want {
+			t.Errorf("displayTimestamp(%q) = %q, want %q", tt.timestamp, got, tt.want)
+		}
+	}
+	if _, err := loadTimezone("Mars/Olympus_Mons"); err == nil {
+		t.Error("expected an error for an unknown timezone")
+	}
+}
+
+func TestRenderMessageRowsTimezone(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.logMessage("<13>1 2024-01-10T12:00:00Z host app - - - from UTC", "127.0.0.1:514")
+
+	form := url.Values{"severity": {"7"}, "maxMessages": {"10"}, "displayTimezone": {"Asia/Tokyo"}}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	configHandler(handler)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the timezone to be accepted, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rows), "<td>2024-01-10 21:00:00 JST</td>") {
+		t.Errorf("expected the timestamp in Tokyo time:\n%s", rows)
+	}
+	// The API keeps the timestamp as received.
+	if got := handler.messages[0].Msg.Timestamp; got != "2024-01-10T12:00:00Z" {
+		t.Errorf("expected the stored timestamp to be unchanged, got %q", got)
+	}
+
+	form.Set("displayTimezone", "Nowhere/Special")
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	configHandler(handler)(rec, req)
+	if rec.Code != http.StatusBadRequest || handler.getConfig().DisplayTimezone != "Asia/Tokyo" {
+		t.Errorf("expected an unknown timezone to be rejected, got %d", rec.Code)
+	}
+}
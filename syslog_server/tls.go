@@ -0,0 +1,55 @@
+package syslog_server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the TLS handshake of a new connection, so a
+// client that never completes it does not hold a connection slot.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// loadTLSConfig loads the server certificate for the TLS listener. With a
+// client CA bundle, clients must present a certificate signed by one of its
+// CAs (mutual TLS).
+func loadTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("a TLS listener needs -tlscert and -tlskey")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// handshake completes the TLS handshake of conn and returns the common name
+// of the verified client certificate, if the client presented one.
+func handshake(conn *tls.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		return certs[0].Subject.CommonName, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,143 @@
+package syslog_server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert issues a certificate for name, signed by parent or self-signed
+// when parent is nil.
+func testCert(t *testing.T, name string, isCA bool, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, any(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM writes the certificate, and its key unless certOnly, to files in
+// dir and returns their paths.
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSClientCertificateName(t *testing.T) {
+	dir := t.TempDir()
+	ca := testCert(t, "Test CA", true, nil)
+	server := testCert(t, "syslog.example.com", false, &ca)
+	client := testCert(t, "web-01.example.com", false, &ca)
+	caFile, _ := writePEM(t, dir, "ca", ca)
+	certFile, keyFile := writePEM(t, dir, "server", server)
+
+	tlsConfig, err := loadTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := listenTCP("127.0.0.1:0", defaultMaxMessageSize, 0, 0, 0, false, tlsConfig, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	conn, err := tls.Dial("tcp", tl.ln.Addr().String(), &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The claimed host name differs from the certificate.
+	if _, err := conn.Write([]byte("<13>Jan 1 00:00:00 db-01 app: over TLS\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitForMessages(t, handler, 1)
+	conn.Close()
+	handler.mu.Lock()
+	msg := handler.messages[0].Msg
+	handler.mu.Unlock()
+	if msg.ClientCert != "web-01.example.com" || msg.Hostname != "db-01" {
+		t.Errorf("expected the certificate name next to the claimed host name, got %+v", msg)
+	}
+	rows, err := renderMessageRows(context.Background(), handler, testTemplates(t), messageOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rows), "cert: web-01.example.com") {
+		t.Errorf("expected the certificate name in the UI, got %s", rows)
+	}
+
+	// Without a client certificate the handshake fails and nothing is logged.
+	anonymous, err := tls.Dial("tcp", tl.ln.Addr().String(), &tls.Config{RootCAs: roots})
+	if err == nil {
+		anonymous.Write([]byte("<13>Jan 1 00:00:01 db-01 app: anonymous\n"))
+		_, err = anonymous.Read(make([]byte, 1))
+		anonymous.Close()
+	}
+	if err == nil {
+		t.Error("expected a client without a certificate to be rejected")
+	}
+	time.Sleep(50 * time.Millisecond)
+	handler.mu.Lock()
+	n := len(handler.messages)
+	handler.mu.Unlock()
+	if n != 1 {
+		t.Errorf("expected only the authenticated message, got %d", n)
+	}
+
+	if _, err := loadTLSConfig(certFile, "", ""); err == nil {
+		t.Error("expected an error without a key")
+	}
+}
@@ -0,0 +1,119 @@
+package syslog_server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// unixListener receives syslog messages on a Unix domain socket such as
+// /dev/log. Closing it stops the receiver and removes the socket file.
+type unixListener struct {
+	path   string
+	closer io.Closer
+	active connSet
+	wg     sync.WaitGroup
+}
+
+// listenUnix listens on path using proto "unixgram" (datagram, like
+// /dev/log) or "unix" (stream, one message per line) and feeds received
+// messages to handler. A stale socket file left by a previous run is removed.
+func listenUnix(path, proto string, mode os.FileMode, handler *logFileHandler) (*unixListener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	ul := &unixListener{path: path}
+	switch proto {
+	case "unixgram":
+		conn, err := net.ListenUnixgram(proto, &net.UnixAddr{Name: path, Net: proto})
+		if err != nil {
+			return nil, err
+		}
+		ul.closer = conn
+		ul.wg.Add(1)
+		go func() {
+			defer ul.wg.Done()
+			receiveUnixgram(conn, handler)
+		}()
+	case "unix":
+		ln, err := net.ListenUnix(proto, &net.UnixAddr{Name: path, Net: proto})
+		if err != nil {
+			return nil, err
+		}
+		ul.closer = ln
+		ul.wg.Add(1)
+		go func() {
+			defer ul.wg.Done()
+			ul.acceptUnix(ln, handler)
+		}()
+	default:
+		return nil, fmt.Errorf("unsupported unix socket type %q, use 'unixgram' or 'unix'", proto)
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		ul.close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ul, nil
+}
+
+func receiveUnixgram(conn *net.UnixConn, handler *logFileHandler) {
+	buffer := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFromUnix(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Error("Error reading unix socket message", "error", err)
+			continue
+		}
+		message := strings.TrimSpace(string(buffer[:n]))
+		handler.logMessage(message, conn.LocalAddr().String())
+	}
+}
+
+func (ul *unixListener) acceptUnix(ln *net.UnixListener, handler *logFileHandler) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Error("Error accepting unix socket connection", "error", err)
+			continue
+		}
+		ul.active.serve(conn, func() {
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				if message := strings.TrimSpace(scanner.Text()); message != "" {
+					handler.logMessage(message, ln.Addr().String())
+				}
+			}
+		})
+	}
+}
+
+// close stops receiving, closes the open stream connections and waits
+// until their messages have been logged, then removes the socket file.
+func (ul *unixListener) close() {
+	ul.closer.Close()
+	ul.wg.Wait()
+	ul.active.close()
+	if err := os.Remove(ul.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("Error removing unix socket", "path", ul.path, "error", err)
+	}
+}
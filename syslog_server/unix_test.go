@@ -0,0 +1,82 @@
+package syslog_server
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitForMessages(t *testing.T, handler *logFileHandler, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		handler.mu.Lock()
+		messages := rawMessages(handler.messages)
+		handler.mu.Unlock()
+		if len(messages) >= n {
+			return messages
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d messages", n)
+	return nil
+}
+
+func TestUnixSocketInput(t *testing.T) {
+	for _, proto := range []string{"unixgram", "unix"} {
+		t.Run(proto, func(t *testing.T) {
+			handler, err := createLogFileHandler("", 10, "", "udp", 6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "log.sock")
+			ul, err := listenUnix(path, proto, 0660, handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0660 {
+				t.Errorf("expected socket with mode 0660, got %v (%v)", fi.Mode(), err)
+			}
+
+			conn, err := net.Dial(proto, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.Write([]byte("<13>Jan 1 00:00:00 host app: via unix socket\n")); err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+
+			messages := waitForMessages(t, handler, 1)
+			if messages[0] != "<13>Jan 1 00:00:00 host app: via unix socket" {
+				t.Errorf("unexpected message %q", messages[0])
+			}
+
+			if proto == "unix" {
+				// Open stream connections are closed with the listener.
+				open, err := net.Dial(proto, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer open.Close()
+				if _, err := open.Write([]byte("<13>Jan 1 00:00:01 host app: still connected\n")); err != nil {
+					t.Fatal(err)
+				}
+				waitForMessages(t, handler, 2)
+				ul.close()
+				open.SetReadDeadline(time.Now().Add(2 * time.Second))
+				if _, err := open.Read(make([]byte, 1)); err != io.EOF {
+					t.Errorf("expected the connection to be closed, got %v", err)
+				}
+			} else {
+				ul.close()
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("expected socket file to be removed on close, got %v", err)
+			}
+		})
+	}
+}
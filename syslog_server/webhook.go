@@ -0,0 +1,122 @@
+package syslog_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"syslog/syslog_anomaly"
+)
+
+// anomalyNotifier POSTs newly found anomalies to a webhook in the background.
+// An anomaly, identified by its message, is not sent again until debounce
+// has passed since it was last sent.
+type anomalyNotifier struct {
+	url        string
+	debounce   time.Duration
+	client     *http.Client
+	maxRetries int
+	retryDelay time.Duration
+	mu         sync.Mutex
+	sent       map[string]time.Time
+	queue      chan anomalyWebhookPayload
+	wg         sync.WaitGroup
+}
+
+// anomalyWebhookPayload is the JSON body posted to the webhook.
+type anomalyWebhookPayload struct {
+	Count      int                      `json:"count"`
+	DetectedAt time.Time                `json:"detectedAt"`
+	Anomalies  []syslog_anomaly.Anomaly `json:"anomalies"`
+}
+
+func newAnomalyNotifier(url string, debounce time.Duration) *anomalyNotifier {
+	n := &anomalyNotifier{
+		url:        url,
+		debounce:   debounce,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		maxRetries: 3,
+		retryDelay: time.Second,
+		sent:       map[string]time.Time{},
+		queue:      make(chan anomalyWebhookPayload, 100),
+	}
+	n.wg.Add(1)
+	go n.run()
+	return n
+}
+
+// notify queues the anomalies that were not sent within the debounce window.
+func (n *anomalyNotifier) notify(anomalies []syslog_anomaly.Anomaly) {
+	now := time.Now()
+	var fresh []syslog_anomaly.Anomaly
+	n.mu.Lock()
+	for message, sentAt := range n.sent {
+		if now.Sub(sentAt) >= n.debounce {
+			delete(n.sent, message)
+		}
+	}
+	for _, anomaly := range anomalies {
+		if _, ok := n.sent[anomaly.Message]; ok {
+			continue
+		}
+		n.sent[anomaly.Message] = now
+		fresh = append(fresh, anomaly)
+	}
+	n.mu.Unlock()
+	if len(fresh) == 0 {
+		return
+	}
+	select {
+	case n.queue <- anomalyWebhookPayload{Count: len(fresh), DetectedAt: now.UTC(), Anomalies: fresh}:
+	default:
+		slog.Warn("Anomaly webhook queue full, dropping anomalies", "count", len(fresh))
+	}
+}
+
+func (n *anomalyNotifier) run() {
+	defer n.wg.Done()
+	for payload := range n.queue {
+		if err := n.post(payload); err != nil {
+			slog.Error("Error sending anomalies to webhook", "error", err)
+		}
+	}
+}
+
+// post sends payload, retrying on network errors and 5xx responses.
+func (n *anomalyNotifier) post(payload anomalyWebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 0; attempt < n.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(n.retryDelay * time.Duration(attempt))
+		}
+		resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("webhook returned %s", resp.Status)
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// close sends the queued anomalies and stops the notifier.
+func (n *anomalyNotifier) close() {
+	close(n.queue)
+	n.wg.Wait()
+}
@@ -0,0 +1,98 @@
+package syslog_server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"syslog/syslog_anomaly"
+)
+
+func TestAnomalyWebhook(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(syslog_anomaly.CompletionResponse{
+			Choices: []syslog_anomaly.Choice{{Message: syslog_anomaly.Message{Content: `[
+				{"message": "Jan 1 00:00:02 db-01 kernel: disk failure", "reason": "hardware", "severity": "critical"}
+			]`}}},
+		})
+	}))
+	defer llm.Close()
+
+	var mu sync.Mutex
+	var payloads []anomalyWebhookPayload
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		var payload anomalyWebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		payloads = append(payloads, payload)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := handler.getConfig()
+	config.ApiKey = "test"
+	config.Url = llm.URL
+	config.AnomaliesOnly = true
+	handler.notifier = newAnomalyNotifier(webhook.URL, time.Hour)
+
+	// The same anomaly is found twice but only sent once.
+	for i := 0; i < 2; i++ {
+		handler.logMessage("<11>Jan 1 00:00:02 db-01 kernel: disk failure", "127.0.0.1:514")
+		if _, err := filteredMessages(context.Background(), handler, messageOrder{}, messagePage{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler.notifier.close()
+
+	if len(payloads) != 1 {
+		t.Fatalf("expected 1 webhook call, got %d: %+v", len(payloads), payloads)
+	}
+	got := payloads[0]
+	if got.Count != 1 || len(got.Anomalies) != 1 || got.DetectedAt.IsZero() {
+		t.Fatalf("unexpected payload %+v", got)
+	}
+	want := syslog_anomaly.Anomaly{Message: "Jan 1 00:00:02 db-01 kernel: disk failure", Reason: "hardware", Severity: "critical"}
+	if got.Anomalies[0] != want {
+		t.Errorf("got anomaly %+v, want %+v", got.Anomalies[0], want)
+	}
+}
+
+func TestAnomalyNotifierDebounceExpires(t *testing.T) {
+	calls := make(chan anomalyWebhookPayload, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload anomalyWebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		calls <- payload
+	}))
+	defer webhook.Close()
+
+	n := newAnomalyNotifier(webhook.URL, 50*time.Millisecond)
+	anomaly := syslog_anomaly.Anomaly{Message: "disk failure", Severity: "high"}
+	n.notify([]syslog_anomaly.Anomaly{anomaly})
+	n.notify([]syslog_anomaly.Anomaly{anomaly})
+	time.Sleep(100 * time.Millisecond)
+	n.notify([]syslog_anomaly.Anomaly{anomaly, {Message: "new one"}})
+	n.close()
+	close(calls)
+
+	var counts []int
+	for payload := range calls {
+		counts = append(counts, payload.Count)
+	}
+	if len(counts) != 2 || counts[0] != 1 || counts[1] != 2 {
+		t.Errorf("expected the anomaly to be sent again after the debounce window, got counts %v", counts)
+	}
+}
@@ -0,0 +1,159 @@
+package syslog_server
+
+import (
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// queueWarningInterval limits how often a deep queue is logged.
+const queueWarningInterval = 10 * time.Second
+
+// datagram is a raw message waiting to be processed by a worker.
+type datagram struct {
+	message    string
+	remoteAddr string
+}
+
+// workerPool processes messages on a fixed number of goroutines so that the
+// UDP read loop never waits on disk writes or forwarding. Each worker has its
+// own bounded queue and messages are assigned by source IP, so messages from
+// one source are still logged in the order they arrived. Messages are dropped
+// when the source's queue is full, and a warning is logged when a queue holds
+// warnDepth messages or more.
+type workerPool struct {
+	handler     *logFileHandler
+	queues      []chan datagram
+	warnDepth   int
+	dropped     atomic.Uint64
+	highWater   atomic.Int64
+	lastWarning atomic.Int64
+	wg          sync.WaitGroup
+}
+
+func newWorkerPool(handler *logFileHandler, workers, queueSize, warnDepth int) *workerPool {
+	wp := &workerPool{handler: handler, warnDepth: warnDepth}
+	for i := 0; i < workers; i++ {
+		queue := make(chan datagram, queueSize)
+		wp.queues = append(wp.queues, queue)
+		wp.wg.Add(1)
+		go wp.run(queue)
+	}
+	return wp
+}
+
+func (wp *workerPool) run(queue chan datagram) {
+	defer wp.wg.Done()
+	for d := range queue {
+		wp.handler.logMessage(d.message, d.remoteAddr)
+	}
+}
+
+// submit queues message on the worker that owns the sender's IP.
+func (wp *workerPool) submit(message, remoteAddr string) {
+	h := fnv.New32a()
+	h.Write([]byte(sourceIP(remoteAddr)))
+	queue := wp.queues[h.Sum32()%uint32(len(wp.queues))]
+	select {
+	case queue <- datagram{message, remoteAddr}:
+		wp.observe(len(queue))
+	default:
+		wp.dropped.Add(1)
+	}
+}
+
+// observe records the depth of a worker queue after a message was added,
+// updating the high-water mark and warning when the queue is close to full.
+func (wp *workerPool) observe(depth int) {
+	for {
+		highWater := wp.highWater.Load()
+		if int64(depth) <= highWater || wp.highWater.CompareAndSwap(highWater, int64(depth)) {
+			break
+		}
+	}
+	if wp.warnDepth <= 0 || depth < wp.warnDepth {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := wp.lastWarning.Load()
+	if now-last < int64(queueWarningInterval) || !wp.lastWarning.CompareAndSwap(last, now) {
+		return
+	}
+	slog.Warn("Worker queue is filling up, messages will be dropped if it is full", "depth", depth, "warn_depth", wp.warnDepth, "capacity", cap(wp.queues[0]))
+}
+
+// depth returns the number of messages waiting in all worker queues.
+func (wp *workerPool) depth() int {
+	depth := 0
+	for _, queue := range wp.queues {
+		depth += len(queue)
+	}
+	return depth
+}
+
+// close processes the queued messages and stops the workers.
+func (wp *workerPool) close() {
+	for _, queue := range wp.queues {
+		close(queue)
+	}
+	wp.wg.Wait()
+}
+
+// setReadBuffer asks for a socket receive buffer (SO_RCVBUF) of size bytes,
+// so bursts are queued by the kernel instead of dropped while the read loop
+// catches up, and returns the size the kernel actually granted. Linux caps
+// the request at net.core.rmem_max and reports double the requested size to
+// account for its bookkeeping overhead.
+func setReadBuffer(conn *net.UDPConn, size int) (int, error) {
+	if err := conn.SetReadBuffer(size); err != nil {
+		return 0, err
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var effective int
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		effective, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return effective, sockErr
+}
+
+// serveUDP reads datagrams from conn until it is closed. Datagrams are
+// written to the handler's capture file, if any, as received. Messages are
+// handed to pool when it is set, otherwise they are processed on the read
+// loop.
+func serveUDP(conn *net.UDPConn, handler *logFileHandler, pool *workerPool) error {
+	buffer := make([]byte, 1024)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			slog.Error("Error reading UDP message", "error", err)
+			continue
+		}
+		if handler.capture != nil {
+			if err := handler.capture.record(buffer[:n], remoteAddr.String(), time.Now()); err != nil {
+				slog.Error("Error writing capture file", "error", err)
+			}
+		}
+		message := strings.TrimSpace(string(buffer[:n]))
+		if pool != nil {
+			pool.submit(message, remoteAddr.String())
+		} else {
+			handler.logMessage(message, remoteAddr.String())
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package syslog_server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/natefinch/lumberjack"
+)
+
+func TestWorkerPoolKeepsSourceOrder(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.config.MaxMessages = 1000
+	pool := newWorkerPool(handler, 4, 100, 0)
+	for i := 0; i < 50; i++ {
+		for _, source := range []string{"10.0.0.1:514", "10.0.0.2:514", "10.0.0.3:514"} {
+			pool.submit(fmt.Sprintf("<14>Jan 1 00:00:00 host app: %s %d", source, i), source)
+		}
+	}
+	pool.close()
+
+	messages := rawMessages(handler.messages)
+	if len(messages) != 150 {
+		t.Fatalf("expected 150 messages, got %d", len(messages))
+	}
+	next := map[string]int{}
+	for _, m := range messages {
+		var source string
+		var i int
+		if _, err := fmt.Sscanf(m, "<14>Jan 1 00:00:00 host app: %s %d", &source, &i); err != nil {
+			t.Fatal(err)
+		}
+		if i != next[source] {
+			t.Fatalf("message %d from %s out of order, expected %d", i, source, next[source])
+		}
+		next[source]++
+	}
+}
+
+func TestWorkerPoolDropsWhenFull(t *testing.T) {
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hold the handler lock so the worker cannot drain its queue.
+	handler.mu.Lock()
+	pool := newWorkerPool(handler, 1, 2, 0)
+	for i := 0; i < 10; i++ {
+		pool.submit("<14>Jan 1 00:00:00 host app: flood", "10.0.0.1:514")
+	}
+	handler.mu.Unlock()
+	pool.close()
+	// One message may be held by the worker in addition to the queue.
+	if dropped := pool.dropped.Load(); dropped < 7 || dropped > 8 {
+		t.Errorf("expected 7 or 8 dropped messages, got %d", dropped)
+	}
+}
+
+// slowDisk returns a FIFO that accepts about 4 MB/s and stalls for 200ms after
+// every 400 KB, standing in for a log file on a slow disk.
+func slowDisk(b *testing.B) string {
+	path := filepath.Join(b.TempDir(), "slow.log")
+	if err := syscall.Mkfifo(path, 0644); err != nil {
+		b.Skipf("mkfifo: %v", err)
+	}
+	// Opening read-write never blocks and never sees EOF between writers.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { f.Close() })
+	go func() {
+		buf := make([]byte, 4096)
+		for i := 1; ; i++ {
+			if _, err := f.Read(buf); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+			if i%100 == 0 {
+				time.Sleep(200 * time.Millisecond)
+			}
+		}
+	}()
+	return path
+}
+
+// BenchmarkUDPIngest sends bursts of datagrams to the UDP read loop while the
+// log file drains slowly and reports the share that was never processed.
+func BenchmarkUDPIngest(b *testing.B) {
+	for _, workers := range []int{0, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			handler, err := createLogFileHandler("", 10, "", "udp", 6)
+			if err != nil {
+				b.Fatal(err)
+			}
+			handler.disableLogging = false
+			handler.logger = &lumberjack.Logger{Filename: slowDisk(b)}
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Fatal(err)
+			}
+			var pool *workerPool
+			if workers > 0 {
+				pool = newWorkerPool(handler, workers, 10000, 0)
+			}
+			done := make(chan error)
+			go func() { done <- serveUDP(conn, handler, pool) }()
+
+			var senders []net.Conn
+			for i := 0; i < 8; i++ {
+				c, err := net.Dial("udp", conn.LocalAddr().String())
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer c.Close()
+				senders = append(senders, c)
+			}
+			msg := []byte("<14>Jan 1 00:00:00 bench-host bench-app: [INFO] a reasonably sized benchmark message body")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				senders[i%len(senders)].Write(msg)
+				if i%100 == 99 {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			time.Sleep(200 * time.Millisecond)
+			conn.Close()
+			<-done
+			if pool != nil {
+				pool.close()
+			}
+			handler.logger.Close()
+			b.StopTimer()
+			b.ReportMetric(100*float64(uint64(b.N)-handler.received.Load())/float64(b.N), "%dropped")
+		})
+	}
+}
+
+func TestWorkerPoolQueueDepthWarning(t *testing.T) {
+	var logs strings.Builder
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(prev)
+
+	handler, err := createLogFileHandler("", 10, "", "udp", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hold the handler lock so the worker cannot drain its queue.
+	handler.mu.Lock()
+	pool := newWorkerPool(handler, 1, 10, 8)
+	handler.workerPool = pool
+	for i := 0; i < 7; i++ {
+		pool.submit("<14>Jan 1 00:00:00 host app: burst", "10.0.0.1:514")
+	}
+	if strings.Contains(logs.String(), "Worker queue is filling up") {
+		t.Errorf("expected no warning below the threshold, got %q", logs.String())
+	}
+	for i := 0; i < 20; i++ {
+		pool.submit("<14>Jan 1 00:00:00 host app: burst", "10.0.0.1:514")
+	}
+	if n := strings.Count(logs.String(), "Worker queue is filling up"); n != 1 {
+		t.Errorf("expected one rate limited warning, got %d in %q", n, logs.String())
+	}
+
+	rec := httptest.NewRecorder()
+	statsHandler(handler)(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var stats map[string]int
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	// The worker may hold one message it took before blocking on the lock.
+	if stats["queueHighWater"] != 10 || stats["queueDepth"] < 9 || stats["workerDropped"] < 16 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+	rec = httptest.NewRecorder()
+	metricsHandler(handler)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "\nsyslog_queue_high_water 10\n") {
+		t.Errorf("expected the high-water mark in the metrics, got:\n%s", rec.Body)
+	}
+
+	handler.mu.Unlock()
+	pool.close()
+	if pool.depth() != 0 {
+		t.Errorf("expected the queue to drain, depth %d", pool.depth())
+	}
+}
+
+func TestSetReadBufferReducesDrops(t *testing.T) {
+	// flood sends 30000 messages to a socket with the given receive buffer
+	// before reading any of them, and returns how many were not dropped.
+	flood := func(size int) (effective, received int) {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if effective, err = setReadBuffer(conn, size); err != nil {
+			t.Fatal(err)
+		}
+		client, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		for i := 0; i < 30000; i++ {
+			fmt.Fprintf(client, "<14>Jan 1 00:00:00 host app: flood message %d", i)
+		}
+		return effective, len(readDatagrams(conn))
+	}
+
+	smallBuffer, small := flood(8 << 10)
+	largeBuffer, large := flood(4 << 20)
+	t.Logf("SO_RCVBUF %d kept %d of 30000 messages, SO_RCVBUF %d kept %d", smallBuffer, small, largeBuffer, large)
+	if largeBuffer <= smallBuffer {
+		t.Skipf("the kernel did not grant a larger buffer (%d <= %d), raise net.core.rmem_max", largeBuffer, smallBuffer)
+	}
+	if large <= small {
+		t.Errorf("expected the larger buffer to drop fewer messages, kept %d with %d bytes and %d with %d bytes",
+			large, largeBuffer, small, smallBuffer)
+	}
+}